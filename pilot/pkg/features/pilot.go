@@ -15,6 +15,7 @@
 package features
 
 import (
+	"strings"
 	"time"
 
 	"google.golang.org/protobuf/types/known/durationpb"
@@ -273,6 +274,75 @@ var (
 			"ENABLE_MCS_HOST also be enabled.").Get() &&
 		EnableMCSHost
 
+	MCSCanaryLabel = env.RegisterStringVar(
+		"PILOT_MCS_CANARY_LABEL",
+		"",
+		"If set, endpoints of a service exported via a Kubernetes Multi-Cluster "+
+			"Services (MCS) ServiceExport whose pods carry this label are only "+
+			"discoverable within the same cluster, while the other endpoints "+
+			"of the service are discoverable mesh-wide. Requires that "+
+			"ENABLE_MCS_SERVICE_DISCOVERY also be enabled.").Get()
+
+	MCSUnexportGracePeriod = env.RegisterDurationVar(
+		"PILOT_MCS_UNEXPORT_GRACE_PERIOD",
+		0,
+		"The time for which the endpoints of a service remain discoverable from other "+
+			"clusters after its Kubernetes Multi-Cluster Services (MCS) ServiceExport "+
+			"is deleted, giving the other clusters a chance to drain the connections "+
+			"to the service. Requires that ENABLE_MCS_SERVICE_DISCOVERY also be enabled.").Get()
+
+	MCSEndpointRemovalGracePeriod = env.RegisterDurationVar(
+		"PILOT_MCS_ENDPOINT_REMOVAL_GRACE_PERIOD",
+		0,
+		"The delay before the removal of endpoints of a service exported via a Kubernetes "+
+			"Multi-Cluster Services (MCS) ServiceExport affects the discoverability of the "+
+			"service, e.g. holding it cluster-local for lack of endpoints. This is usually "+
+			"shorter than PILOT_MCS_UNEXPORT_GRACE_PERIOD, as a single endpoint going away "+
+			"is common. Requires that ENABLE_MCS_SERVICE_DISCOVERY also be enabled.").Get()
+
+	MCSPreferOldestExport = env.RegisterBoolVar(
+		"PILOT_MCS_PREFER_OLDEST_EXPORT",
+		false,
+		"If enabled, conflicting Kubernetes Multi-Cluster Services (MCS) ServiceExports "+
+			"of a service, i.e. exports of services exposing different ports in different "+
+			"clusters, are resolved in favor of the export with the earliest creation "+
+			"timestamp: the endpoints of the newer exports are kept cluster-local. "+
+			"Requires that ENABLE_MCS_SERVICE_DISCOVERY also be enabled.").Get()
+
+	MCSDryRun = env.RegisterBoolVar(
+		"PILOT_MCS_DRY_RUN",
+		false,
+		"If enabled, Kubernetes Multi-Cluster Services (MCS) ServiceExports are processed "+
+			"and the discoverability they would give the endpoints of the exported services "+
+			"is logged and reported in their status, but it isn't applied: the endpoints "+
+			"remain cluster-local and no push is triggered by the exports. Requires that "+
+			"ENABLE_MCS_SERVICE_DISCOVERY also be enabled.").Get()
+
+	mcsNamespaceSamenessVar = env.RegisterStringVar(
+		"PILOT_MCS_NAMESPACE_SAMENESS",
+		"",
+		"A comma-separated list of <namespace>=<canonical namespace> mappings. Services "+
+			"exported and imported via Kubernetes Multi-Cluster Services (MCS) from a mapped "+
+			"namespace are given the clusterset.local host of the canonical namespace, which "+
+			"aligns services that live in differently named namespaces across clusters. "+
+			"Requires that ENABLE_MCS_HOST also be enabled.")
+
+	MCSNamespaceSameness = func() map[string]string {
+		out := make(map[string]string)
+		for _, mapping := range strings.Split(mcsNamespaceSamenessVar.Get(), ",") {
+			if mapping = strings.TrimSpace(mapping); mapping == "" {
+				continue
+			}
+			parts := strings.Split(mapping, "=")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				log.Warnf("ignoring invalid PILOT_MCS_NAMESPACE_SAMENESS mapping %q", mapping)
+				continue
+			}
+			out[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		return out
+	}()
+
 	EnableAnalysis = env.RegisterBoolVar(
 		"PILOT_ENABLE_ANALYSIS",
 		false,
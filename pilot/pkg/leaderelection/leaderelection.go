@@ -37,6 +37,9 @@ import (
 const (
 	NamespaceController     = "istio-namespace-controller-election"
 	ServiceExportController = "istio-serviceexport-controller-election"
+	// ServiceExportStatusController controls the status of the ServiceExports of a cluster, written by the
+	// istiods of the cluster.
+	ServiceExportStatusController = "istio-serviceexport-status-leader"
 	// This holds the legacy name to not conflict with older control plane deployments which are just
 	// doing the ingress syncing.
 	IngressController = "istio-leader"
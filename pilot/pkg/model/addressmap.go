@@ -28,6 +28,10 @@ type AddressMap struct {
 
 	// NOTE: The copystructure library is not able to copy unexported fields, so the mutex will not be copied.
 	mutex sync.RWMutex
+
+	// sharedKnown is true when shared holds the result of HasSharedAddress for the current addresses.
+	sharedKnown bool
+	shared      bool
 }
 
 func (m *AddressMap) IsEmpty() bool {
@@ -74,6 +78,7 @@ func (m *AddressMap) SetAddresses(addrs map[cluster.ID][]string) {
 
 	m.mutex.Lock()
 	m.Addresses = addrs
+	m.sharedKnown = false
 	m.mutex.Unlock()
 }
 
@@ -96,6 +101,7 @@ func (m *AddressMap) GetAddressesFor(c cluster.ID) []string {
 func (m *AddressMap) SetAddressesFor(c cluster.ID, addresses []string) *AddressMap {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	m.sharedKnown = false
 
 	if len(addresses) == 0 {
 		// Setting an empty array for the cluster. Remove the entry for the cluster if it exists.
@@ -124,6 +130,7 @@ func (m *AddressMap) AddAddressesFor(c cluster.ID, addresses []string) *AddressM
 
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	m.sharedKnown = false
 
 	// Create the map if nil.
 	if m.Addresses == nil {
@@ -150,3 +157,34 @@ func (m *AddressMap) ForEach(fn func(c cluster.ID, addresses []string)) {
 		fn(c, addresses)
 	}
 }
+
+// HasSharedAddress returns true if the same address is assigned in more than one cluster, e.g. a VIP valid across the
+// clusters of a flat network. The result is cached until the addresses change.
+func (m *AddressMap) HasSharedAddress() bool {
+	if m == nil {
+		return false
+	}
+
+	m.mutex.RLock()
+	known, shared := m.sharedKnown, m.shared
+	m.mutex.RUnlock()
+	if known {
+		return shared
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	seen := make(map[string]cluster.ID)
+	m.shared = false
+	for c, addresses := range m.Addresses {
+		for _, address := range addresses {
+			if other, ok := seen[address]; ok && other != c {
+				m.shared = true
+			}
+			seen[address] = c
+		}
+	}
+	m.sharedKnown = true
+	return m.shared
+}
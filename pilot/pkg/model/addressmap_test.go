@@ -162,3 +162,27 @@ func TestAddressMapForEach(t *testing.T) {
 	g.Expect(found[c1ID]).To(Equal(c1Addresses))
 	g.Expect(found[c2ID]).To(Equal(c2Addresses))
 }
+
+func TestAddressMapHasSharedAddress(t *testing.T) {
+	g := NewWithT(t)
+
+	m := model.AddressMap{}
+	g.Expect(m.HasSharedAddress()).To(BeFalse())
+
+	m.SetAddressesFor(c1ID, c1Addresses)
+	m.SetAddressesFor(c2ID, c2Addresses)
+	g.Expect(m.HasSharedAddress()).To(BeFalse())
+
+	// The cached result is invalidated when the addresses change.
+	m.AddAddressesFor(c2ID, []string{c1Addresses[0]})
+	g.Expect(m.HasSharedAddress()).To(BeTrue())
+
+	m.SetAddressesFor(c2ID, c2Addresses)
+	g.Expect(m.HasSharedAddress()).To(BeFalse())
+
+	m.SetAddresses(map[cluster.ID][]string{
+		c1ID: c1Addresses,
+		c2ID: c1Addresses,
+	})
+	g.Expect(m.HasSharedAddress()).To(BeTrue())
+}
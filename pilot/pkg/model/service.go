@@ -34,6 +34,7 @@ import (
 	"github.com/mitchellh/copystructure"
 
 	"istio.io/api/label"
+	networkingapi "istio.io/api/networking/v1alpha3"
 	"istio.io/istio/pilot/pkg/networking"
 	"istio.io/istio/pilot/pkg/serviceregistry/provider"
 	"istio.io/istio/pilot/pkg/util/sets"
@@ -430,6 +431,15 @@ type IstioEndpoint struct {
 
 	// Determines the discoverability of this endpoint throughout the mesh.
 	DiscoverabilityPolicy EndpointDiscoverabilityPolicy `json:"-"`
+
+	// ServiceExportGeneration is the generation of the Kubernetes Multi-Cluster Services (MCS) ServiceExport
+	// observed when the endpoint was built, or 0 if the service isn't exported. This is for debugging purpose.
+	ServiceExportGeneration int64
+
+	// ALPNHints are the ALPN protocols the endpoint is expected to negotiate, derived from the protocol of its
+	// service port when the service is exported with Kubernetes Multi-Cluster Services (MCS). Empty otherwise. They
+	// are sent to the proxies in the istio metadata of the endpoint (see util.AddALPNHintsMetadata).
+	ALPNHints []string
 }
 
 // GetLoadBalancingWeight returns the weight for this endpoint, normalized to always be > 0.
@@ -450,6 +460,27 @@ func (ep *IstioEndpoint) IsDiscoverableFromProxy(p *Proxy) bool {
 	return ep.DiscoverabilityPolicy.IsDiscoverableFromProxy(ep, p)
 }
 
+// DuplicateEndpointScope returns the scope, e.g. the cluster or the network of an endpoint, within which the endpoints
+// of the service with the same address and port are duplicates of each other. This is the case for the endpoints of a
+// cluster covered by overlapping ServiceExports, and for the endpoints reported by every cluster sharing a VIP in a
+// flat network. It returns nil if the endpoints of the service are never duplicates.
+func DuplicateEndpointScope(svc *Service) func(*IstioEndpoint) string {
+	if svc == nil {
+		return nil
+	}
+	if svc.ClusterVIPs.HasSharedAddress() {
+		return func(ep *IstioEndpoint) string {
+			return string(ep.Network)
+		}
+	}
+	if strings.HasSuffix(string(svc.Hostname), "."+constants.DefaultClusterSetLocalDomain) {
+		return func(ep *IstioEndpoint) string {
+			return ep.Locality.ClusterID.String()
+		}
+	}
+	return nil
+}
+
 // EndpointDiscoverabilityPolicy determines the discoverability of an endpoint throughout the mesh.
 type EndpointDiscoverabilityPolicy interface {
 	// IsDiscoverableFromProxy indicates whether an endpoint is discoverable from the given Proxy.
@@ -523,6 +554,16 @@ type ServiceAttributes struct {
 	// The port that the user provides in the meshNetworks config is the service port.
 	// We translate that to the appropriate node port here.
 	ClusterExternalPorts map[cluster.ID]map[uint32]uint32
+
+	// LoadBalancer is the simple load balancing policy to use for the service when a DestinationRule
+	// doesn't set one. Only set for the synthetic Kubernetes Multi-Cluster Services (MCS) service
+	// (i.e. clusterset.local), from the annotations of the ServiceExport.
+	LoadBalancer *networkingapi.LoadBalancerSettings_SimpleLB
+
+	// SourceNamespace is the namespace of the Kubernetes service that the synthetic Multi-Cluster Services (MCS)
+	// service (i.e. clusterset.local) was generated from, if Namespace was replaced by the canonical namespace of
+	// the service (see PILOT_MCS_NAMESPACE_SAMENESS). Empty otherwise.
+	SourceNamespace string
 }
 
 // DeepCopy creates a deep copy of ServiceAttributes, but skips internal mutexes.
@@ -612,6 +653,7 @@ type MCSServiceInfo struct {
 	Imported        bool
 	ClusterSetVIP   string
 	Discoverability map[host.Name]string
+	ExportedVIPs    []string
 }
 
 // GetNames returns port names
@@ -239,7 +239,7 @@ func (cb *ClusterBuilder) applyDestinationRule(mc *MutableCluster, clusterMode C
 	port *model.Port, proxyNetworkView map[network.ID]bool, destRule *config.Config, serviceAccounts []string) []*cluster.Cluster {
 	destinationRule := CastDestinationRule(destRule)
 	// merge applicable port level traffic policy settings
-	trafficPolicy := MergeTrafficPolicy(nil, destinationRule.GetTrafficPolicy(), port)
+	trafficPolicy := MergeTrafficPolicy(serviceTrafficPolicy(service), destinationRule.GetTrafficPolicy(), port)
 	opts := buildClusterOpts{
 		mesh:             cb.req.Push.Mesh,
 		serviceInstances: cb.serviceInstances,
@@ -289,6 +289,21 @@ func (cb *ClusterBuilder) applyMetadataExchange(c *cluster.Cluster) {
 	}
 }
 
+// serviceTrafficPolicy returns the default traffic policy set on the service itself, if any. It is
+// overridden by the traffic policy of a DestinationRule.
+func serviceTrafficPolicy(service *model.Service) *networking.TrafficPolicy {
+	if service == nil || service.Attributes.LoadBalancer == nil {
+		return nil
+	}
+	return &networking.TrafficPolicy{
+		LoadBalancer: &networking.LoadBalancerSettings{
+			LbPolicy: &networking.LoadBalancerSettings_Simple{
+				Simple: *service.Attributes.LoadBalancer,
+			},
+		},
+	}
+}
+
 // MergeTrafficPolicy returns the merged TrafficPolicy for a destination-level and subset-level policy on a given port.
 func MergeTrafficPolicy(original, subsetPolicy *networking.TrafficPolicy, port *model.Port) *networking.TrafficPolicy {
 	if subsetPolicy == nil {
@@ -593,6 +608,7 @@ func (cb *ClusterBuilder) buildLocalityLbEndpoints(proxyNetworkView map[network.
 		}
 		ep.Metadata = util.BuildLbEndpointMetadata(instance.Endpoint.Network, instance.Endpoint.TLSMode, instance.Endpoint.WorkloadName,
 			instance.Endpoint.Namespace, instance.Endpoint.Locality.ClusterID, instance.Endpoint.Labels)
+		ep.Metadata = util.AddALPNHintsMetadata(ep.Metadata, instance.Endpoint.ALPNHints)
 		locality := instance.Endpoint.Locality.Label
 		lbEndpoints[locality] = append(lbEndpoints[locality], ep)
 	}
@@ -513,6 +513,28 @@ func BuildLbEndpointMetadata(networkID network.ID, tlsMode, workloadname, namesp
 	return metadata
 }
 
+// AddALPNHintsMetadata adds the ALPN protocols an endpoint is expected to negotiate, e.g. the hints of the endpoints of
+// a service exported with Kubernetes Multi-Cluster Services (MCS), to the istio metadata of the endpoint under the
+// "alpn" key. The metadata is returned unchanged if there are no hints.
+func AddALPNHintsMetadata(metadata *core.Metadata, alpn []string) *core.Metadata {
+	if len(alpn) == 0 {
+		return metadata
+	}
+	if metadata == nil {
+		metadata = &core.Metadata{
+			FilterMetadata: map[string]*structpb.Struct{},
+		}
+	}
+	values := make([]*structpb.Value, 0, len(alpn))
+	for _, p := range alpn {
+		values = append(values, &structpb.Value{Kind: &structpb.Value_StringValue{StringValue: p}})
+	}
+	addIstioEndpointLabel(metadata, "alpn", &structpb.Value{
+		Kind: &structpb.Value_ListValue{ListValue: &structpb.ListValue{Values: values}},
+	})
+	return metadata
+}
+
 // MaybeApplyTLSModeLabel may or may not update the metadata for the Envoy transport socket matches for auto mTLS.
 func MaybeApplyTLSModeLabel(ep *endpoint.LbEndpoint, tlsMode string) (*endpoint.LbEndpoint, bool) {
 	if ep == nil || ep.Metadata == nil {
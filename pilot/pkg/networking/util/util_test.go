@@ -1260,6 +1260,27 @@ func TestEndpointMetadata(t *testing.T) {
 	}
 }
 
+func TestAddALPNHintsMetadata(t *testing.T) {
+	if got := AddALPNHintsMetadata(nil, nil); got != nil {
+		t.Errorf("Unexpected metadata for no ALPN hints: %v", got)
+	}
+
+	got := AddALPNHintsMetadata(BuildLbEndpointMetadata("", "istio", "", "", "", nil), ALPNH2Only)
+	want := &structpb.Value{
+		Kind: &structpb.Value_ListValue{
+			ListValue: &structpb.ListValue{
+				Values: []*structpb.Value{{Kind: &structpb.Value_StringValue{StringValue: "h2"}}},
+			},
+		},
+	}
+	if alpn := got.GetFilterMetadata()[IstioMetadataKey].GetFields()["alpn"]; !reflect.DeepEqual(alpn, want) {
+		t.Errorf("Unexpected ALPN metadata got %v, want %v", alpn, want)
+	}
+	if tlsMode := got.GetFilterMetadata()[EnvoyTransportSocketMetadataKey].GetFields()[model.TLSModeLabelShortname]; tlsMode.GetStringValue() != "istio" {
+		t.Errorf("Unexpected TLS mode metadata got %v, want istio", tlsMode)
+	}
+}
+
 func TestByteCount(t *testing.T) {
 	cases := []struct {
 		in  int
@@ -207,9 +207,40 @@ func (c *Controller) InstancesByPort(svc *model.Service, port int, labels labels
 	for _, r := range c.GetRegistries() {
 		instances = append(instances, r.InstancesByPort(svc, port, labels)...)
 	}
+	if scope := model.DuplicateEndpointScope(svc); scope != nil {
+		// Overlapping ServiceExports may cover the same endpoint of a cluster more than once and, in flat networks,
+		// the same VIP may be valid in several clusters, which then report the same endpoints. Count each endpoint
+		// only once.
+		instances = dedupInstances(instances, scope)
+	}
 	return instances
 }
 
+// dedupInstances removes the instances whose endpoint has the same address, port and scope as a previous one,
+// where the scope of an endpoint, e.g. its network or cluster, is returned by scope.
+func dedupInstances(instances []*model.ServiceInstance, scope func(*model.IstioEndpoint) string) []*model.ServiceInstance {
+	type endpointKey struct {
+		address string
+		port    uint32
+		scope   string
+	}
+	seen := make(map[endpointKey]bool, len(instances))
+	out := make([]*model.ServiceInstance, 0, len(instances))
+	for _, instance := range instances {
+		key := endpointKey{
+			address: instance.Endpoint.Address,
+			port:    instance.Endpoint.EndpointPort,
+			scope:   scope(instance.Endpoint),
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, instance)
+	}
+	return out
+}
+
 func nodeClusterID(node *model.Proxy) cluster.ID {
 	if node.Metadata == nil || node.Metadata.ClusterID == "" {
 		return ""
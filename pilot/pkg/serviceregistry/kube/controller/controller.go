@@ -18,11 +18,14 @@ import (
 	"fmt"
 	"net"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/yl2chen/cidranger"
+	"go.opencensus.io/metric"
+	"go.opencensus.io/metric/metricproducer"
 	"go.uber.org/atomic"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -74,8 +77,10 @@ const (
 var log = istiolog.RegisterScope("kube", "kubernetes service registry controller", 0)
 
 var (
-	typeTag  = monitoring.MustCreateLabel("type")
-	eventTag = monitoring.MustCreateLabel("event")
+	typeTag    = monitoring.MustCreateLabel("type")
+	eventTag   = monitoring.MustCreateLabel("event")
+	serviceTag = monitoring.MustCreateLabel("service")
+	clusterTag = monitoring.MustCreateLabel("cluster")
 
 	k8sEvents = monitoring.NewSum(
 		"pilot_k8s_reg_events",
@@ -94,18 +99,61 @@ var (
 		"pilot_k8s_endpoints_pending_pod",
 		"Number of endpoints that do not currently have any corresponding pods.",
 	)
+
+	// mcsMetrics holds the gauges whose label sets are removed once the service they describe is gone, which the
+	// gauges of the monitoring package don't support. The exporters read it like the other metrics.
+	mcsMetrics = metric.NewRegistry()
+
+	mcsServiceEndpoints = mustAddInt64Gauge(mcsMetrics,
+		"pilot_mcs_service_endpoints",
+		"Number of endpoints of each service exported via a Kubernetes Multi-Cluster Services (MCS) ServiceExport.",
+		"service", "cluster",
+	)
+
+	mcsServiceImportingClusters = monitoring.NewGauge(
+		"pilot_mcs_service_importing_clusters",
+		"Number of other clusters importing each service exported via a Kubernetes Multi-Cluster Services (MCS) ServiceExport.",
+		monitoring.WithLabels(serviceTag, clusterTag),
+	)
+
+	mcsClusterLastSync = monitoring.NewGauge(
+		"pilot_mcs_cluster_last_sync_seconds",
+		"Unix time, in seconds, of the last Kubernetes Multi-Cluster Services (MCS) update processed for each cluster.",
+		monitoring.WithLabels(clusterTag),
+	)
 )
 
+// mcsSyncClock returns the time recorded by pilot_mcs_cluster_last_sync_seconds. It is replaced in tests.
+var mcsSyncClock = time.Now
+
 func init() {
 	monitoring.MustRegister(k8sEvents)
 	monitoring.MustRegister(endpointsWithNoPods)
 	monitoring.MustRegister(endpointsPendingPodUpdate)
+	monitoring.MustRegister(mcsServiceImportingClusters)
+	monitoring.MustRegister(mcsClusterLastSync)
+	metricproducer.GlobalManager().AddProducer(mcsMetrics)
+}
+
+// mustAddInt64Gauge adds a gauge with the given label keys to the registry, panicking if it can't be added.
+func mustAddInt64Gauge(r *metric.Registry, name, description string, labelKeys ...string) *metric.Int64Gauge {
+	g, err := r.AddInt64Gauge(name, metric.WithDescription(description), metric.WithLabelKeys(labelKeys...))
+	if err != nil {
+		panic(fmt.Sprintf("failed adding metric %s: %v", name, err))
+	}
+	return g
 }
 
 func incrementEvent(kind, event string) {
 	k8sEvents.With(typeTag.Value(kind), eventTag.Value(event)).Increment()
 }
 
+// recordMCSSync records that an MCS update (i.e. a ServiceExport, a ServiceImport or the endpoints of an exported
+// service) was processed for the cluster, so that stale clusters can be alerted on.
+func recordMCSSync(c cluster.ID) {
+	mcsClusterLastSync.With(clusterTag.Value(c.String())).Record(float64(mcsSyncClock().Unix()))
+}
+
 // Options stores the configurable attributes of a Controller.
 type Options struct {
 	SystemNamespace string
@@ -123,6 +171,20 @@ type Options struct {
 	// and if it has a different alias we should use that a cluster ID for proxy.
 	ClusterAliases map[string]string
 
+	// ClusterGroups maps the names of groups of clusters (e.g. prod-east) to their members. A ServiceExport
+	// may restrict the discoverability of the service to the members of groups.
+	ClusterGroups map[string][]cluster.ID
+
+	// ClusterCapacities holds the relative capacity of clusters (e.g. 3 for a cluster three times the size of a
+	// cluster of capacity 1). The load balancing weights of the endpoints a cluster contributes to exported services
+	// are scaled by its capacity, so that traffic across the mesh is spread in proportion. Clusters without a
+	// capacity keep the default weights.
+	ClusterCapacities map[cluster.ID]uint32
+
+	// MCSResyncPeriod is the interval at which the discoverability of all of the exported services is recomputed,
+	// correcting any drift from missed events. Zero disables the periodic resync.
+	MCSResyncPeriod time.Duration
+
 	// Metrics for capturing node-based metrics.
 	Metrics model.Metrics
 
@@ -152,6 +214,10 @@ type Options struct {
 
 	// If meshConfig.DiscoverySelectors are specified, the DiscoveryNamespacesFilter tracks the namespaces this controller watches.
 	DiscoveryNamespacesFilter filter.DiscoveryNamespacesFilter
+
+	// hostnameOverrides indexes the clusterset.local hostname overrides of the exported services across the
+	// controllers of the mesh. A controller without it only knows the overrides of its own cluster.
+	hostnameOverrides *hostnameOverrideIndex
 }
 
 func (o Options) GetSyncInterval() time.Duration {
@@ -310,18 +376,19 @@ func NewController(kubeClient kubelib.Client, options Options) *Controller {
 		c.hostNamesForNamespacedName = func(name types.NamespacedName) []host.Name {
 			return []host.Name{
 				kube.ServiceHostname(name.Name, name.Namespace, c.opts.DomainSuffix),
-				serviceClusterSetLocalHostname(name),
+				c.exports.ClusterSetHostname(name),
 			}
 		}
 		c.servicesForNamespacedName = func(name types.NamespacedName) []*model.Service {
 			out := make([]*model.Service, 0, 2)
+			clusterSetHostname := c.exports.ClusterSetHostname(name)
 
 			c.RLock()
 			if svc := c.servicesMap[kube.ServiceHostname(name.Name, name.Namespace, c.opts.DomainSuffix)]; svc != nil {
 				out = append(out, svc)
 			}
 
-			if svc := c.servicesMap[serviceClusterSetLocalHostname(name)]; svc != nil {
+			if svc := c.servicesMap[clusterSetHostname]; svc != nil {
 				out = append(out, svc)
 			}
 			c.RUnlock()
@@ -427,6 +494,7 @@ func (c *Controller) MCSServices() []model.MCSServiceInfo {
 		mcsService.Namespace = se.namespacedName.Namespace
 		mcsService.Exported = true
 		mcsService.Discoverability = se.discoverability
+		mcsService.ExportedVIPs = se.vips
 	}
 
 	// Add the ServiceImport info.
@@ -573,7 +641,7 @@ func (c *Controller) addOrUpdateService(svc *v1.Service, svcConv *model.Service,
 	}
 
 	// instance conversion is only required when service is added/updated.
-	instances := kube.ExternalNameServiceInstances(svc, svcConv)
+	instances := c.externalNameServiceInstances(svc, svcConv)
 	c.Lock()
 	c.servicesMap[svcConv.Hostname] = svcConv
 	if len(instances) > 0 {
@@ -601,8 +669,37 @@ func (c *Controller) addOrUpdateService(svc *v1.Service, svcConv *model.Service,
 	c.handlers.NotifyServiceHandlers(svcConv, event)
 }
 
+// externalNameServiceInstances returns the instances of an ExternalName service, addressed by the external name.
+// The synthetic clusterset.local service has no Kubernetes Service of its own, so its instances are built from the
+// cluster.local one. The hostname is preserved rather than resolved in this cluster and the service uses STRICT_DNS
+// resolution, so that the importing clusters can do their own resolution.
+func (c *Controller) externalNameServiceInstances(svc *v1.Service, svcConv *model.Service) []*model.ServiceInstance {
+	if svc == nil && strings.HasSuffix(svcConv.Hostname.String(), mcsDomainSuffix) {
+		name := namespacedNameForService(svcConv)
+		k8sSvc, err := c.serviceLister.Services(name.Namespace).Get(name.Name)
+		if err != nil {
+			return nil
+		}
+		svc = k8sSvc
+	}
+
+	instances := kube.ExternalNameServiceInstances(svc, svcConv)
+	if len(instances) == 0 {
+		return nil
+	}
+	svcConv.Resolution = model.DNSLB
+
+	// The endpoints are discoverable according to the ServiceExport for the service, if any.
+	policy := c.exports.EndpointDiscoverabilityPolicy(svcConv)
+	for _, instance := range instances {
+		instance.Endpoint.DiscoverabilityPolicy = policy
+	}
+	return instances
+}
+
 func (c *Controller) buildEndpointsForService(svc *model.Service, updateCache bool) []*model.IstioEndpoint {
-	endpoints := c.endpoints.buildIstioEndpointsWithService(svc.Attributes.Name, svc.Attributes.Namespace, svc.Hostname, updateCache)
+	name := namespacedNameForService(svc)
+	endpoints := c.endpoints.buildIstioEndpointsWithService(name.Name, name.Namespace, svc.Hostname, updateCache)
 	if features.EnableK8SServiceSelectWorkloadEntries {
 		fep := c.collectWorkloadInstanceEndpoints(svc)
 		endpoints = append(endpoints, fep...)
@@ -838,6 +935,7 @@ func (c *Controller) Run(stop <-chan struct{}) {
 		log.Errorf("one or more errors force-syncing resources: %v", err)
 	}
 	c.initialSync.Store(true)
+	go c.exports.runResync(stop)
 	// after the in-order sync we can start processing the queue
 	c.queue.Run(stop)
 	log.Infof("Controller terminated")
@@ -870,6 +968,24 @@ func (c *Controller) GetService(hostname host.Name) *model.Service {
 	return svc
 }
 
+// endpointPortName returns the name of the port that endpoints of the service in this cluster use for svcPort.
+// A ClusterSet service is merged across clusters, so its port may be named differently from the local service;
+// the local name is found by port number.
+func (c *Controller) endpointPortName(svc *model.Service, svcPort *model.Port) string {
+	if !strings.HasSuffix(svc.Hostname.String(), mcsDomainSuffix) {
+		return svcPort.Name
+	}
+	name := namespacedNameForService(svc)
+	local := c.GetService(kube.ServiceHostname(name.Name, name.Namespace, c.opts.DomainSuffix))
+	if local == nil {
+		return svcPort.Name
+	}
+	if localPort, ok := local.Ports.GetByPort(svcPort.Port); ok {
+		return localPort.Name
+	}
+	return svcPort.Name
+}
+
 // getPodLocality retrieves the locality for a pod.
 func (c *Controller) getPodLocality(pod *v1.Pod) string {
 	// if pod has `istio-locality` label, skip below ops
@@ -906,6 +1022,17 @@ func (c *Controller) getPodLocality(pod *v1.Pod) string {
 
 // InstancesByPort implements a service catalog operation
 func (c *Controller) InstancesByPort(svc *model.Service, reqSvcPort int, labelsList labels.Collection) []*model.ServiceInstance {
+	// The services synthesized for the pods of exported headless services only select the endpoints of their pod.
+	if instances, ok := c.exports.PodInstancesByPort(svc, reqSvcPort, labelsList); ok {
+		return instances
+	}
+
+	// A clusterset.local service is merged across clusters, which may generate it from services in different
+	// namespaces (see ClusterSetNamespace), so the instances in this cluster are those of its own service.
+	if local := c.GetService(svc.Hostname); local != nil && namespacedNameForService(local) != namespacedNameForService(svc) {
+		svc = local
+	}
+
 	// First get k8s standard service instances and the workload entry instances
 	outInstances := c.endpoints.InstancesByPort(c, svc, reqSvcPort, labelsList)
 	outInstances = append(outInstances, c.serviceInstancesFromWorkloadInstances(svc, reqSvcPort)...)
@@ -951,11 +1078,12 @@ func (c *Controller) serviceInstancesFromWorkloadInstances(svc *model.Service, r
 	selector := labels.Instance(svc.Attributes.LabelSelectors)
 
 	// Get the service port name and target port so that we can construct the service instance
-	k8sService, err := c.serviceLister.Services(svc.Attributes.Namespace).Get(svc.Attributes.Name)
+	name := namespacedNameForService(svc)
+	k8sService, err := c.serviceLister.Services(name.Namespace).Get(name.Name)
 	// We did not find the k8s service. We cannot get the targetPort
 	if err != nil {
 		log.Infof("serviceInstancesFromWorkloadInstances(%s.%s) failed to get k8s service => error %v",
-			svc.Attributes.Name, svc.Attributes.Namespace, err)
+			name.Name, name.Namespace, err)
 		return nil
 	}
 
@@ -976,11 +1104,17 @@ func (c *Controller) serviceInstancesFromWorkloadInstances(svc *model.Service, r
 		targetPort = reqSvcPort
 	}
 
+	// The workload entries are discoverable like the pods selected by the service, i.e. across the mesh only
+	// once the service is exported.
+	discoverabilityPolicy := c.exports.EndpointDiscoverabilityPolicy(svc)
+	exportGeneration := c.exports.ExportGeneration(svc)
+	alpnHints := c.exports.EndpointALPNHints(svc)
+
 	out := make([]*model.ServiceInstance, 0)
 
 	c.RLock()
 	for _, wi := range c.workloadInstancesByIP {
-		if wi.Namespace != svc.Attributes.Namespace {
+		if wi.Namespace != name.Namespace {
 			continue
 		}
 		if selector.SubsetOf(wi.Endpoint.Labels) {
@@ -1002,6 +1136,9 @@ func (c *Controller) serviceInstancesFromWorkloadInstances(svc *model.Service, r
 			}
 
 			istioEndpoint.ServicePortName = servicePort.Name
+			istioEndpoint.DiscoverabilityPolicy = discoverabilityPolicy
+			istioEndpoint.ServiceExportGeneration = exportGeneration
+			istioEndpoint.ALPNHints = alpnHints[servicePort.Name]
 			out = append(out, &model.ServiceInstance{
 				Service:     svc,
 				ServicePort: servicePort,
@@ -1156,29 +1293,32 @@ func (c *Controller) WorkloadInstanceHandler(si *model.WorkloadInstance, event m
 	// find the services that map to this workload entry, fire off eds updates if the service is of type client-side lb
 	if k8sServices, err := getPodServices(c.serviceLister, dummyPod); err == nil && len(k8sServices) > 0 {
 		for _, k8sSvc := range k8sServices {
-			service := c.GetService(kube.ServiceHostname(k8sSvc.Name, k8sSvc.Namespace, c.opts.DomainSuffix))
-			// Note that this cannot be an external service because k8s external services do not have label selectors.
-			if service == nil || service.Resolution != model.ClientSideLB {
-				// may be a headless service
-				continue
-			}
-
-			// Get the updated list of endpoints that includes k8s pods and the workload entries for this service
-			// and then notify the EDS server that endpoints for this service have changed.
-			// We need one endpoint object for each service port
-			endpoints := make([]*model.IstioEndpoint, 0)
-			for _, port := range service.Ports {
-				if port.Protocol == protocol.UDP {
+			// Include the clusterset.local host, if any, so that the workload entries of exported services are
+			// discoverable from other clusters.
+			for _, service := range c.servicesForNamespacedName(kube.NamespacedNameForK8sObject(k8sSvc)) {
+				// Note that this cannot be an external service because k8s external services do not have label selectors.
+				if service.Resolution != model.ClientSideLB {
+					// may be a headless service
 					continue
 				}
-				// Similar code as UpdateServiceShards in eds.go
-				instances := c.InstancesByPort(service, port.Port, labels.Collection{})
-				for _, inst := range instances {
-					endpoints = append(endpoints, inst.Endpoint)
+
+				// Get the updated list of endpoints that includes k8s pods and the workload entries for this service
+				// and then notify the EDS server that endpoints for this service have changed.
+				// We need one endpoint object for each service port
+				endpoints := make([]*model.IstioEndpoint, 0)
+				for _, port := range service.Ports {
+					if port.Protocol == protocol.UDP {
+						continue
+					}
+					// Similar code as UpdateServiceShards in eds.go
+					instances := c.InstancesByPort(service, port.Port, labels.Collection{})
+					for _, inst := range instances {
+						endpoints = append(endpoints, inst.Endpoint)
+					}
 				}
+				// fire off eds update
+				c.opts.XDSUpdater.EDSUpdate(shard, string(service.Hostname), service.Attributes.Namespace, endpoints)
 			}
-			// fire off eds update
-			c.opts.XDSUpdater.EDSUpdate(shard, string(service.Hostname), service.Attributes.Namespace, endpoints)
 		}
 	}
 }
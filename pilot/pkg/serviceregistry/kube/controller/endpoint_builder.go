@@ -39,6 +39,13 @@ type EndpointBuilder struct {
 	workloadName   string
 	namespace      string
 
+	// Attributes derived from the ServiceExport of the service, if it's exported.
+	lbWeight         uint32
+	exportGeneration int64
+	alpnHints        map[string][]string
+	// clusterLocal keeps the endpoints within the cluster, whatever the discoverability policy of the service.
+	clusterLocal bool
+
 	// Values used to build dns name tables per pod.
 	// The the hostname of the Pod, by default equals to pod name.
 	hostname string
@@ -118,20 +125,39 @@ func (b *EndpointBuilder) buildIstioEndpoint(
 		b.labels[label.TopologyNetwork.Name] = string(networkID)
 	}
 
+	if b.clusterLocal {
+		discoverabilityPolicy = model.DiscoverableFromSameCluster
+	}
+
 	return &model.IstioEndpoint{
-		Labels:                b.labels,
-		ServiceAccount:        b.serviceAccount,
-		Locality:              b.locality,
-		TLSMode:               b.tlsMode,
-		Address:               endpointAddress,
-		EndpointPort:          uint32(endpointPort),
-		ServicePortName:       svcPortName,
-		Network:               networkID,
-		WorkloadName:          b.workloadName,
-		Namespace:             b.namespace,
-		HostName:              b.hostname,
-		SubDomain:             b.subDomain,
-		DiscoverabilityPolicy: discoverabilityPolicy,
+		Labels:                  b.labels,
+		ServiceAccount:          b.serviceAccount,
+		Locality:                b.locality,
+		TLSMode:                 b.tlsMode,
+		Address:                 endpointAddress,
+		EndpointPort:            uint32(endpointPort),
+		LbWeight:                b.lbWeight,
+		ServicePortName:         svcPortName,
+		Network:                 networkID,
+		WorkloadName:            b.workloadName,
+		Namespace:               b.namespace,
+		HostName:                b.hostname,
+		SubDomain:               b.subDomain,
+		DiscoverabilityPolicy:   discoverabilityPolicy,
+		ServiceExportGeneration: b.exportGeneration,
+		ALPNHints:               b.alpnHints[svcPortName],
+	}
+}
+
+// withExport sets the attributes of the endpoints that derive from the ServiceExport of the given service, if any,
+// for the endpoints of the service backed by the pod.
+func (b *EndpointBuilder) withExport(exports serviceExportCache, svc *model.Service, pod *v1.Pod) {
+	b.lbWeight = exports.EndpointLbWeight(svc, pod)
+	b.exportGeneration = exports.ExportGeneration(svc)
+	b.alpnHints = exports.EndpointALPNHints(svc)
+	b.clusterLocal = !exports.PodExportable(pod)
+	if exports.ExportsTLSMode(svc) {
+		b.labels[label.SecurityTlsMode.Name] = b.tlsMode
 	}
 }
 
@@ -15,6 +15,8 @@
 package controller
 
 import (
+	"strings"
+
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -117,7 +119,13 @@ func updateEDS(c *Controller, epc kubeEndpointsController, ep interface{}, event
 			}
 		}
 
+		if strings.HasSuffix(hostName.String(), mcsDomainSuffix) {
+			// The endpoints of the clusterset.local host are merged across clusters under its canonical namespace.
+			c.opts.XDSUpdater.EDSUpdate(shard, string(hostName), c.exports.ClusterSetNamespace(namespacedName), endpoints)
+			continue
+		}
 		c.opts.XDSUpdater.EDSUpdate(shard, string(hostName), namespacedName.Namespace, endpoints)
+		c.exports.EndpointsUpdated(namespacedName, endpointAddressCount(endpoints))
 	}
 }
 
@@ -106,9 +106,10 @@ func endpointServiceInstances(c *Controller, endpoints *v1.Endpoints, proxy *mod
 }
 
 func (e *endpointsController) InstancesByPort(c *Controller, svc *model.Service, reqSvcPort int, labelsList labels.Collection) []*model.ServiceInstance {
-	item, exists, err := e.informer.GetIndexer().GetByKey(kube.KeyFunc(svc.Attributes.Name, svc.Attributes.Namespace))
+	name := namespacedNameForService(svc)
+	item, exists, err := e.informer.GetIndexer().GetByKey(kube.KeyFunc(name.Name, name.Namespace))
 	if err != nil {
-		log.Infof("get endpoints(%s, %s) => error %v", svc.Attributes.Name, svc.Attributes.Namespace, err)
+		log.Infof("get endpoints(%s, %s) => error %v", name.Name, name.Namespace, err)
 		return nil
 	}
 	if !exists {
@@ -122,6 +123,7 @@ func (e *endpointsController) InstancesByPort(c *Controller, svc *model.Service,
 	if !exists {
 		return nil
 	}
+	portName := c.endpointPortName(svc, svcPort)
 	ep := item.(*v1.Endpoints)
 	var out []*model.ServiceInstance
 	for _, ss := range ep.Subsets {
@@ -140,11 +142,12 @@ func (e *endpointsController) InstancesByPort(c *Controller, svc *model.Service,
 			}
 
 			builder := NewEndpointBuilder(c, pod)
+			builder.withExport(c.exports, svc, pod)
 
 			// identify the port by name. K8S EndpointPort uses the service port name
 			for _, port := range ss.Ports {
 				if port.Name == "" || // 'name optional if single port is defined'
-					svcPort.Name == port.Name {
+					portName == port.Name {
 					istioEndpoint := builder.buildIstioEndpoint(ea.IP, port.Port, svcPort.Name, discoverabilityPolicy)
 					out = append(out, &model.ServiceInstance{
 						Endpoint:    istioEndpoint,
@@ -196,7 +199,8 @@ func (e *endpointsController) buildIstioEndpoints(endpoint interface{}, host hos
 	var endpoints []*model.IstioEndpoint
 	ep := endpoint.(*v1.Endpoints)
 
-	discoverabilityPolicy := e.c.exports.EndpointDiscoverabilityPolicy(e.c.GetService(host))
+	svc := e.c.GetService(host)
+	discoverabilityPolicy := e.c.exports.EndpointDiscoverabilityPolicy(svc)
 
 	for _, ss := range ep.Subsets {
 		for _, ea := range ss.Addresses {
@@ -205,6 +209,7 @@ func (e *endpointsController) buildIstioEndpoints(endpoint interface{}, host hos
 				continue
 			}
 			builder := NewEndpointBuilder(e.c, pod)
+			builder.withExport(e.c.exports, svc, pod)
 
 			// EDS and ServiceEntry use name for service port - ADS will need to map to numbers.
 			for _, port := range ss.Ports {
@@ -216,7 +216,8 @@ func (esc *endpointSliceController) updateEndpointCacheForSlice(hostName host.Na
 	var endpoints []*model.IstioEndpoint
 	slice := wrapEndpointSlice(ep)
 
-	discoverabilityPolicy := esc.c.exports.EndpointDiscoverabilityPolicy(esc.c.GetService(hostName))
+	svc := esc.c.GetService(hostName)
+	discoverabilityPolicy := esc.c.exports.EndpointDiscoverabilityPolicy(svc)
 
 	for _, e := range slice.Endpoints() {
 		if e.Conditions.Ready != nil && !*e.Conditions.Ready {
@@ -229,6 +230,7 @@ func (esc *endpointSliceController) updateEndpointCacheForSlice(hostName host.Na
 				continue
 			}
 			builder := esc.newEndpointBuilder(pod)
+			builder.withExport(esc.c.exports, svc, pod)
 			// EDS and ServiceEntry use name for service port - ADS will need to map to numbers.
 			for _, port := range slice.Ports() {
 				var portNum int32
@@ -276,7 +278,7 @@ func (esc *endpointSliceController) getServiceNamespacedName(es interface{}) typ
 
 func (esc *endpointSliceController) InstancesByPort(c *Controller, svc *model.Service, reqSvcPort int, labelsList labels.Collection) []*model.ServiceInstance {
 	esLabelSelector := endpointSliceSelectorForService(svc.Attributes.Name)
-	slices, err := esc.listSlices(svc.Attributes.Namespace, esLabelSelector)
+	slices, err := esc.listSlices(namespacedNameForService(svc).Namespace, esLabelSelector)
 	if err != nil {
 		log.Infof("get endpoints(%s, %s) => error %v", svc.Attributes.Name, svc.Attributes.Namespace, err)
 		return nil
@@ -292,6 +294,7 @@ func (esc *endpointSliceController) InstancesByPort(c *Controller, svc *model.Se
 	}
 
 	discoverabilityPolicy := c.exports.EndpointDiscoverabilityPolicy(svc)
+	portName := c.endpointPortName(svc, svcPort)
 
 	var out []*model.ServiceInstance
 	for _, es := range slices {
@@ -312,6 +315,7 @@ func (esc *endpointSliceController) InstancesByPort(c *Controller, svc *model.Se
 				}
 
 				builder := esc.newEndpointBuilder(pod)
+				builder.withExport(c.exports, svc, pod)
 				// identify the port by name. K8S EndpointPort uses the service port name
 				for _, port := range slice.Ports() {
 					var portNum int32
@@ -320,7 +324,7 @@ func (esc *endpointSliceController) InstancesByPort(c *Controller, svc *model.Se
 					}
 
 					if port.Name == nil ||
-						svcPort.Name == *port.Name {
+						portName == *port.Name {
 						istioEndpoint := builder.buildIstioEndpoint(a, portNum, svcPort.Name, discoverabilityPolicy)
 						out = append(out, &model.ServiceInstance{
 							Endpoint:    istioEndpoint,
@@ -15,6 +15,9 @@
 package controller
 
 import (
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"k8s.io/client-go/tools/cache"
@@ -36,6 +39,10 @@ const (
 type FakeXdsUpdater struct {
 	// Events tracks notifications received by the updater
 	Events chan FakeXdsEvent
+
+	mu sync.Mutex
+	// pushed holds the endpoints of the last EDS push for each hostname, keyed by address and port.
+	pushed map[string]map[string]*model.IstioEndpoint
 }
 
 var _ model.XDSUpdater = &FakeXdsUpdater{}
@@ -70,6 +77,11 @@ type FakeXdsEvent struct {
 
 	// The endpoints associated with an EDS push if any
 	Endpoints []*model.IstioEndpoint
+
+	// AddedEndpoints and RemovedEndpoints are the changes to the endpoints of an EDS push since
+	// the previous push for the same hostname.
+	AddedEndpoints   []*model.IstioEndpoint
+	RemovedEndpoints []*model.IstioEndpoint
 }
 
 // NewFakeXDS creates a XdsUpdater reporting events via a channel.
@@ -80,21 +92,64 @@ func NewFakeXDS() *FakeXdsUpdater {
 }
 
 func (fx *FakeXdsUpdater) EDSUpdate(_ model.ShardKey, hostname string, _ string, entry []*model.IstioEndpoint) {
-	if len(entry) > 0 {
-		select {
-		case fx.Events <- FakeXdsEvent{Type: "eds", ID: hostname, Endpoints: entry}:
-		default:
-		}
+	fx.edsEvent("eds", hostname, entry)
+}
+
+// edsEvent reports an EDS push with the changes since the previous push for the hostname. A push
+// without endpoints is only reported when it removes the previously pushed ones.
+func (fx *FakeXdsUpdater) edsEvent(et, hostname string, entry []*model.IstioEndpoint) {
+	added, removed := fx.trackEndpoints(hostname, entry)
+	if len(entry) == 0 && len(removed) == 0 {
+		return
+	}
+	select {
+	case fx.Events <- FakeXdsEvent{Type: et, ID: hostname, Endpoints: entry, AddedEndpoints: added, RemovedEndpoints: removed}:
+	default:
 	}
 }
 
-func (fx *FakeXdsUpdater) EDSCacheUpdate(_ model.ShardKey, hostname, _ string, entry []*model.IstioEndpoint) {
-	if len(entry) > 0 {
-		select {
-		case fx.Events <- FakeXdsEvent{Type: "eds cache", ID: hostname, Endpoints: entry}:
-		default:
+// trackEndpoints records the endpoints pushed for the hostname and returns the changes since the previous push.
+func (fx *FakeXdsUpdater) trackEndpoints(hostname string, entry []*model.IstioEndpoint) (added, removed []*model.IstioEndpoint) {
+	current := make(map[string]*model.IstioEndpoint, len(entry))
+	for _, ep := range entry {
+		current[ep.Address+":"+strconv.Itoa(int(ep.EndpointPort))] = ep
+	}
+
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+	if fx.pushed == nil {
+		fx.pushed = make(map[string]map[string]*model.IstioEndpoint)
+	}
+	previous := fx.pushed[hostname]
+	fx.pushed[hostname] = current
+
+	for key, ep := range current {
+		if _, found := previous[key]; !found {
+			added = append(added, ep)
+		}
+	}
+	for key, ep := range previous {
+		if _, found := current[key]; !found {
+			removed = append(removed, ep)
 		}
 	}
+	sortEndpoints(added)
+	sortEndpoints(removed)
+	return added, removed
+}
+
+// sortEndpoints orders the endpoints by address and port so that the reported changes are deterministic.
+func sortEndpoints(endpoints []*model.IstioEndpoint) {
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Address != endpoints[j].Address {
+			return endpoints[i].Address < endpoints[j].Address
+		}
+		return endpoints[i].EndpointPort < endpoints[j].EndpointPort
+	})
+}
+
+func (fx *FakeXdsUpdater) EDSCacheUpdate(_ model.ShardKey, hostname, _ string, entry []*model.IstioEndpoint) {
+	fx.edsEvent("eds cache", hostname, entry)
 }
 
 // SvcUpdate is called when a service port mapping definition is updated.
@@ -149,6 +204,21 @@ type FakeControllerOptions struct {
 	DomainSuffix              string
 	XDSUpdater                model.XDSUpdater
 	DiscoveryNamespacesFilter filter.DiscoveryNamespacesFilter
+	ClusterGroups             map[string][]cluster.ID
+	ClusterCapacities         map[cluster.ID]uint32
+	MCSResyncPeriod           time.Duration
+
+	// WriteMCSStatus makes the fake controller write the status of the ServiceExports in its cluster, as if it was
+	// elected to do so (see serviceExportCache.runStatusWriter).
+	WriteMCSStatus bool
+
+	// MeshServiceController is the aggregate controller the fake controller is added to. If it is shared by
+	// several fake controllers, the caller is responsible for running it. Otherwise, a new one is created and run.
+	MeshServiceController *aggregate.Controller
+
+	// hostnameOverrides is the index of the clusterset.local hostname overrides shared by the fake controllers of
+	// a mesh.
+	hostnameOverrides *hostnameOverrideIndex
 
 	// when calling from NewFakeDiscoveryServer, we wait for the aggregate cache to sync. Waiting here can cause deadlock.
 	SkipCacheSyncWait bool
@@ -176,7 +246,10 @@ func NewFakeControllerWithOptions(opts FakeControllerOptions) (*FakeController,
 		opts.MeshWatcher = mesh.NewFixedWatcher(&meshconfig.MeshConfig{})
 	}
 
-	meshServiceController := aggregate.NewController(aggregate.Options{MeshHolder: opts.MeshWatcher})
+	meshServiceController := opts.MeshServiceController
+	if meshServiceController == nil {
+		meshServiceController = aggregate.NewController(aggregate.Options{MeshHolder: opts.MeshWatcher})
+	}
 
 	options := Options{
 		DomainSuffix:              domainSuffix,
@@ -189,6 +262,10 @@ func NewFakeControllerWithOptions(opts FakeControllerOptions) (*FakeController,
 		SyncInterval:              time.Microsecond,
 		DiscoveryNamespacesFilter: opts.DiscoveryNamespacesFilter,
 		MeshServiceController:     meshServiceController,
+		ClusterGroups:             opts.ClusterGroups,
+		ClusterCapacities:         opts.ClusterCapacities,
+		MCSResyncPeriod:           opts.MCSResyncPeriod,
+		hostnameOverrides:         opts.hostnameOverrides,
 	}
 	c := NewController(opts.Client, options)
 	meshServiceController.AddRegistry(c)
@@ -202,8 +279,13 @@ func NewFakeControllerWithOptions(opts FakeControllerOptions) (*FakeController,
 	}
 	// Run in initiation to prevent calling each test
 	// TODO: fix it, so we can remove `stop` channel
-	go meshServiceController.Run(c.stop)
+	if opts.MeshServiceController == nil {
+		go meshServiceController.Run(c.stop)
+	}
 	opts.Client.RunAndWait(c.stop)
+	if opts.WriteMCSStatus {
+		go c.exports.runStatusWriter(c.stop)
+	}
 	if !opts.SkipCacheSyncWait {
 		// Wait for the caches to sync, otherwise we may hit race conditions where events are dropped
 		cache.WaitForCacheSync(c.stop, c.HasSynced)
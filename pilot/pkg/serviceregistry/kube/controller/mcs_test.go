@@ -0,0 +1,777 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	kubeMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	mcs "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/pkg/features"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/serviceregistry/aggregate"
+	"istio.io/istio/pilot/pkg/serviceregistry/kube"
+	"istio.io/istio/pkg/cluster"
+	"istio.io/istio/pkg/config/host"
+	"istio.io/istio/pkg/config/mesh"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+// fakeClusterSet is a set of fake controllers, one per cluster, added to a shared aggregate mesh registry. It plays
+// the part of the MCS controller: a ServiceExport in any of the clusters results in a ServiceImport in all of them,
+// which exercises the export and import caches together.
+type fakeClusterSet struct {
+	mesh     *aggregate.Controller
+	clusters map[cluster.ID]*FakeController
+
+	mu   sync.Mutex
+	vips map[types.NamespacedName]string
+}
+
+func newFakeClusterSet(t *testing.T, mode EndpointMode, clusterIDs ...cluster.ID) *fakeClusterSet {
+	t.Helper()
+
+	stop := make(chan struct{})
+	prevEnableMCSServiceDiscovery := features.EnableMCSServiceDiscovery
+	features.EnableMCSServiceDiscovery = true
+	prevEnableMCSClusterLocal := features.EnableMCSClusterLocal
+	features.EnableMCSClusterLocal = false
+	t.Cleanup(func() {
+		close(stop)
+		features.EnableMCSServiceDiscovery = prevEnableMCSServiceDiscovery
+		features.EnableMCSClusterLocal = prevEnableMCSClusterLocal
+	})
+
+	meshWatcher := mesh.NewFixedWatcher(&meshconfig.MeshConfig{})
+	cs := &fakeClusterSet{
+		mesh:     aggregate.NewController(aggregate.Options{MeshHolder: meshWatcher}),
+		clusters: make(map[cluster.ID]*FakeController),
+		vips:     make(map[types.NamespacedName]string),
+	}
+	hostnameOverrides := newHostnameOverrideIndex()
+	for _, clusterID := range clusterIDs {
+		c, _ := NewFakeControllerWithOptions(FakeControllerOptions{
+			ClusterID:             clusterID,
+			Mode:                  mode,
+			MeshWatcher:           meshWatcher,
+			MeshServiceController: cs.mesh,
+			Stop:                  stop,
+			WriteMCSStatus:        true,
+			hostnameOverrides:     hostnameOverrides,
+		})
+		cs.clusters[clusterID] = c
+	}
+	go cs.mesh.Run(stop)
+
+	// Import every exported service into all of the clusters.
+	for _, c := range cs.clusters {
+		c.client.MCSApisInformer().Multicluster().V1alpha1().ServiceExports().Informer().AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					cs.importService(t, obj.(*mcs.ServiceExport))
+				},
+			})
+	}
+	return cs
+}
+
+// importService creates a ServiceImport for the exported service in all of the clusters, with a ClusterSet VIP
+// allocated for the service.
+func (cs *fakeClusterSet) importService(t *testing.T, se *mcs.ServiceExport) {
+	name := types.NamespacedName{Namespace: se.Namespace, Name: se.Name}
+	cs.mu.Lock()
+	vip, ok := cs.vips[name]
+	if !ok {
+		vip = fmt.Sprintf("240.240.0.%d", len(cs.vips)+1)
+		cs.vips[name] = vip
+	}
+	cs.mu.Unlock()
+
+	for clusterID, c := range cs.clusters {
+		si := &mcs.ServiceImport{
+			ObjectMeta: kubeMeta.ObjectMeta{Name: se.Name, Namespace: se.Namespace},
+			Spec: mcs.ServiceImportSpec{
+				Type: mcs.ClusterSetIP,
+				IPs:  []string{vip},
+			},
+		}
+		_, err := c.client.MCSApis().MulticlusterV1alpha1().ServiceImports(se.Namespace).Create(
+			context.TODO(), si, kubeMeta.CreateOptions{})
+		if err != nil && !errors.IsAlreadyExists(err) {
+			// Called from an informer, so the test can't be failed directly.
+			t.Errorf("failed importing %s into cluster %s: %v", name, clusterID, err)
+		}
+	}
+}
+
+func (cs *fakeClusterSet) vip(name types.NamespacedName) string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.vips[name]
+}
+
+func TestServiceExportedAndImportedAcrossClusters(t *testing.T) {
+	const clusterA, clusterB cluster.ID = "cluster-a", "cluster-b"
+	cs := newFakeClusterSet(t, EndpointSliceOnly, clusterA, clusterB)
+	a := cs.clusters[clusterA]
+
+	// Create the service in cluster A only and export it.
+	createService(a, serviceExportName, serviceExportNamespace, nil,
+		[]int32{8080}, map[string]string{"app": "prod-app"}, t)
+	createEndpoints(t, a, serviceExportName, serviceExportNamespace, []string{"tcp-port"}, []string{serviceExportPodIP}, nil, nil)
+	if _, err := a.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+		context.TODO(), newServiceExport(), kubeMeta.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	proxyInClusterB := &model.Proxy{
+		Metadata: &model.NodeMetadata{ClusterID: clusterB},
+	}
+	clusterSetHost := serviceClusterSetLocalHostname(serviceExportNamespacedName)
+	retry.UntilSuccessOrFail(t, func() error {
+		svc := cs.mesh.GetService(clusterSetHost)
+		if svc == nil {
+			return fmt.Errorf("failed to find service %s", clusterSetHost)
+		}
+
+		// The service is imported into cluster B with the ClusterSet VIP.
+		vip := cs.vip(serviceExportNamespacedName)
+		if vips := svc.ClusterVIPs.GetAddressesFor(clusterB); len(vips) != 1 || vips[0] != vip {
+			return fmt.Errorf("expected VIP %s in cluster %s, found %v", vip, clusterB, vips)
+		}
+
+		// The endpoint in cluster A is discoverable from proxies in cluster B.
+		instances := cs.mesh.InstancesByPort(svc, 8080, nil)
+		if len(instances) != 1 {
+			return fmt.Errorf("expected 1 instance, found %d", len(instances))
+		}
+		ep := instances[0].Endpoint
+		if ep.Address != serviceExportPodIP || ep.Locality.ClusterID != clusterA {
+			return fmt.Errorf("unexpected endpoint %s in cluster %s", ep.Address, ep.Locality.ClusterID)
+		}
+		if !ep.IsDiscoverableFromProxy(proxyInClusterB) {
+			return fmt.Errorf("endpoint was not discoverable from cluster %s", clusterB)
+		}
+		return nil
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportedFromClustersWithSameVIP(t *testing.T) {
+	const clusterA, clusterB cluster.ID = "cluster-a", "cluster-b"
+	cs := newFakeClusterSet(t, EndpointSliceOnly, clusterA, clusterB)
+
+	// In a flat network, both clusters define the service with the same VIP (see createService) and report an
+	// endpoint in common.
+	sharedIP := "128.0.0.3"
+	endpointsByCluster := map[cluster.ID][]string{
+		clusterA: {"128.0.0.2", sharedIP},
+		clusterB: {sharedIP, "128.0.0.4"},
+	}
+	for clusterID, ips := range endpointsByCluster {
+		c := cs.clusters[clusterID]
+		createService(c, serviceExportName, serviceExportNamespace, nil,
+			[]int32{8080}, map[string]string{"app": "prod-app"}, t)
+		createEndpoints(t, c, serviceExportName, serviceExportNamespace, []string{"tcp-port"}, ips, nil, nil)
+		if _, err := c.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+			context.TODO(), newServiceExport(), kubeMeta.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clusterLocalHost := kube.ServiceHostname(serviceExportName, serviceExportNamespace, defaultFakeDomainSuffix)
+	retry.UntilSuccessOrFail(t, func() error {
+		// The exports record the VIP in each cluster.
+		exportedVIPs := make(map[cluster.ID][]string)
+		for _, info := range cs.mesh.MCSServices() {
+			if info.Exported {
+				exportedVIPs[info.Cluster] = info.ExportedVIPs
+			}
+		}
+		for _, clusterID := range []cluster.ID{clusterA, clusterB} {
+			if vips := exportedVIPs[clusterID]; len(vips) != 1 || vips[0] != "10.0.0.1" {
+				return fmt.Errorf("expected exported VIP 10.0.0.1 in cluster %s, found %v", clusterID, vips)
+			}
+		}
+
+		// A single logical service, with the endpoint in common counted once.
+		svc := cs.mesh.GetService(clusterLocalHost)
+		if svc == nil {
+			return fmt.Errorf("failed to find service %s", clusterLocalHost)
+		}
+		addresses := make(map[string]int)
+		for _, instance := range cs.mesh.InstancesByPort(svc, 8080, nil) {
+			addresses[instance.Endpoint.Address]++
+		}
+		if len(addresses) != 3 {
+			return fmt.Errorf("expected 3 endpoints, found %v", addresses)
+		}
+		if addresses[sharedIP] != 1 {
+			return fmt.Errorf("expected endpoint %s to be counted once, found %d", sharedIP, addresses[sharedIP])
+		}
+		return nil
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportedWithOverlappingEndpoints(t *testing.T) {
+	const clusterA, clusterB cluster.ID = "cluster-a", "cluster-b"
+	cs := newFakeClusterSet(t, EndpointsOnly, clusterA, clusterB)
+
+	// Both clusters export the service, and their endpoints overlap: the shared address is reported by both, as
+	// in a flat network where the clusters share the ClusterSet VIP.
+	sharedIP := "128.0.0.3"
+	podIPs := map[cluster.ID][]string{
+		clusterA: {serviceExportPodIP, sharedIP},
+		clusterB: {sharedIP, "128.0.0.4"},
+	}
+	for clusterID, ips := range podIPs {
+		c := cs.clusters[clusterID]
+		createService(c, serviceExportName, serviceExportNamespace, nil,
+			[]int32{8080}, map[string]string{"app": "prod-app"}, t)
+		createEndpoints(t, c, serviceExportName, serviceExportNamespace, []string{"tcp-port"}, ips, nil, nil)
+		if _, err := c.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+			context.TODO(), newServiceExport(), kubeMeta.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clusterSetHost := serviceClusterSetLocalHostname(serviceExportNamespacedName)
+	retry.UntilSuccessOrFail(t, func() error {
+		svc := cs.mesh.GetService(clusterSetHost)
+		if svc == nil {
+			return fmt.Errorf("failed to find service %s", clusterSetHost)
+		}
+
+		// Each endpoint appears once in the aggregated service. The EDS shards of the clusters are merged the same
+		// way (see TestEndpointsOfOverlappingServiceExports in pilot/pkg/xds).
+		addresses := make(map[string]int)
+		for _, instance := range cs.mesh.InstancesByPort(svc, 8080, nil) {
+			addresses[instance.Endpoint.Address]++
+		}
+		if len(addresses) != 3 {
+			return fmt.Errorf("expected 3 endpoints, found %v", addresses)
+		}
+		for address, count := range addresses {
+			if count != 1 {
+				return fmt.Errorf("expected endpoint %s to appear once, found %d", address, count)
+			}
+		}
+		return nil
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportedFromClustersWithDifferentCapacities(t *testing.T) {
+	const clusterA, clusterB cluster.ID = "cluster-a", "cluster-b"
+	cs := newFakeClusterSet(t, EndpointSliceOnly, clusterA, clusterB)
+
+	// Cluster A has three times the capacity of cluster B.
+	capacities := map[cluster.ID]uint32{clusterA: 3, clusterB: 1}
+	ipsByCluster := map[cluster.ID]string{
+		clusterA: "128.0.0.2",
+		clusterB: "128.0.0.3",
+	}
+	for clusterID, ip := range ipsByCluster {
+		c := cs.clusters[clusterID]
+		c.opts.ClusterCapacities = capacities
+		createService(c, serviceExportName, serviceExportNamespace, nil,
+			[]int32{8080}, map[string]string{"app": "prod-app"}, t)
+		createEndpoints(t, c, serviceExportName, serviceExportNamespace, []string{"tcp-port"}, []string{ip}, nil, nil)
+		if _, err := c.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+			context.TODO(), newServiceExport(), kubeMeta.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clusterSetHost := serviceClusterSetLocalHostname(serviceExportNamespacedName)
+	retry.UntilSuccessOrFail(t, func() error {
+		svc := cs.mesh.GetService(clusterSetHost)
+		if svc == nil {
+			return fmt.Errorf("failed to find service %s", clusterSetHost)
+		}
+		weights := make(map[cluster.ID]uint32)
+		for _, instance := range cs.mesh.InstancesByPort(svc, 8080, nil) {
+			weights[instance.Endpoint.Locality.ClusterID] = instance.Endpoint.LbWeight
+		}
+		if len(weights) != 2 {
+			return fmt.Errorf("expected endpoints from 2 clusters, found %v", weights)
+		}
+		// The weights of the endpoints are in proportion to the capacities of their clusters.
+		if weights[clusterA] == 0 || weights[clusterA] != 3*weights[clusterB] {
+			return fmt.Errorf("expected the weight in cluster %s to be 3 times the weight in cluster %s, found %v",
+				clusterA, clusterB, weights)
+		}
+		return nil
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportedWithConflictingPortsPreferringOldest(t *testing.T) {
+	prevPreferOldestExport := features.MCSPreferOldestExport
+	features.MCSPreferOldestExport = true
+	t.Cleanup(func() {
+		features.MCSPreferOldestExport = prevPreferOldestExport
+	})
+
+	const clusterA, clusterB cluster.ID = "cluster-a", "cluster-b"
+	cs := newFakeClusterSet(t, EndpointSliceOnly, clusterA, clusterB)
+
+	// The service exposes different ports in each cluster. The export in cluster B is the older one.
+	portsByCluster := map[cluster.ID][]coreV1.ServicePort{
+		clusterA: {{Name: "tcp-port", Port: 8080, Protocol: "TCP"}, {Name: "tcp-admin", Port: 9090, Protocol: "TCP"}},
+		clusterB: {{Name: "tcp-port", Port: 8080, Protocol: "TCP"}},
+	}
+	ipsByCluster := map[cluster.ID]string{
+		clusterA: "128.0.0.2",
+		clusterB: "128.0.0.3",
+	}
+	createdByCluster := map[cluster.ID]time.Time{
+		clusterA: time.Now(),
+		clusterB: time.Now().Add(-time.Hour),
+	}
+	for clusterID, ports := range portsByCluster {
+		c := cs.clusters[clusterID]
+		createServiceWithTargetPorts(c, serviceExportName, serviceExportNamespace, nil, ports, map[string]string{"app": "prod-app"}, t)
+		createEndpoints(t, c, serviceExportName, serviceExportNamespace, []string{"tcp-port"}, []string{ipsByCluster[clusterID]}, nil, nil)
+		se := newServiceExport()
+		se.CreationTimestamp = kubeMeta.NewTime(createdByCluster[clusterID])
+		if _, err := c.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+			context.TODO(), se, kubeMeta.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	proxies := map[cluster.ID]*model.Proxy{
+		clusterA: {Metadata: &model.NodeMetadata{ClusterID: clusterA}},
+		clusterB: {Metadata: &model.NodeMetadata{ClusterID: clusterB}},
+	}
+	clusterSetHost := serviceClusterSetLocalHostname(serviceExportNamespacedName)
+	retry.UntilSuccessOrFail(t, func() error {
+		svc := cs.mesh.GetService(clusterSetHost)
+		if svc == nil {
+			return fmt.Errorf("failed to find service %s", clusterSetHost)
+		}
+		instances := cs.mesh.InstancesByPort(svc, 8080, nil)
+		if len(instances) != 2 {
+			return fmt.Errorf("expected 2 instances, found %d", len(instances))
+		}
+		for _, instance := range instances {
+			ep := instance.Endpoint
+			for proxyCluster, proxy := range proxies {
+				// The older export in cluster B wins: its endpoint is discoverable from both clusters, while the
+				// endpoint of the newer, conflicting export in cluster A is kept cluster-local.
+				want := ep.Locality.ClusterID == clusterB || proxyCluster == ep.Locality.ClusterID
+				if got := ep.IsDiscoverableFromProxy(proxy); got != want {
+					return fmt.Errorf("expected endpoint %s in cluster %s to be discoverable from cluster %s: %t, found %t",
+						ep.Address, ep.Locality.ClusterID, proxyCluster, want, got)
+				}
+			}
+		}
+		return nil
+	}, serviceExportTimeout)
+	a := cs.clusters[clusterA].exports.(*serviceExportCacheImpl)
+	if winner, ok := a.conflictingExport(serviceExportNamespacedName); !ok || winner != clusterB {
+		t.Fatalf("expected the export in cluster %s to lose to cluster %s, found %q (%t)", clusterA, clusterB, winner, ok)
+	}
+
+	// Aligning the ports of the service in cluster A resolves the conflict, without any change to the exports.
+	svc, err := a.client.CoreV1().Services(serviceExportNamespace).Get(context.TODO(), serviceExportName, kubeMeta.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Spec.Ports = svc.Spec.Ports[:1]
+	if _, err := a.client.CoreV1().Services(serviceExportNamespace).Update(context.TODO(), svc, kubeMeta.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		svc := cs.mesh.GetService(clusterSetHost)
+		if svc == nil {
+			return fmt.Errorf("failed to find service %s", clusterSetHost)
+		}
+		for _, instance := range cs.mesh.InstancesByPort(svc, 8080, nil) {
+			ep := instance.Endpoint
+			for proxyCluster, proxy := range proxies {
+				if !ep.IsDiscoverableFromProxy(proxy) {
+					return fmt.Errorf("expected endpoint %s in cluster %s to be discoverable from cluster %s",
+						ep.Address, ep.Locality.ClusterID, proxyCluster)
+				}
+			}
+		}
+		return nil
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportedWithDifferentPortNames(t *testing.T) {
+	const clusterA, clusterB cluster.ID = "cluster-a", "cluster-b"
+	cs := newFakeClusterSet(t, EndpointSliceOnly, clusterA, clusterB)
+
+	// The same ports are named differently in each cluster, e.g. HTTP/2 in cluster A and HTTP/1 in cluster B.
+	portNamesByCluster := map[cluster.ID][]string{
+		clusterA: {"http2-web", "tcp-db"},
+		clusterB: {"http-web", "tcp-mysql"},
+	}
+	ipsByCluster := map[cluster.ID]string{
+		clusterA: "128.0.0.2",
+		clusterB: "128.0.0.3",
+	}
+	for clusterID, portNames := range portNamesByCluster {
+		c := cs.clusters[clusterID]
+		createServiceWithTargetPorts(c, serviceExportName, serviceExportNamespace, nil, []coreV1.ServicePort{
+			{Name: portNames[0], Port: 8080, Protocol: "TCP"},
+			{Name: portNames[1], Port: 3306, Protocol: "TCP"},
+		}, map[string]string{"app": "prod-app"}, t)
+		createEndpoints(t, c, serviceExportName, serviceExportNamespace, portNames, []string{ipsByCluster[clusterID]}, nil, nil)
+		if _, err := c.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+			context.TODO(), newServiceExport(), kubeMeta.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clusterSetHost := serviceClusterSetLocalHostname(serviceExportNamespacedName)
+	retry.UntilSuccessOrFail(t, func() error {
+		svc := cs.mesh.GetService(clusterSetHost)
+		if svc == nil {
+			return fmt.Errorf("failed to find service %s", clusterSetHost)
+		}
+
+		// Each cluster matches its endpoints by its own name for the port, so every port gets exactly one
+		// endpoint from each cluster, labeled with the port of the imported service.
+		for _, port := range []int{8080, 3306} {
+			svcPort, _ := svc.Ports.GetByPort(port)
+			addresses := make(map[string]int)
+			for _, instance := range cs.mesh.InstancesByPort(svc, port, nil) {
+				if instance.Endpoint.ServicePortName != svcPort.Name {
+					return fmt.Errorf("expected endpoint %s for port %d to use port name %s, found %s",
+						instance.Endpoint.Address, port, svcPort.Name, instance.Endpoint.ServicePortName)
+				}
+				addresses[instance.Endpoint.Address]++
+			}
+			for clusterID, ip := range ipsByCluster {
+				if addresses[ip] != 1 {
+					return fmt.Errorf("expected 1 endpoint for port %d in cluster %s, found %v", port, clusterID, addresses)
+				}
+			}
+		}
+		return nil
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportReflectsImportingClusters(t *testing.T) {
+	prevEnableMCSHost := features.EnableMCSHost
+	features.EnableMCSHost = true
+	t.Cleanup(func() {
+		features.EnableMCSHost = prevEnableMCSHost
+	})
+
+	const clusterA, clusterB, clusterC cluster.ID = "cluster-a", "cluster-b", "cluster-c"
+	cs := newFakeClusterSet(t, EndpointSliceOnly, clusterA, clusterB, clusterC)
+	a := cs.clusters[clusterA]
+
+	// Create the service in cluster A only and export it, which imports it into all of the clusters.
+	createService(a, serviceExportName, serviceExportNamespace, nil,
+		[]int32{8080}, map[string]string{"app": "prod-app"}, t)
+	createEndpoints(t, a, serviceExportName, serviceExportNamespace, []string{"tcp-port"}, []string{serviceExportPodIP}, nil, nil)
+	if _, err := a.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+		context.TODO(), newServiceExport(), kubeMeta.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkImported := func(status coreV1.ConditionStatus, reason, message string) error {
+		se, err := a.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Get(
+			context.TODO(), serviceExportName, kubeMeta.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, c := range se.Status.Conditions {
+			if c.Type != serviceExportImported {
+				continue
+			}
+			if c.Status != status || c.Reason == nil || *c.Reason != reason || c.Message == nil || *c.Message != message {
+				return fmt.Errorf("unexpected Imported condition %v", c)
+			}
+			return nil
+		}
+		return fmt.Errorf("Imported condition not found")
+	}
+
+	// The exporting cluster observes the imports of the other clusters, not its own.
+	retry.UntilSuccessOrFail(t, func() error {
+		return checkImported(coreV1.ConditionTrue, serviceExportReasonImported,
+			"the service is imported by other clusters: cluster-b,cluster-c")
+	}, serviceExportTimeout)
+
+	unimport := func(clusterID cluster.ID) {
+		if err := cs.clusters[clusterID].client.MCSApis().MulticlusterV1alpha1().ServiceImports(serviceExportNamespace).Delete(
+			context.TODO(), serviceExportName, kubeMeta.DeleteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unimport(clusterB)
+	retry.UntilSuccessOrFail(t, func() error {
+		return checkImported(coreV1.ConditionTrue, serviceExportReasonImported,
+			"the service is imported by other clusters: cluster-c")
+	}, serviceExportTimeout)
+
+	unimport(clusterC)
+	retry.UntilSuccessOrFail(t, func() error {
+		return checkImported(coreV1.ConditionFalse, serviceExportReasonNotImported,
+			"the service is not imported by any other cluster")
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportedWithHostnameOverrideAcrossClusters(t *testing.T) {
+	prevEnableMCSHost := features.EnableMCSHost
+	features.EnableMCSHost = true
+	t.Cleanup(func() {
+		features.EnableMCSHost = prevEnableMCSHost
+	})
+
+	const clusterA, clusterB cluster.ID = "cluster-a", "cluster-b"
+	cs := newFakeClusterSet(t, EndpointSliceOnly, clusterA, clusterB)
+	a, b := cs.clusters[clusterA], cs.clusters[clusterB]
+
+	// Create the service in cluster A only and export it under another hostname. Cluster B only imports it.
+	createService(a, serviceExportName, serviceExportNamespace, nil,
+		[]int32{8080}, map[string]string{"app": "prod-app"}, t)
+	createEndpoints(t, a, serviceExportName, serviceExportNamespace, []string{"tcp-port"}, []string{serviceExportPodIP}, nil, nil)
+	overrideHost := host.Name("legacy.team-a.svc.clusterset.local")
+	se := newServiceExport()
+	se.Annotations = map[string]string{exportHostnameAnnotation: overrideHost.String()}
+	if _, err := a.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+		context.TODO(), se, kubeMeta.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultHost := serviceClusterSetLocalHostname(serviceExportNamespacedName)
+	checkHostname := func(want, unwanted host.Name) {
+		t.Helper()
+		retry.UntilSuccessOrFail(t, func() error {
+			for clusterID, c := range cs.clusters {
+				if got := c.exports.ClusterSetHostname(serviceExportNamespacedName); got != want {
+					return fmt.Errorf("expected hostname %s in cluster %s, found %s", want, clusterID, got)
+				}
+				if c.GetService(want) == nil {
+					return fmt.Errorf("failed to find service %s in cluster %s", want, clusterID)
+				}
+				if c.GetService(unwanted) != nil {
+					return fmt.Errorf("found unexpected service %s in cluster %s", unwanted, clusterID)
+				}
+			}
+
+			// The importing cluster contributes its ClusterSet VIP to the service under the same hostname.
+			svc := cs.mesh.GetService(want)
+			if svc == nil {
+				return fmt.Errorf("failed to find service %s in the mesh", want)
+			}
+			vip := cs.vip(serviceExportNamespacedName)
+			if vips := svc.ClusterVIPs.GetAddressesFor(clusterB); len(vips) != 1 || vips[0] != vip {
+				return fmt.Errorf("expected VIP %s in cluster %s, found %v", vip, clusterB, vips)
+			}
+			return nil
+		}, serviceExportTimeout)
+	}
+
+	// Both clusters agree on the overridden hostname, even though cluster B doesn't export the service.
+	checkHostname(overrideHost, defaultHost)
+	if b.exports.(*serviceExportCacheImpl).isExported(serviceExportNamespacedName) {
+		t.Fatalf("expected the service not to be exported in cluster %s", clusterB)
+	}
+
+	// Removing the override moves the service back to its default hostname in both clusters.
+	se, err := a.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Get(
+		context.TODO(), serviceExportName, kubeMeta.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	se.Annotations = nil
+	if _, err := a.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Update(
+		context.TODO(), se, kubeMeta.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkHostname(defaultHost, overrideHost)
+}
+
+func TestServiceExportedWithConflictingHostnameOverrides(t *testing.T) {
+	prevEnableMCSHost := features.EnableMCSHost
+	features.EnableMCSHost = true
+	t.Cleanup(func() {
+		features.EnableMCSHost = prevEnableMCSHost
+	})
+
+	const clusterA, clusterB cluster.ID = "cluster-a", "cluster-b"
+	cs := newFakeClusterSet(t, EndpointSliceOnly, clusterA, clusterB)
+	a := cs.clusters[clusterA]
+
+	// Export two services of cluster A under the same hostname. Neither override applies while they conflict.
+	const otherName = "other-svc"
+	overrideHost := host.Name("legacy.team-a.svc.clusterset.local")
+	for _, name := range []string{serviceExportName, otherName} {
+		createService(a, name, serviceExportNamespace, nil,
+			[]int32{8080}, map[string]string{"app": name}, t)
+		se := &mcs.ServiceExport{ObjectMeta: kubeMeta.ObjectMeta{
+			Name:        name,
+			Namespace:   serviceExportNamespace,
+			Annotations: map[string]string{exportHostnameAnnotation: overrideHost.String()},
+		}}
+		if _, err := a.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+			context.TODO(), se, kubeMeta.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	otherNamespacedName := types.NamespacedName{Namespace: serviceExportNamespace, Name: otherName}
+	checkHostnames := func(want map[types.NamespacedName]host.Name) {
+		t.Helper()
+		retry.UntilSuccessOrFail(t, func() error {
+			for clusterID, c := range cs.clusters {
+				for name, hostname := range want {
+					if got := c.exports.ClusterSetHostname(name); got != hostname {
+						return fmt.Errorf("expected hostname %s for %s in cluster %s, found %s", hostname, name, clusterID, got)
+					}
+				}
+			}
+			return nil
+		}, serviceExportTimeout)
+	}
+	checkHostnames(map[types.NamespacedName]host.Name{
+		serviceExportNamespacedName: serviceClusterSetLocalHostname(serviceExportNamespacedName),
+		otherNamespacedName:         serviceClusterSetLocalHostname(otherNamespacedName),
+	})
+
+	// Unexporting one of the services resolves the conflict, so the override of the other one applies across the mesh.
+	if err := a.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Delete(
+		context.TODO(), otherName, kubeMeta.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkHostnames(map[types.NamespacedName]host.Name{serviceExportNamespacedName: overrideHost})
+}
+
+func TestServiceExportedWithNamespaceSamenessAcrossClusters(t *testing.T) {
+	prevEnableMCSHost := features.EnableMCSHost
+	features.EnableMCSHost = true
+	prevMCSNamespaceSameness := features.MCSNamespaceSameness
+	features.MCSNamespaceSameness = map[string]string{"team-a": "shared", "team-b": "shared"}
+	t.Cleanup(func() {
+		features.EnableMCSHost = prevEnableMCSHost
+		features.MCSNamespaceSameness = prevMCSNamespaceSameness
+	})
+
+	const clusterA, clusterB cluster.ID = "cluster-a", "cluster-b"
+	cs := newFakeClusterSet(t, EndpointSliceOnly, clusterA, clusterB)
+
+	// The same service lives in a differently named namespace in each cluster, mapped to the same canonical one.
+	namespaces := map[cluster.ID]string{clusterA: "team-a", clusterB: "team-b"}
+	podIPs := map[cluster.ID]string{clusterA: "128.0.0.2", clusterB: "128.0.0.3"}
+	for clusterID, namespace := range namespaces {
+		c := cs.clusters[clusterID]
+		createService(c, serviceExportName, namespace, nil,
+			[]int32{8080}, map[string]string{"app": "prod-app"}, t)
+		createEndpoints(t, c, serviceExportName, namespace, []string{"tcp-port"}, []string{podIPs[clusterID]}, nil, nil)
+		se := &mcs.ServiceExport{ObjectMeta: kubeMeta.ObjectMeta{Name: serviceExportName, Namespace: namespace}}
+		if _, err := c.client.MCSApis().MulticlusterV1alpha1().ServiceExports(namespace).Create(
+			context.TODO(), se, kubeMeta.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clusterSetHost := host.Name(serviceExportName + ".shared.svc.clusterset.local")
+	retry.UntilSuccessOrFail(t, func() error {
+		// Both clusters generate the clusterset.local service in the canonical namespace.
+		for clusterID, c := range cs.clusters {
+			svc := c.GetService(clusterSetHost)
+			if svc == nil {
+				return fmt.Errorf("failed to find service %s in cluster %s", clusterSetHost, clusterID)
+			}
+			if svc.Attributes.Namespace != "shared" {
+				return fmt.Errorf("expected namespace shared in cluster %s, found %s", clusterID, svc.Attributes.Namespace)
+			}
+		}
+
+		// The endpoints of both clusters are merged under the single service.
+		svc := cs.mesh.GetService(clusterSetHost)
+		if svc == nil {
+			return fmt.Errorf("failed to find service %s in the mesh", clusterSetHost)
+		}
+		found := make(map[cluster.ID]string)
+		for _, instance := range cs.mesh.InstancesByPort(svc, 8080, nil) {
+			found[instance.Endpoint.Locality.ClusterID] = instance.Endpoint.Address
+		}
+		for clusterID, ip := range podIPs {
+			if found[clusterID] != ip {
+				return fmt.Errorf("expected endpoint %s in cluster %s, found %v", ip, clusterID, found)
+			}
+		}
+		return nil
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportedWithNamespaceSamenessCollision(t *testing.T) {
+	prevMCSNamespaceSameness := features.MCSNamespaceSameness
+	features.MCSNamespaceSameness = map[string]string{serviceExportNamespace: "shared"}
+	t.Cleanup(func() {
+		features.MCSNamespaceSameness = prevMCSNamespaceSameness
+	})
+
+	const clusterA cluster.ID = "cluster-a"
+	cs := newFakeClusterSet(t, EndpointSliceOnly, clusterA)
+	a := cs.clusters[clusterA]
+	ec := a.exports.(*serviceExportCacheImpl)
+
+	createService(a, serviceExportName, serviceExportNamespace, nil,
+		[]int32{8080}, map[string]string{"app": "prod-app"}, t)
+	if _, err := a.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+		context.TODO(), newServiceExport(), kubeMeta.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		if got := ec.ClusterSetNamespace(serviceExportNamespacedName); got != "shared" {
+			return fmt.Errorf("expected canonical namespace shared, found %s", got)
+		}
+		return nil
+	}, serviceExportTimeout)
+
+	// A different service of the same name exported from the canonical namespace itself cancels the mapping, so
+	// that the two services aren't merged.
+	createService(a, serviceExportName, "shared", nil,
+		[]int32{8080}, map[string]string{"app": "other-app"}, t)
+	se := &mcs.ServiceExport{ObjectMeta: kubeMeta.ObjectMeta{Name: serviceExportName, Namespace: "shared"}}
+	if _, err := a.client.MCSApis().MulticlusterV1alpha1().ServiceExports("shared").Create(
+		context.TODO(), se, kubeMeta.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ownHost := host.Name(serviceExportName + "." + serviceExportNamespace + ".svc.clusterset.local")
+	retry.UntilSuccessOrFail(t, func() error {
+		if got := ec.ClusterSetNamespace(serviceExportNamespacedName); got != serviceExportNamespace {
+			return fmt.Errorf("expected namespace %s, found %s", serviceExportNamespace, got)
+		}
+		if got := ec.ClusterSetHostname(serviceExportNamespacedName); got != ownHost {
+			return fmt.Errorf("expected hostname %s, found %s", ownHost, got)
+		}
+		svc := a.GetService(ownHost)
+		if svc == nil {
+			return fmt.Errorf("failed to find service %s", ownHost)
+		}
+		if namespacedNameForService(svc) != serviceExportNamespacedName {
+			return fmt.Errorf("expected service %s under %s, found %s", serviceExportNamespacedName, ownHost, namespacedNameForService(svc))
+		}
+		return nil
+	}, serviceExportTimeout)
+}
@@ -94,6 +94,7 @@ func NewMulticluster(
 	clusterLocal model.ClusterLocalProvider,
 	s server.Instance) *Multicluster {
 	remoteKubeController := make(map[cluster.ID]*kubeController)
+	opts.hostnameOverrides = newHostnameOverrideIndex()
 	mc := &Multicluster{
 		serverID:              serverID,
 		opts:                  opts,
@@ -254,6 +255,23 @@ func (m *Multicluster) AddMemberCluster(clusterID cluster.ID, rc *secretcontroll
 
 	}
 
+	// Only the istiods of a cluster write the status of its ServiceExports, rather than every primary watching it, and
+	// only the elected one of them, so that the writers don't fight over the status.
+	if features.EnableMCSServiceDiscovery && (features.ExternalIstiod || localCluster) {
+		log.Infof("joining leader-election for %s in %s on cluster %s",
+			leaderelection.ServiceExportStatusController, options.SystemNamespace, options.ClusterID)
+		// Block server exit on graceful termination of the leader controller.
+		m.s.RunComponentAsyncAndWait(func(_ <-chan struct{}) error {
+			leaderelection.
+				NewLeaderElection(options.SystemNamespace, m.serverID, leaderelection.ServiceExportStatusController, m.revision, client).
+				AddRunFunction(func(leaderStop <-chan struct{}) {
+					log.Infof("starting service export status writer for cluster %s", clusterID)
+					kubeRegistry.exports.runStatusWriter(leaderStop)
+				}).Run(clusterStopCh)
+			return nil
+		})
+	}
+
 	// setting up the serviceexport controller if and only if it is turned on in the meshconfig.
 	// TODO(nmittler): Need a better solution. Leader election doesn't take into account locality.
 	if features.EnableMCSAutoExport {
@@ -15,26 +15,152 @@
 package controller
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"go.opencensus.io/metric/metricdata"
+	"go.uber.org/atomic"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	klabels "k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 	mcsCore "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 	mcsLister "sigs.k8s.io/mcs-api/pkg/client/listers/apis/v1alpha1"
 
+	"istio.io/api/label"
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	networking "istio.io/api/networking/v1alpha3"
 	"istio.io/istio/pilot/pkg/features"
 	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/util"
 	kubesr "istio.io/istio/pilot/pkg/serviceregistry/kube"
+	"istio.io/istio/pilot/pkg/serviceregistry/kube/controller/filter"
+	"istio.io/istio/pkg/cluster"
 	"istio.io/istio/pkg/config/constants"
 	"istio.io/istio/pkg/config/host"
+	"istio.io/istio/pkg/config/labels"
+	"istio.io/istio/pkg/config/protocol"
+	"istio.io/istio/pkg/config/schema/gvk"
 )
 
+const (
+	// exportEndpointSelectorAnnotation is an annotation on a ServiceExport holding a label selector. When set, only
+	// the endpoints whose pods match the selector are discoverable from other clusters in the mesh.
+	exportEndpointSelectorAnnotation = "networking.istio.io/exportEndpointSelector"
+
+	// exportDiscoverabilityAnnotation is the discoverability hint proposed for the MCS ServiceExport API. A value of
+	// exportDiscoverabilityLocal keeps the endpoints of an exported service local to the cluster, which allows the
+	// export to be staged before it is rolled out.
+	exportDiscoverabilityAnnotation = "multicluster.x-k8s.io/discoverability"
+	exportDiscoverabilityLocal      = "Local"
+
+	// exportNamespaceDiscoverabilityLabel is a label on a Namespace applying the discoverability hint to all of the
+	// ServiceExports in the namespace. A value of exportDiscoverabilityLocal keeps the exported services local.
+	exportNamespaceDiscoverabilityLabel = exportDiscoverabilityAnnotation
+
+	// exportLoadBalancerAnnotation is an annotation on a ServiceExport that sets the simple load balancing policy
+	// (e.g. LEAST_CONN) of the synthetic clusterset.local service. A DestinationRule for the host takes precedence.
+	exportLoadBalancerAnnotation = "networking.istio.io/exportLoadBalancer"
+
+	// exportRequireMutualTLSAnnotation is an annotation on a ServiceExport. When "true", the endpoints are only
+	// discoverable from proxies in other clusters that use mutual TLS.
+	exportRequireMutualTLSAnnotation = "networking.istio.io/exportRequireMutualTLS"
+
+	// exportPortsAnnotation is an annotation on a ServiceExport holding a comma-separated list of service port names.
+	// When set, only the endpoints for the listed ports are discoverable from other clusters in the mesh. References
+	// to ports that the service doesn't expose are reported on the Valid condition of the ServiceExport.
+	exportPortsAnnotation = "networking.istio.io/exportPorts"
+
+	// exportZonesAnnotation is an annotation on a ServiceExport holding a comma-separated list of locality zones
+	// (e.g. us-east-1a). When set, only the endpoints in the listed zones are discoverable from other clusters.
+	exportZonesAnnotation = "networking.istio.io/exportZones"
+
+	// exportMinEndpointsAnnotation is an annotation on a ServiceExport holding a minimum number of endpoints, counted
+	// as distinct endpoint addresses (pods or workloads) rather than per port. Until the service has at least this many
+	// endpoints in the cluster, its endpoints are kept local to the cluster.
+	exportMinEndpointsAnnotation = "networking.istio.io/minEndpoints"
+
+	// exportClusterGroupsAnnotation is an annotation on a ServiceExport holding a comma-separated list of cluster
+	// groups, as configured by Options.ClusterGroups. When set, the endpoints are only discoverable from the clusters
+	// in the listed groups.
+	exportClusterGroupsAnnotation = "networking.istio.io/exportClusterGroups"
+
+	// exportHostnameAnnotation is an annotation on a ServiceExport overriding the clusterset.local hostname of the
+	// exported service (e.g. legacy.team-a.svc.clusterset.local). The override must be in the clusterset.local domain
+	// and is ignored if it is the hostname of another exported service in the cluster. It applies in all of the
+	// clusters of the mesh, including those only importing the service (see ClusterSetHostname).
+	exportHostnameAnnotation = "networking.istio.io/exportHostname"
+
+	// exportTLSModeAnnotation is an annotation on a ServiceExport. When "true", the TLS mode of each endpoint, derived
+	// from the security.istio.io/tlsMode label of its pod, is stamped onto the labels of the exported endpoint, so that
+	// it can be matched by the endpoint selector and is carried with the endpoint to the other clusters.
+	exportTLSModeAnnotation = "networking.istio.io/exportTLSMode"
+
+	// healthCheckPassRateAnnotation is an annotation on a Pod holding the fraction, between 0 and 1, of recent health
+	// checks the pod passed, as reported by an external health checker. The endpoints of exported services are
+	// weighted by it, so that traffic across the mesh prefers the healthier endpoints.
+	healthCheckPassRateAnnotation = "networking.istio.io/healthCheckPassRate"
+
+	// healthWeightScale is the load balancing weight of an endpoint passing all of its health checks.
+	healthWeightScale = 100
+
+	// serviceExportReasonUnknownPort is the reason of the Valid condition of a ServiceExport that references ports
+	// the service doesn't expose.
+	serviceExportReasonUnknownPort = "UnknownPort"
+
+	// serviceExportHeldClusterLocal is the type of the condition set on a ServiceExport while the endpoints of the
+	// service are kept local to the cluster because it doesn't have the minimum number of endpoints (see
+	// exportMinEndpointsAnnotation). The condition is removed once the endpoints are discoverable mesh-wide.
+	serviceExportHeldClusterLocal mcsCore.ServiceExportConditionType = "HeldClusterLocal"
+
+	// serviceExportReasonInsufficientEndpoints is the reason of the HeldClusterLocal condition of a ServiceExport.
+	serviceExportReasonInsufficientEndpoints = "InsufficientEndpoints"
+
+	// serviceExportDiscoverability is the type of the condition reporting the discoverability policy the controller
+	// applies to the endpoints of the exported service. Its reason summarizes the policy (see
+	// discoverabilityReason), while its message holds the full policy, e.g. FilteredDiscoverable[Zones(us-east-1a)].
+	// The condition is False in dry-run mode, where the policy is reported but not applied.
+	serviceExportDiscoverability mcsCore.ServiceExportConditionType = "Discoverability"
+
+	// The reasons of the Discoverability condition of a ServiceExport.
+	serviceExportReasonClusterLocal = "ClusterLocal"
+	serviceExportReasonMeshWide     = "MeshWide"
+	serviceExportReasonFiltered     = "Filtered"
+
+	// serviceExportImported is the type of the condition reporting whether the exported service is imported by
+	// other clusters in the mesh, i.e. whether they hold a ServiceImport for it. Its message lists the importing
+	// clusters, which are also counted by the pilot_mcs_service_importing_clusters metric.
+	serviceExportImported mcsCore.ServiceExportConditionType = "Imported"
+
+	// The reasons of the Imported condition of a ServiceExport.
+	serviceExportReasonImported    = "Imported"
+	serviceExportReasonNotImported = "NotImported"
+)
+
+// mutualTLSModes are the values of the security.istio.io/tlsMode label indicating that a proxy uses mutual TLS.
+var mutualTLSModes = map[string]bool{
+	model.IstioMutualTLSModeLabel: true,
+	"mutual":                      true,
+}
+
 type exportedService struct {
 	namespacedName  types.NamespacedName
 	discoverability map[host.Name]string
+	// vips are the VIPs of the exported service in this cluster. In flat networks, the same VIP may be valid
+	// in several clusters.
+	vips []string
 }
 
 // serviceExportCache reads Kubernetes Multi-Cluster Services (MCS) ServiceExport resources in the
@@ -43,11 +169,59 @@ type serviceExportCache interface {
 	// EndpointDiscoverabilityPolicy returns the policy for Service endpoints residing within the current cluster.
 	EndpointDiscoverabilityPolicy(svc *model.Service) model.EndpointDiscoverabilityPolicy
 
+	// LoadBalancerPolicy returns the load balancing policy requested by the ServiceExport for the given service, if any.
+	LoadBalancerPolicy(name types.NamespacedName) *networking.LoadBalancerSettings_SimpleLB
+
+	// EndpointLbWeight returns the load balancing weight of the endpoint of the given exported service backed by
+	// the pod, derived from the health check pass rate of the pod and scaled by the capacity of the cluster. Zero
+	// means the default weight.
+	EndpointLbWeight(svc *model.Service, pod *v1.Pod) uint32
+
+	// ExportGeneration returns the generation of the ServiceExport of the given service, or 0 if it isn't exported.
+	ExportGeneration(svc *model.Service) int64
+
+	// PodExportable indicates whether the endpoints backed by the pod may be discoverable from other clusters. Only
+	// the endpoints of running pods are, even though the endpoints of pods in other phases may be listed.
+	PodExportable(pod *v1.Pod) bool
+
+	// EndpointALPNHints returns the ALPN protocols the endpoints of the given exported service are expected to
+	// negotiate, keyed by service port name. It returns nil if the service isn't exported.
+	EndpointALPNHints(svc *model.Service) map[string][]string
+
+	// ExportsTLSMode indicates whether the TLS mode of the endpoints of the given exported service is stamped onto
+	// their labels.
+	ExportsTLSMode(svc *model.Service) bool
+
+	// EndpointsUpdated records the number of endpoints of the given service in this cluster, i.e. the number of
+	// distinct endpoint addresses (see endpointAddressCount), which are reported for the exported services.
+	EndpointsUpdated(name types.NamespacedName, endpoints int)
+
+	// ClusterSetHostname returns the clusterset.local hostname of the given service, which may be overridden by
+	// its ServiceExport in any of the clusters of the mesh.
+	ClusterSetHostname(name types.NamespacedName) host.Name
+
+	// ClusterSetNamespace returns the namespace of the synthetic clusterset.local service of the given service, which
+	// is its canonical namespace if one is configured by features.MCSNamespaceSameness.
+	ClusterSetNamespace(name types.NamespacedName) string
+
+	// PodInstancesByPort returns the instances on the given port of svc if it's the service synthesized for a pod
+	// of an exported headless service (see podClusterSetHostname). ok is false for any other service.
+	PodInstancesByPort(svc *model.Service, port int, labelsList labels.Collection) (instances []*model.ServiceInstance, ok bool)
+
 	// ExportedServices returns the list of services that are exported in this cluster. Used for debugging.
 	ExportedServices() []exportedService
 
 	// HasSynced indicates whether the kube createClient has synced for the watched resources.
 	HasSynced() bool
+
+	// runResync periodically recomputes the discoverability of all of the exported services, as configured by
+	// Options.MCSResyncPeriod, until stop is closed. The pending grace periods are cancelled once stop is closed.
+	runResync(stop <-chan struct{})
+
+	// runStatusWriter writes the status of the ServiceExports in the cluster until stop is closed. It is only run by
+	// the istiod elected to do so among those of the cluster (see leaderelection.ServiceExportStatusController), so
+	// that a single writer owns the status.
+	runStatusWriter(stop <-chan struct{})
 }
 
 // newServiceExportCache creates a new serviceExportCache that observes the given cluster.
@@ -55,17 +229,42 @@ func newServiceExportCache(c *Controller) serviceExportCache {
 	if features.EnableMCSServiceDiscovery {
 		informer := c.client.MCSApisInformer().Multicluster().V1alpha1().ServiceExports().Informer()
 		ec := &serviceExportCacheImpl{
-			Controller: c,
-			informer:   informer,
-			lister:     mcsLister.NewServiceExportLister(informer.GetIndexer()),
+			Controller:         c,
+			informer:           informer,
+			lister:             mcsLister.NewServiceExportLister(informer.GetIndexer()),
+			policies:           make(map[types.NamespacedName]map[host.Name]string),
+			endpointCounts:     make(map[types.NamespacedName]int),
+			endpointEvents:     make(map[types.NamespacedName]uint64),
+			conflicts:          make(map[types.NamespacedName]cluster.ID),
+			draining:           make(map[types.NamespacedName]*mcsCore.ServiceExport),
+			timers:             make(map[*time.Timer]struct{}),
+			hostnameOverrides:  make(map[types.NamespacedName]host.Name),
+			requestedHostnames: make(map[types.NamespacedName]host.Name),
+			hostnameClaims:     make(map[host.Name]map[types.NamespacedName]struct{}),
+			claimedHostnames:   make(map[types.NamespacedName][]host.Name),
+			podInstances:       make(map[types.NamespacedName]map[host.Name][]*model.ServiceInstance),
+			statusWriter:       atomic.NewBool(false),
+
+			meshHostnameOverrides: c.opts.hostnameOverrides,
+
+			unexportGrace:        features.MCSUnexportGracePeriod,
+			endpointRemovalGrace: features.MCSEndpointRemovalGracePeriod,
+			preferOldestExport:   features.MCSPreferOldestExport,
+			dryRun:               features.MCSDryRun,
+		}
+
+		if ec.meshHostnameOverrides == nil {
+			// The controller isn't part of a mesh sharing the overrides, so it only knows its own.
+			ec.meshHostnameOverrides = newHostnameOverrideIndex()
 		}
 
 		// Set the discoverability policy for the clusterset.local host.
 		ec.clusterSetLocalPolicySelector = func(svc *model.Service) (policy model.EndpointDiscoverabilityPolicy) {
-			// If the service is exported in this cluster, allow the endpoints in this cluster to be discoverable
-			// anywhere in the mesh.
-			if ec.isExported(namespacedNameForService(svc)) {
-				return model.AlwaysDiscoverable
+			// If the service is exported in this cluster, or was recently unexported and is still draining, allow
+			// the endpoints in this cluster to be discoverable anywhere in the mesh, subject to any restrictions
+			// configured on the export.
+			if se := ec.getServiceExportOrDraining(namespacedNameForService(svc)); se != nil {
+				return ec.exportedPolicy(se)
 			}
 
 			// Otherwise, endpoints are only discoverable from within the same cluster.
@@ -86,7 +285,16 @@ func newServiceExportCache(c *Controller) serviceExportCache {
 		}
 
 		// Register callbacks for events.
-		c.registerHandlers(informer, "ServiceExports", ec.onServiceExportEvent, nil)
+		c.registerHandlers(informer, "ServiceExports", ec.onServiceExportEvent, serviceExportsEqual)
+		c.AppendServiceHandler(ec.onServiceEvent)
+		nsInformer := filter.NewFilteredSharedIndexInformer(func(interface{}) bool { return true }, c.nsInformer)
+		c.registerHandlers(nsInformer, "ServiceExportNamespaces", ec.onNamespaceEvent, namespaceDiscoverabilityEqual)
+		if c.opts.MeshWatcher != nil {
+			ec.clusterLocalHosts = meshClusterLocalHosts(c.opts.MeshWatcher.Mesh())
+			c.opts.MeshWatcher.AddMeshHandler(func() {
+				ec.onMeshConfigChange(c.opts.MeshWatcher.Mesh())
+			})
+		}
 		return ec
 	}
 
@@ -107,6 +315,79 @@ type serviceExportCacheImpl struct {
 
 	// clusterSetLocalPolicySelector selects an appropriate EndpointDiscoverabilityPolicy for the clusterset.local host.
 	clusterSetLocalPolicySelector discoverabilityPolicySelector
+
+	// mutex protects policies, endpointCounts, endpointEvents, conflicts, draining, timers, hostnameOverrides,
+	// requestedHostnames, hostnameClaims, claimedHostnames, podInstances and clusterLocalHosts.
+	mutex sync.Mutex
+
+	// policies holds the discoverability policy, ServiceExport generation and TLS mode export, by service and then
+	// by hostname, of the endpoints last pushed by updateXDS (see policyChanged). It is keyed by service rather than
+	// endpoint, so it is unaffected by endpoints changing IPs. A service is dropped once it is no longer exported and
+	// its endpoints were pushed accordingly, or once it is deleted (see forgetService).
+	policies map[types.NamespacedName]map[host.Name]string
+
+	// endpointCounts holds the number of distinct endpoint addresses of each exported service in this cluster, as
+	// reported by EndpointsUpdated.
+	endpointCounts map[types.NamespacedName]int
+
+	// endpointEvents counts the calls to EndpointsUpdated for each exported service, so that a removal of endpoints
+	// applied after endpointRemovalGrace can tell whether it has been superseded.
+	endpointEvents map[types.NamespacedName]uint64
+
+	// conflicts holds, by exported service, the cluster of the older export the export in this cluster conflicts
+	// with (see olderConflictingExport). It is only maintained with preferOldestExport, on the ServiceExport and
+	// Service events of all of the clusters (see updateConflict).
+	conflicts map[types.NamespacedName]cluster.ID
+
+	// draining holds the ServiceExports deleted less than unexportGrace ago, by service.
+	draining map[types.NamespacedName]*mcsCore.ServiceExport
+
+	// timers holds the pending grace period timers started by afterGrace. It is nil once the cache is stopped.
+	timers map[*time.Timer]struct{}
+
+	// hostnameOverrides holds the valid clusterset.local hostname overrides of the services exported in this cluster
+	// (see exportHostnameAnnotation), by service. It is updated on ServiceExport events by updateHostnameOverrides,
+	// which also records the overrides in meshHostnameOverrides.
+	hostnameOverrides map[types.NamespacedName]host.Name
+
+	// requestedHostnames holds the well-formed hostname overrides requested by the ServiceExports in this cluster, by
+	// service. They are only valid if no other export claims the same hostname (see validHostnameOverride).
+	requestedHostnames map[types.NamespacedName]host.Name
+
+	// hostnameClaims holds the exported services claiming each clusterset.local hostname in this cluster, either as
+	// their default hostname or as their requested override. claimedHostnames holds the reverse, by service, with the
+	// default hostname first.
+	hostnameClaims   map[host.Name]map[types.NamespacedName]struct{}
+	claimedHostnames map[types.NamespacedName][]host.Name
+
+	// meshHostnameOverrides holds the valid hostname overrides of all of the clusters of the mesh, from which
+	// ClusterSetHostname picks the one applying to a service.
+	meshHostnameOverrides *hostnameOverrideIndex
+
+	// podInstances holds the instances of the services synthesized for the named pods of the exported headless
+	// services, by service and then by hostname (see updatePodClusterSetServices).
+	podInstances map[types.NamespacedName]map[host.Name][]*model.ServiceInstance
+
+	// unexportGrace is the time for which the endpoints of a service remain discoverable from other clusters once
+	// its ServiceExport is deleted.
+	unexportGrace time.Duration
+
+	// endpointRemovalGrace is the delay before a decrease of the number of endpoints of an exported service applies.
+	endpointRemovalGrace time.Duration
+
+	// preferOldestExport resolves conflicts between the exports of a service in different clusters in favor of the
+	// oldest export (see olderConflictingExport).
+	preferOldestExport bool
+
+	// dryRun reports the discoverability of the exported services without applying it (see appliedServiceExport).
+	dryRun bool
+
+	// clusterLocalHosts holds the hosts the mesh config marks as cluster-local (see meshClusterLocalHosts). The
+	// services exported under these hosts are kept local to the cluster.
+	clusterLocalHosts model.ClusterLocalHosts
+
+	// statusWriter is true while runStatusWriter runs. The status of the ServiceExports is only written meanwhile.
+	statusWriter *atomic.Bool
 }
 
 func (ec *serviceExportCacheImpl) onServiceExportEvent(obj interface{}, event model.Event) error {
@@ -122,88 +403,1702 @@ func (ec *serviceExportCacheImpl) onServiceExportEvent(obj interface{}, event mo
 		}
 	}
 
-	switch event {
-	case model.EventAdd, model.EventDelete:
-		ec.updateXDS(se)
-	default:
-		// Don't care about updates.
+	if event == model.EventDelete {
+		ec.startDraining(se)
+		ec.removeEndpointCount(kubesr.NamespacedNameForK8sObject(se))
+	} else {
+		ec.mutex.Lock()
+		delete(ec.draining, kubesr.NamespacedNameForK8sObject(se))
+		ec.mutex.Unlock()
+	}
+
+	// Updates are only received when the annotations change (see serviceExportsEqual), which may
+	// change the discoverability of the endpoints.
+	name := kubesr.NamespacedNameForK8sObject(se)
+	if ec.preferOldestExport {
+		ec.updateConflict(name)
+	}
+	// An export from a canonical namespace cancels the mapping of the services of the same name to it, which changes
+	// their default hostname.
+	names := []types.NamespacedName{name}
+	for namespace, canonical := range features.MCSNamespaceSameness {
+		if canonical == name.Namespace {
+			names = append(names, types.NamespacedName{Namespace: namespace, Name: name.Name})
+		}
+	}
+	for _, changed := range ec.updateHostnameOverrides(names...) {
+		if changed != name {
+			// The override of another service may have started or stopped conflicting with se.
+			ec.updateClusterSetHostname(changed)
+		}
+		ec.notifyHostnameOverride(changed)
+	}
+	for _, nn := range names {
+		ec.updateClusterSetHostname(nn)
+	}
+	ec.updateClusterSetService(se)
+	ec.updatePodClusterSetServices(kubesr.NamespacedNameForK8sObject(se))
+	ec.updateExternalNameInstances(se)
+	if event != model.EventDelete {
+		// The minimum number of endpoints of the export applies to the endpoints counted so far.
+		ec.trackEndpointCount(name)
+	}
+	ec.updateXDS(se)
+	if event == model.EventDelete && ec.getServiceExportOrDraining(name) == nil {
+		// The endpoints were pushed as unexported, with no grace period to wait for.
+		ec.forgetService(name)
 	}
+	if event != model.EventDelete {
+		ec.updateStatus(se)
+		if ec.dryRun {
+			log.Infof("dry run: the endpoints of service %s/%s in cluster %s would be %s",
+				se.Namespace, se.Name, ec.Cluster(), ec.exportedPolicy(se))
+		}
+	}
+	if ec.preferOldestExport {
+		ec.updateConflictingExports(name)
+	}
+	recordMCSSync(ec.Cluster())
 	return nil
 }
 
-func (ec *serviceExportCacheImpl) updateXDS(se metav1.Object) {
-	for _, svc := range ec.servicesForNamespacedName(kubesr.NamespacedNameForK8sObject(se)) {
-		// Re-build the endpoints for this service with a new discoverability policy.
-		// Also update any internal caching.
-		endpoints := ec.buildEndpointsForService(svc, true)
-		shard := model.ShardKeyFromRegistry(ec)
-		ec.opts.XDSUpdater.EDSUpdate(shard, svc.Hostname.String(), se.GetNamespace(), endpoints)
+// peerExportCaches returns the export caches of the other Kubernetes clusters in the mesh.
+func (ec *serviceExportCacheImpl) peerExportCaches() []*serviceExportCacheImpl {
+	if ec.opts.MeshServiceController == nil {
+		return nil
 	}
+	var out []*serviceExportCacheImpl
+	for _, r := range ec.opts.MeshServiceController.GetRegistries() {
+		c, ok := r.(*Controller)
+		if !ok || c.Cluster() == ec.Cluster() {
+			continue
+		}
+		if peer, ok := c.exports.(*serviceExportCacheImpl); ok {
+			out = append(out, peer)
+		}
+	}
+	return out
 }
 
-func (ec *serviceExportCacheImpl) EndpointDiscoverabilityPolicy(svc *model.Service) model.EndpointDiscoverabilityPolicy {
-	if svc == nil {
-		// Default policy when the service doesn't exist.
-		return model.DiscoverableFromSameCluster
+// updateConflictingExports re-evaluates the discoverability of the given service exported in the other clusters,
+// whose exports may win or lose a conflict with the export in this cluster.
+func (ec *serviceExportCacheImpl) updateConflictingExports(name types.NamespacedName) {
+	for _, peer := range ec.peerExportCaches() {
+		peer := peer
+		peer.queue.Push(func() error {
+			peer.updateConflict(name)
+			if peerSE := peer.getServiceExport(name); peerSE != nil {
+				peer.updateExternalNameInstances(peerSE)
+				peer.updateXDS(peerSE)
+			}
+			return nil
+		})
 	}
+}
 
-	if strings.HasSuffix(svc.Hostname.String(), "."+constants.DefaultClusterSetLocalDomain) {
-		return ec.clusterSetLocalPolicySelector(svc)
+// updateConflict caches the result of olderConflictingExport for the export of the given service in this cluster,
+// returning whether it changed. exportedPolicy reads the cached result, so that the other clusters aren't walked
+// whenever the endpoints are built.
+func (ec *serviceExportCacheImpl) updateConflict(name types.NamespacedName) bool {
+	se := ec.getServiceExport(name)
+	ec.mutex.Lock()
+	if se == nil {
+		// The endpoints of a deleted export keep its policy while draining.
+		se = ec.draining[name]
+	}
+	ec.mutex.Unlock()
+	var winner cluster.ID
+	conflict := false
+	if se != nil {
+		winner, conflict = ec.olderConflictingExport(se)
 	}
 
-	return ec.clusterLocalPolicySelector(svc)
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	prev, hadConflict := ec.conflicts[name]
+	if conflict {
+		ec.conflicts[name] = winner
+	} else {
+		delete(ec.conflicts, name)
+	}
+	return conflict != hadConflict || prev != winner
 }
 
-func (ec *serviceExportCacheImpl) isExported(name types.NamespacedName) bool {
-	_, err := ec.lister.ServiceExports(name.Namespace).Get(name.Name)
-	return err == nil
+// conflictingExport returns the cluster of the older export the export of the given service in this cluster
+// conflicts with, as cached by updateConflict. ok is false if the export isn't in conflict with an older export.
+func (ec *serviceExportCacheImpl) conflictingExport(name types.NamespacedName) (winner cluster.ID, ok bool) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	winner, ok = ec.conflicts[name]
+	return
 }
 
-func (ec *serviceExportCacheImpl) ExportedServices() []exportedService {
-	// List all exports in this cluster.
+// olderConflictingExport returns the cluster holding the oldest export of the service exported by se that conflicts
+// with se, i.e. whose service exposes different ports, if it is older than se. The creation timestamps of the
+// exports are compared, falling back to the cluster IDs on ties. ok is false if se isn't in conflict with an older
+// export.
+func (ec *serviceExportCacheImpl) olderConflictingExport(se *mcsCore.ServiceExport) (cluster.ID, bool) {
+	name := kubesr.NamespacedNameForK8sObject(se)
+	ports, ok := servicePortNumbers(ec.Controller, name)
+	if !ok {
+		return "", false
+	}
+	for _, peer := range ec.peerExportCaches() {
+		peerSE := peer.getServiceExport(name)
+		if peerSE == nil {
+			continue
+		}
+		peerPorts, ok := servicePortNumbers(peer.Controller, name)
+		if !ok || reflect.DeepEqual(ports, peerPorts) {
+			continue
+		}
+		if exportOlder(peerSE, peer.Cluster(), se, ec.Cluster()) {
+			return peer.Cluster(), true
+		}
+	}
+	return "", false
+}
+
+// servicePortNumbers returns the sorted port numbers exposed by the Kubernetes service in the cluster of c. ok is
+// false if the service doesn't exist.
+func servicePortNumbers(c *Controller, name types.NamespacedName) (ports []int32, ok bool) {
+	svc, err := c.serviceLister.Services(name.Namespace).Get(name.Name)
+	if err != nil {
+		return nil, false
+	}
+	for _, port := range svc.Spec.Ports {
+		ports = append(ports, port.Port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports, true
+}
+
+// exportOlder indicates whether the ServiceExport a in cluster aCluster was created before the ServiceExport b in
+// cluster bCluster. Exports created at the same time are ordered by cluster ID.
+func exportOlder(a *mcsCore.ServiceExport, aCluster cluster.ID, b *mcsCore.ServiceExport, bCluster cluster.ID) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return aCluster < bCluster
+}
+
+// startDraining keeps the endpoints of the service exported by the deleted ServiceExport discoverable from other
+// clusters for the unexportGrace, after which they revert to cluster-local.
+func (ec *serviceExportCacheImpl) startDraining(se *mcsCore.ServiceExport) {
+	if ec.unexportGrace <= 0 {
+		return
+	}
+	name := kubesr.NamespacedNameForK8sObject(se)
+	ec.mutex.Lock()
+	ec.draining[name] = se
+	ec.mutex.Unlock()
+
+	ec.afterGrace(ec.unexportGrace, func() error {
+		ec.mutex.Lock()
+		drained := ec.draining[name] == se
+		if drained {
+			delete(ec.draining, name)
+		}
+		ec.mutex.Unlock()
+		if !drained {
+			// The service was exported again in the meantime.
+			return nil
+		}
+		ec.updateExternalNameInstances(se)
+		ec.updateXDS(se)
+		ec.forgetService(name)
+		recordMCSSync(ec.Cluster())
+		return nil
+	})
+}
+
+// afterGrace pushes fn to the queue once the grace period elapsed, so that it is serialized with the handling of the
+// events. fn is dropped if the cache is stopped first (see stopTimers).
+func (ec *serviceExportCacheImpl) afterGrace(grace time.Duration, fn func() error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.timers == nil {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(grace, func() {
+		ec.mutex.Lock()
+		_, pending := ec.timers[timer]
+		delete(ec.timers, timer)
+		ec.mutex.Unlock()
+		if pending {
+			ec.queue.Push(fn)
+		}
+	})
+	ec.timers[timer] = struct{}{}
+}
+
+// stopTimers cancels the pending grace periods. No grace period starts afterwards.
+func (ec *serviceExportCacheImpl) stopTimers() {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	for timer := range ec.timers {
+		timer.Stop()
+	}
+	ec.timers = nil
+}
+
+// onServiceEvent forgets the state of the services deleted from the cluster (see forgetService) and, with
+// preferOldestExport, re-evaluates the conflicts between the exports of the service, which depend on its ports.
+func (ec *serviceExportCacheImpl) onServiceEvent(svc *model.Service, event model.Event) {
+	if strings.HasSuffix(svc.Hostname.String(), mcsDomainSuffix) {
+		return
+	}
+	name := namespacedNameForService(svc)
+	if event == model.EventDelete {
+		ec.forgetService(name)
+	}
+	se := ec.getServiceExport(name)
+	if !ec.preferOldestExport || se == nil {
+		// The ports of a service only matter to the conflicts if it is exported from this cluster.
+		return
+	}
+	if ec.updateConflict(name) {
+		ec.updateExternalNameInstances(se)
+		ec.updateXDS(se)
+	}
+	ec.updateConflictingExports(name)
+}
+
+// forgetService drops the state tracked for the given service, once it is deleted or it is no longer exported and
+// its endpoints were pushed accordingly.
+func (ec *serviceExportCacheImpl) forgetService(name types.NamespacedName) {
+	ec.mutex.Lock()
+	delete(ec.policies, name)
+	delete(ec.endpointCounts, name)
+	delete(ec.endpointEvents, name)
+	delete(ec.conflicts, name)
+	ec.mutex.Unlock()
+	ec.removeEndpointCount(name)
+}
+
+// onNamespaceEvent re-evaluates the discoverability of the services exported from a namespace when its labels change,
+// or when it starts terminating.
+func (ec *serviceExportCacheImpl) onNamespaceEvent(obj interface{}, event model.Event) error {
+	if event == model.EventDelete {
+		// The services in the namespace are deleted along with it.
+		return nil
+	}
+	ns, ok := obj.(*v1.Namespace)
+	if !ok {
+		return fmt.Errorf("unexpected object %#v for a Namespace event", obj)
+	}
+
+	exports, err := ec.lister.ServiceExports(ns.Name).List(klabels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, se := range exports {
+		ec.updateExternalNameInstances(se)
+		ec.updateXDS(se)
+		ec.updateStatus(se)
+	}
+	return nil
+}
+
+// onMeshConfigChange re-evaluates the discoverability of all exported services when the cluster-local hosts in the
+// mesh config change. Other changes to the mesh config are ignored.
+func (ec *serviceExportCacheImpl) onMeshConfigChange(mesh *meshconfig.MeshConfig) {
+	hosts := meshClusterLocalHosts(mesh)
+	ec.mutex.Lock()
+	changed := !reflect.DeepEqual(ec.clusterLocalHosts, hosts)
+	ec.clusterLocalHosts = hosts
+	ec.mutex.Unlock()
+	if !changed {
+		return
+	}
+
+	ec.queue.Push(ec.resync)
+}
+
+func (ec *serviceExportCacheImpl) runResync(stop <-chan struct{}) {
+	defer ec.stopTimers()
+	defer ec.forgetHostnameOverrides()
+	if ec.opts.MCSResyncPeriod <= 0 {
+		<-stop
+		return
+	}
+	ticker := time.NewTicker(ec.opts.MCSResyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ec.queue.Push(ec.resync)
+		}
+	}
+}
+
+// resync recomputes the discoverability of all of the exported services. Only the services whose policy differs
+// from the one last pushed are pushed (see policyChanged), so that a resync corrects any drift but is otherwise a
+// no-op.
+func (ec *serviceExportCacheImpl) resync() error {
 	exports, err := ec.lister.List(klabels.Everything())
 	if err != nil {
-		return make([]exportedService, 0)
+		return err
+	}
+	for _, se := range exports {
+		ec.updateExternalNameInstances(se)
+		ec.updateXDS(se)
+		ec.updateStatus(se)
 	}
+	recordMCSSync(ec.Cluster())
+	return nil
+}
 
-	ec.RLock()
+func (ec *serviceExportCacheImpl) runStatusWriter(stop <-chan struct{}) {
+	ec.statusWriter.Store(true)
+	defer ec.statusWriter.Store(false)
+	// The status may have gone stale while another istiod was writing it, or none was.
+	ec.queue.Push(ec.resyncStatus)
+	<-stop
+}
 
-	out := make([]exportedService, 0, len(exports))
-	for _, export := range exports {
-		es := exportedService{
-			namespacedName:  kubesr.NamespacedNameForK8sObject(export),
-			discoverability: make(map[host.Name]string),
+// resyncStatus updates the status of all of the ServiceExports in the cluster.
+func (ec *serviceExportCacheImpl) resyncStatus() error {
+	exports, err := ec.lister.List(klabels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, se := range exports {
+		ec.updateStatus(se)
+	}
+	return nil
+}
+
+// meshClusterLocalHosts returns the hosts marked as cluster-local by the service settings of the mesh config, sorted
+// in ascending order. Unlike model.ClusterLocalProvider, the default cluster-local hosts are not included, since the
+// services they cover are not expected to be exported.
+func meshClusterLocalHosts(mesh *meshconfig.MeshConfig) model.ClusterLocalHosts {
+	var hosts model.ClusterLocalHosts
+	for _, serviceSettings := range mesh.GetServiceSettings() {
+		if serviceSettings.GetSettings().GetClusterLocal() {
+			for _, h := range serviceSettings.Hosts {
+				hosts = append(hosts, host.Name(h))
+			}
 		}
+	}
+	sort.Sort(host.Names(hosts))
+	return hosts
+}
 
-		// Generate the map of all hosts for this service to their discoverability policies.
-		clusterLocalHost := kubesr.ServiceHostname(export.Name, export.Namespace, ec.opts.DomainSuffix)
-		clusterSetLocalHost := serviceClusterSetLocalHostname(es.namespacedName)
-		for _, hostName := range []host.Name{clusterLocalHost, clusterSetLocalHost} {
-			if svc := ec.servicesMap[hostName]; svc != nil {
-				es.discoverability[hostName] = ec.EndpointDiscoverabilityPolicy(svc).String()
+// namespaceDiscoverabilityEqual indicates whether an update to a Namespace can be ignored. Only the
+// exportNamespaceDiscoverabilityLabel and the phase affect the discoverability of the exported services.
+func namespaceDiscoverabilityEqual(old, cur interface{}) bool {
+	oldNs, ok := old.(*v1.Namespace)
+	if !ok {
+		return false
+	}
+	curNs, ok := cur.(*v1.Namespace)
+	if !ok {
+		return false
+	}
+	return oldNs.Labels[exportNamespaceDiscoverabilityLabel] == curNs.Labels[exportNamespaceDiscoverabilityLabel] &&
+		oldNs.Status.Phase == curNs.Status.Phase
+}
+
+// namespaceKeepsExportsLocal indicates whether the services exported from the given namespace are kept cluster-local,
+// either because the namespace is labeled so or because it is terminating. In the latter case the endpoints are about
+// to vanish, so other clusters should stop routing to them right away.
+func namespaceKeepsExportsLocal(ns *v1.Namespace) bool {
+	return ns.Labels[exportNamespaceDiscoverabilityLabel] == exportDiscoverabilityLocal || ns.Status.Phase == v1.NamespaceTerminating
+}
+
+// updateStatus writes the status of the ServiceExport (see setStatusConditions), if this istiod is the status writer
+// of the cluster (see runStatusWriter). An update conflicting with another write is retried against the latest
+// version of the ServiceExport.
+func (ec *serviceExportCacheImpl) updateStatus(se *mcsCore.ServiceExport) {
+	if !ec.statusWriter.Load() {
+		return
+	}
+	client := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(se.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		updated := se.DeepCopy()
+		if !ec.setStatusConditions(updated) {
+			return nil
+		}
+		_, err := client.UpdateStatus(context.TODO(), updated, metav1.UpdateOptions{})
+		if kerrors.IsConflict(err) {
+			latest, getErr := client.Get(context.TODO(), se.Name, metav1.GetOptions{})
+			if getErr != nil {
+				return getErr
 			}
+			se = latest
+		}
+		return err
+	})
+	if err != nil {
+		log.Warnf("failed updating status of ServiceExport %s/%s in cluster %s: %v", se.Namespace, se.Name, ec.Cluster(), err)
+	}
+}
+
+// setStatusConditions reports on the Valid condition of the ServiceExport whether the ports it references are exposed
+// by the service, on the HeldClusterLocal condition whether the endpoints are kept local to the cluster until the
+// service has the minimum number of endpoints, on the Discoverability condition the policy applied to the
+// endpoints, and on the Imported condition the other clusters importing the service. The Valid condition is left
+// untouched if the ServiceExport doesn't reference any ports. It returns false if none of the conditions changed.
+func (ec *serviceExportCacheImpl) setStatusConditions(se *mcsCore.ServiceExport) bool {
+	changed := false
+
+	if _, unknown, ok := ec.exportedPorts(se); ok {
+		cond := mcsCore.ServiceExportCondition{
+			Type:   mcsCore.ServiceExportValid,
+			Status: v1.ConditionTrue,
 		}
+		if len(unknown) > 0 {
+			reason := serviceExportReasonUnknownPort
+			message := fmt.Sprintf("ports %s are not exposed by the service and are not exported", strings.Join(unknown, ","))
+			cond.Status = v1.ConditionFalse
+			cond.Reason = &reason
+			cond.Message = &message
+		}
+		changed = setServiceExportCondition(se, cond) || changed
+	}
 
-		out = append(out, es)
+	if held, message := ec.heldClusterLocal(se); held {
+		reason := serviceExportReasonInsufficientEndpoints
+		changed = setServiceExportCondition(se, mcsCore.ServiceExportCondition{
+			Type:    serviceExportHeldClusterLocal,
+			Status:  v1.ConditionTrue,
+			Reason:  &reason,
+			Message: &message,
+		}) || changed
+	} else {
+		changed = removeServiceExportCondition(se, serviceExportHeldClusterLocal) || changed
 	}
 
-	ec.RUnlock()
+	policy := ec.exportedPolicy(se)
+	reason, message := discoverabilityReason(policy), policy.String()
+	applied := v1.ConditionTrue
+	if ec.dryRun {
+		applied = v1.ConditionFalse
+	}
+	changed = setServiceExportCondition(se, mcsCore.ServiceExportCondition{
+		Type:    serviceExportDiscoverability,
+		Status:  applied,
+		Reason:  &reason,
+		Message: &message,
+	}) || changed
+
+	return setServiceExportCondition(se, ec.importedCondition(se)) || changed
+}
+
+// importedCondition returns the Imported condition of the ServiceExport, listing the other clusters importing the
+// service, and records their number.
+func (ec *serviceExportCacheImpl) importedCondition(se *mcsCore.ServiceExport) mcsCore.ServiceExportCondition {
+	name := kubesr.NamespacedNameForK8sObject(se)
+	clusters := ec.importingClusters(name)
+	mcsServiceImportingClusters.With(serviceTag.Value(name.String()), clusterTag.Value(ec.Cluster().String())).
+		Record(float64(len(clusters)))
+
+	cond := mcsCore.ServiceExportCondition{
+		Type:   serviceExportImported,
+		Status: v1.ConditionFalse,
+	}
+	reason, message := serviceExportReasonNotImported, "the service is not imported by any other cluster"
+	if len(clusters) > 0 {
+		names := make([]string, 0, len(clusters))
+		for _, c := range clusters {
+			names = append(names, c.String())
+		}
+		cond.Status = v1.ConditionTrue
+		reason = serviceExportReasonImported
+		message = "the service is imported by other clusters: " + strings.Join(names, ",")
+	}
+	cond.Reason = &reason
+	cond.Message = &message
+	return cond
+}
 
+// importingClusters returns the other Kubernetes clusters in the mesh holding a ServiceImport for the given service,
+// sorted by ID.
+func (ec *serviceExportCacheImpl) importingClusters(name types.NamespacedName) []cluster.ID {
+	if ec.opts.MeshServiceController == nil {
+		return nil
+	}
+	var out []cluster.ID
+	for _, r := range ec.opts.MeshServiceController.GetRegistries() {
+		c, ok := r.(*Controller)
+		if !ok || c.Cluster() == ec.Cluster() {
+			continue
+		}
+		if c.imports.IsImported(name) {
+			out = append(out, c.Cluster())
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i] < out[j]
+	})
 	return out
 }
 
-func (ec *serviceExportCacheImpl) HasSynced() bool {
-	return ec.informer.HasSynced()
+// importsUpdated is called when the ServiceImport of the given service changed in another cluster, so that the
+// Imported condition of the ServiceExport of the service in this cluster, if any, reflects it.
+func (ec *serviceExportCacheImpl) importsUpdated(name types.NamespacedName) {
+	if se := ec.getServiceExport(name); se != nil {
+		ec.updateStatus(se)
+	}
 }
 
-type disabledServiceExportCache struct{}
+// discoverabilityReason returns the reason of the Discoverability condition for the given policy.
+func discoverabilityReason(policy model.EndpointDiscoverabilityPolicy) string {
+	switch policy {
+	case model.AlwaysDiscoverable:
+		return serviceExportReasonMeshWide
+	case model.DiscoverableFromSameCluster:
+		return serviceExportReasonClusterLocal
+	}
+	return serviceExportReasonFiltered
+}
 
-var _ serviceExportCache = disabledServiceExportCache{}
+// heldClusterLocal indicates whether the endpoints of the service exported by se are kept local to the cluster
+// because the service doesn't have the minimum number of endpoints, with a message explaining why.
+func (ec *serviceExportCacheImpl) heldClusterLocal(se *mcsCore.ServiceExport) (bool, string) {
+	threshold, ok := ec.minEndpoints(se)
+	if !ok {
+		return false, ""
+	}
+	ec.mutex.Lock()
+	endpoints := ec.endpointCounts[kubesr.NamespacedNameForK8sObject(se)]
+	ec.mutex.Unlock()
+	if endpoints >= threshold {
+		return false, ""
+	}
+	return true, fmt.Sprintf("the service has %d of the %d endpoints required to be discoverable from other clusters",
+		endpoints, threshold)
+}
 
-func (c disabledServiceExportCache) EndpointDiscoverabilityPolicy(*model.Service) model.EndpointDiscoverabilityPolicy {
-	return model.AlwaysDiscoverable
+// setServiceExportCondition sets the given condition on the ServiceExport, updating the transition time if its
+// status changed. It returns false if the condition was already set.
+func setServiceExportCondition(se *mcsCore.ServiceExport, cond mcsCore.ServiceExportCondition) bool {
+	for i, c := range se.Status.Conditions {
+		if c.Type != cond.Type {
+			continue
+		}
+		if reflect.DeepEqual(c.Status, cond.Status) && reflect.DeepEqual(c.Reason, cond.Reason) &&
+			reflect.DeepEqual(c.Message, cond.Message) {
+			// Nothing changed.
+			return false
+		}
+		cond.LastTransitionTime = c.LastTransitionTime
+		if c.Status != cond.Status {
+			now := metav1.Now()
+			cond.LastTransitionTime = &now
+		}
+		se.Status.Conditions[i] = cond
+		return true
+	}
+	now := metav1.Now()
+	cond.LastTransitionTime = &now
+	se.Status.Conditions = append(se.Status.Conditions, cond)
+	return true
+}
+
+// removeServiceExportCondition removes the condition of the given type from the ServiceExport. It returns false if
+// the condition was not set.
+func removeServiceExportCondition(se *mcsCore.ServiceExport, condType mcsCore.ServiceExportConditionType) bool {
+	for i, c := range se.Status.Conditions {
+		if c.Type == condType {
+			se.Status.Conditions = append(se.Status.Conditions[:i], se.Status.Conditions[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// updateExternalNameInstances refreshes the discoverability of the instances of an ExternalName service. These
+// are addressed by hostname and resolved by the proxy (STRICT_DNS), so they are not updated through EDS.
+func (ec *serviceExportCacheImpl) updateExternalNameInstances(se metav1.Object) {
+	if ec.dryRun {
+		return
+	}
+	updated := false
+	for _, svc := range ec.servicesForNamespacedName(kubesr.NamespacedNameForK8sObject(se)) {
+		policy := ec.EndpointDiscoverabilityPolicy(svc)
+
+		ec.Lock()
+		instances := ec.externalNameSvcInstanceMap[svc.Hostname]
+		if len(instances) > 0 && !hasDiscoverabilityPolicy(instances, policy) {
+			out := make([]*model.ServiceInstance, 0, len(instances))
+			for _, instance := range instances {
+				instance = instance.DeepCopy()
+				instance.Endpoint.DiscoverabilityPolicy = policy
+				out = append(out, instance)
+			}
+			ec.externalNameSvcInstanceMap[svc.Hostname] = out
+			updated = true
+		}
+		ec.Unlock()
+	}
+
+	if updated {
+		// The endpoints of DNS clusters are part of CDS, so a full push is required.
+		ec.opts.XDSUpdater.ConfigUpdate(&model.PushRequest{
+			Full: true,
+			ConfigsUpdated: map[model.ConfigKey]struct{}{{
+				Kind:      gvk.ServiceEntry,
+				Name:      ec.ClusterSetHostname(kubesr.NamespacedNameForK8sObject(se)).String(),
+				Namespace: se.GetNamespace(),
+			}: {}},
+			Reason: []model.TriggerReason{model.ServiceUpdate},
+		})
+	}
+}
+
+// hasDiscoverabilityPolicy indicates whether all of the instances already have the given policy, in which case
+// they don't need to be pushed again.
+func hasDiscoverabilityPolicy(instances []*model.ServiceInstance, policy model.EndpointDiscoverabilityPolicy) bool {
+	for _, instance := range instances {
+		current := instance.Endpoint.DiscoverabilityPolicy
+		if current == nil || current.String() != policy.String() {
+			return false
+		}
+	}
+	return true
 }
 
+// updateClusterSetHostname moves the synthetic clusterset.local service of the given service to the hostname
+// returned by ClusterSetHostname and the namespace returned by ClusterSetNamespace, if it has been generated under a
+// different hostname or namespace.
+func (ec *serviceExportCacheImpl) updateClusterSetHostname(name types.NamespacedName) {
+	hostname := ec.ClusterSetHostname(name)
+	namespace := ec.ClusterSetNamespace(name)
+
+	ec.mutex.Lock()
+	podInstances := ec.podInstances[name]
+	ec.mutex.Unlock()
+
+	var stale []*model.Service
+	ec.RLock()
+	for h, svc := range ec.servicesMap {
+		if _, isPodService := podInstances[h]; isPodService {
+			continue
+		}
+		if !strings.HasSuffix(h.String(), mcsDomainSuffix) || namespacedNameForService(svc) != name {
+			continue
+		}
+		if h != hostname || svc.Attributes.Namespace != namespace {
+			stale = append(stale, svc)
+		}
+	}
+	ec.RUnlock()
+
+	for _, svc := range stale {
+		ec.deleteService(svc)
+	}
+	if len(stale) > 0 && ec.GetService(hostname) == nil {
+		mcsService := stale[0].DeepCopy()
+		mcsService.Hostname = hostname
+		setClusterSetNamespace(mcsService, name, namespace)
+		ec.addOrUpdateService(nil, mcsService, model.EventAdd)
+	}
+}
+
+// hasPodClusterSetServices indicates whether the given service is headless, or has services synthesized for its pods
+// which may have to be removed, in which case updatePodClusterSetServices applies.
+func (ec *serviceExportCacheImpl) hasPodClusterSetServices(name types.NamespacedName) bool {
+	if svc := ec.GetService(kubesr.ServiceHostname(name.Name, name.Namespace, ec.opts.DomainSuffix)); svc != nil &&
+		svc.Resolution == model.Passthrough {
+		return true
+	}
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return len(ec.podInstances[name]) > 0
+}
+
+// podClusterSetHostname returns the clusterset.local hostname of a pod of an exported headless service, following
+// the MCS DNS specification: "<hostname>.<cluster id>.<service hostname>", e.g.
+// mysql-0.cluster-1.mysql.default.svc.clusterset.local. The cluster ID tells apart the pods of the same hostname
+// in different clusters.
+func podClusterSetHostname(podHostname string, clusterID cluster.ID, clusterSetHostname host.Name) host.Name {
+	return host.Name(podHostname + "." + clusterID.String() + "." + clusterSetHostname.String())
+}
+
+// updatePodClusterSetServices synthesizes a service for each named pod of the given service, if it is an exported
+// headless service, so that the pods are reachable at their own clusterset.local hostnames (see
+// podClusterSetHostname) as they are at their cluster.local ones. The service of a pod is a copy of the
+// cluster.local service, whose instances are only the endpoints of the pod. The services of the pods that are gone,
+// or of a service that is no longer exported, are removed.
+func (ec *serviceExportCacheImpl) updatePodClusterSetServices(name types.NamespacedName) {
+	instances := make(map[host.Name][]*model.ServiceInstance)
+	svc := ec.GetService(kubesr.ServiceHostname(name.Name, name.Namespace, ec.opts.DomainSuffix))
+	if svc != nil && svc.Resolution == model.Passthrough && ec.appliedServiceExport(name) != nil {
+		clusterSetHostname := ec.ClusterSetHostname(name)
+		for _, ep := range ec.buildEndpointsForService(svc, false) {
+			// Only the pods in the subdomain of the service have a hostname under the service.
+			if ep.HostName == "" || ep.SubDomain != name.Name {
+				continue
+			}
+			port, found := svc.Ports.Get(ep.ServicePortName)
+			if !found {
+				continue
+			}
+			hostname := podClusterSetHostname(ep.HostName, ec.Cluster(), clusterSetHostname)
+			var podService *model.Service
+			if prev := instances[hostname]; len(prev) > 0 {
+				podService = prev[0].Service
+			} else {
+				podService = svc.DeepCopy()
+				podService.Hostname = hostname
+			}
+
+			// The pod is addressed by the hostname of its service, so no further hostnames derive from the endpoint.
+			ep = ep.DeepCopy()
+			ep.HostName, ep.SubDomain = "", ""
+			ep.DiscoverabilityPolicy = ec.EndpointDiscoverabilityPolicy(podService)
+			instances[hostname] = append(instances[hostname], &model.ServiceInstance{
+				Service:     podService,
+				ServicePort: port,
+				Endpoint:    ep,
+			})
+		}
+	}
+
+	ec.mutex.Lock()
+	prev := ec.podInstances[name]
+	if len(instances) > 0 {
+		ec.podInstances[name] = instances
+	} else {
+		delete(ec.podInstances, name)
+	}
+	ec.mutex.Unlock()
+
+	for hostname := range prev {
+		if _, found := instances[hostname]; found {
+			continue
+		}
+		if podService := ec.GetService(hostname); podService != nil {
+			ec.deleteService(podService)
+		}
+	}
+	for hostname, podInstances := range instances {
+		event := model.EventAdd
+		if prevInstances, found := prev[hostname]; found {
+			if instanceEndpointsEqual(prevInstances, podInstances) {
+				continue
+			}
+			event = model.EventUpdate
+		}
+		ec.addOrUpdateService(nil, podInstances[0].Service, event)
+	}
+}
+
+// instanceEndpointsEqual indicates whether the two lists of instances have the same endpoints on the same ports.
+func instanceEndpointsEqual(a, b []*model.ServiceInstance) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ServicePort.Port != b[i].ServicePort.Port || !reflect.DeepEqual(a[i].Endpoint, b[i].Endpoint) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ec *serviceExportCacheImpl) PodInstancesByPort(svc *model.Service, port int,
+	labelsList labels.Collection) (instances []*model.ServiceInstance, ok bool) {
+	if !strings.HasSuffix(svc.Hostname.String(), mcsDomainSuffix) {
+		return nil, false
+	}
+	ec.mutex.Lock()
+	podInstances, ok := ec.podInstances[namespacedNameForService(svc)][svc.Hostname]
+	ec.mutex.Unlock()
+	if !ok {
+		return nil, false
+	}
+	for _, instance := range podInstances {
+		if instance.ServicePort.Port == port && labelsList.HasSubsetOf(instance.Endpoint.Labels) {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, true
+}
+
+// updateClusterSetService applies the settings of the ServiceExport to the synthetic clusterset.local service, if
+// it has been generated.
+func (ec *serviceExportCacheImpl) updateClusterSetService(se metav1.Object) {
+	mcsService := ec.GetService(ec.ClusterSetHostname(kubesr.NamespacedNameForK8sObject(se)))
+	if mcsService == nil {
+		return
+	}
+
+	lb := ec.LoadBalancerPolicy(kubesr.NamespacedNameForK8sObject(se))
+	if reflect.DeepEqual(lb, mcsService.Attributes.LoadBalancer) {
+		return
+	}
+
+	mcsService = mcsService.DeepCopy()
+	mcsService.Attributes.LoadBalancer = lb
+	ec.addOrUpdateService(nil, mcsService, model.EventUpdate)
+}
+
+// serviceExportsEqual indicates whether an update to a ServiceExport can be ignored. Only the annotations
+// affect the discoverability of the endpoints, while the generation is recorded on the endpoints.
+func serviceExportsEqual(old, cur interface{}) bool {
+	oldSe, ok := old.(*mcsCore.ServiceExport)
+	if !ok {
+		return false
+	}
+	curSe, ok := cur.(*mcsCore.ServiceExport)
+	if !ok {
+		return false
+	}
+	return oldSe.Generation == curSe.Generation && reflect.DeepEqual(oldSe.Annotations, curSe.Annotations)
+}
+
+func (ec *serviceExportCacheImpl) updateXDS(se metav1.Object) {
+	if ec.dryRun {
+		// The exports aren't applied, so the endpoints are unaffected.
+		return
+	}
+	for _, svc := range ec.servicesForNamespacedName(kubesr.NamespacedNameForK8sObject(se)) {
+		// Only the discoverability of the endpoints changes here, so only push the services whose policy
+		// actually changed. With cluster.local mode, for example, only the clusterset.local host is affected.
+		if !ec.policyChanged(svc) {
+			continue
+		}
+
+		// Re-build the endpoints for this service with a new discoverability policy.
+		// Also update any internal caching. The endpoints are the union of all of the
+		// EndpointSlices for the service, so a single event covers the whole service.
+		endpoints := ec.buildEndpointsForService(svc, true)
+		shard := model.ShardKeyFromRegistry(ec)
+		ec.opts.XDSUpdater.EDSUpdate(shard, svc.Hostname.String(), svc.Attributes.Namespace, endpoints)
+		if !strings.HasSuffix(svc.Hostname.String(), mcsDomainSuffix) {
+			ec.EndpointsUpdated(kubesr.NamespacedNameForK8sObject(se), endpointAddressCount(endpoints))
+		}
+	}
+}
+
+// policyChanged records the current discoverability policy for the service and indicates whether it differs from
+// the policy last pushed for it. The filters of the policy are named after their settings, so policies with the
+// same name behave the same. The other attributes the ServiceExport stamps onto the endpoints are part of the
+// policy, so that the endpoints are pushed again when they change.
+func (ec *serviceExportCacheImpl) policyChanged(svc *model.Service) bool {
+	// The endpoints are also stamped with the generation of the ServiceExport and, if exported, their TLS mode. The
+	// annotations may change without the generation, e.g. as they are not part of the spec.
+	policy := fmt.Sprintf("%s@%d,tlsMode=%t", ec.EndpointDiscoverabilityPolicy(svc), ec.ExportGeneration(svc),
+		ec.ExportsTLSMode(svc))
+
+	name := namespacedNameForService(svc)
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if prev, ok := ec.policies[name][svc.Hostname]; ok && prev == policy {
+		return false
+	}
+	if ec.policies[name] == nil {
+		ec.policies[name] = make(map[host.Name]string)
+	}
+	ec.policies[name][svc.Hostname] = policy
+	return true
+}
+
+// endpointAddressCount returns the number of distinct addresses of the endpoints, i.e. the number of pods or
+// workloads backing them. A service has an IstioEndpoint per port of each address, so the endpoints of a single pod
+// of a service with three ports only count once.
+func endpointAddressCount(endpoints []*model.IstioEndpoint) int {
+	addresses := make(map[string]struct{}, len(endpoints))
+	for _, ep := range endpoints {
+		addresses[ep.Address] = struct{}{}
+	}
+	return len(addresses)
+}
+
+func (ec *serviceExportCacheImpl) EndpointsUpdated(name types.NamespacedName, endpoints int) {
+	if ec.getServiceExport(name) == nil {
+		// Only the endpoints of the exported services are tracked. They are counted once exported (see
+		// trackEndpointCount). The services of the pods of a service are removed when it is unexported.
+		return
+	}
+
+	// The pods of an exported headless service may have changed.
+	if ec.hasPodClusterSetServices(name) {
+		ec.updatePodClusterSetServices(name)
+	}
+
+	ec.mutex.Lock()
+	ec.endpointEvents[name]++
+	event := ec.endpointEvents[name]
+	removed := endpoints < ec.endpointCounts[name]
+	ec.mutex.Unlock()
+
+	if removed && ec.endpointRemovalGrace > 0 {
+		// Apply the removal of endpoints after the grace period, unless the count changes again in the meantime, so
+		// that endpoints that briefly go away don't change the discoverability of the service.
+		ec.afterGrace(ec.endpointRemovalGrace, func() error {
+			ec.mutex.Lock()
+			superseded := ec.endpointEvents[name] != event
+			ec.mutex.Unlock()
+			if !superseded {
+				ec.setEndpointCount(name, endpoints)
+			}
+			return nil
+		})
+		return
+	}
+	ec.setEndpointCount(name, endpoints)
+}
+
+// setEndpointCount records the number of endpoints of the given service, as reported by EndpointsUpdated.
+func (ec *serviceExportCacheImpl) setEndpointCount(name types.NamespacedName, endpoints int) {
+	se := ec.getServiceExport(name)
+	if se == nil {
+		// The service was unexported since, e.g. during endpointRemovalGrace.
+		return
+	}
+
+	ec.mutex.Lock()
+	prev := ec.endpointCounts[name]
+	ec.endpointCounts[name] = endpoints
+	ec.mutex.Unlock()
+
+	ec.recordEndpointCount(name, endpoints)
+	recordMCSSync(ec.Cluster())
+
+	// The endpoints were built with the policy for the previous count. Re-push them if the count crossed the
+	// minimum required for the endpoints to be discoverable from other clusters, and report the count in the
+	// status.
+	if threshold, ok := ec.minEndpoints(se); ok && (prev >= threshold) != (endpoints >= threshold) {
+		ec.updateXDS(se)
+	}
+	ec.updateStatus(se)
+}
+
+// trackEndpointCount starts counting the endpoints of the given service once it is exported, from the endpoints
+// currently in the cluster, unless they are already counted.
+func (ec *serviceExportCacheImpl) trackEndpointCount(name types.NamespacedName) {
+	ec.mutex.Lock()
+	_, tracked := ec.endpointCounts[name]
+	ec.mutex.Unlock()
+	if tracked {
+		return
+	}
+	svc := ec.GetService(kubesr.ServiceHostname(name.Name, name.Namespace, ec.opts.DomainSuffix))
+	if svc == nil {
+		// The endpoints are counted once the service is created (see EndpointsUpdated).
+		return
+	}
+	ec.setEndpointCount(name, endpointAddressCount(ec.buildEndpointsForService(svc, false)))
+}
+
+// recordEndpointCount sets the pilot_mcs_service_endpoints gauge of the given exported service.
+func (ec *serviceExportCacheImpl) recordEndpointCount(name types.NamespacedName, endpoints int) {
+	entry, err := mcsServiceEndpoints.GetEntry(metricdata.NewLabelValue(name.String()),
+		metricdata.NewLabelValue(ec.Cluster().String()))
+	if err != nil {
+		log.Warnf("failed recording the endpoints of service %s in cluster %s: %v", name, ec.Cluster(), err)
+		return
+	}
+	entry.Set(int64(endpoints))
+}
+
+// removeEndpointCount removes the pilot_mcs_service_endpoints gauge of the given service, once it is no longer
+// exported or no longer exists.
+func (ec *serviceExportCacheImpl) removeEndpointCount(name types.NamespacedName) {
+	if err := mcsServiceEndpoints.RemoveEntry(metricdata.NewLabelValue(name.String()),
+		metricdata.NewLabelValue(ec.Cluster().String())); err != nil {
+		log.Warnf("failed removing the endpoints of service %s in cluster %s: %v", name, ec.Cluster(), err)
+	}
+}
+
+// minEndpoints returns the minimum number of endpoints required by the ServiceExport for the endpoints to be
+// discoverable from other clusters. ok is false if the ServiceExport doesn't require a minimum.
+func (ec *serviceExportCacheImpl) minEndpoints(se *mcsCore.ServiceExport) (threshold int, ok bool) {
+	value, found := se.Annotations[exportMinEndpointsAnnotation]
+	if !found {
+		return 0, false
+	}
+	threshold, err := strconv.Atoi(value)
+	if err != nil || threshold < 0 {
+		log.Warnf("ignoring invalid %s annotation value %q on ServiceExport %s/%s in cluster %s",
+			exportMinEndpointsAnnotation, value, se.Namespace, se.Name, ec.Cluster())
+		return 0, false
+	}
+	return threshold, true
+}
+
+func (ec *serviceExportCacheImpl) EndpointDiscoverabilityPolicy(svc *model.Service) model.EndpointDiscoverabilityPolicy {
+	if svc == nil {
+		// Default policy when the service doesn't exist.
+		return model.DiscoverableFromSameCluster
+	}
+
+	if strings.HasSuffix(svc.Hostname.String(), "."+constants.DefaultClusterSetLocalDomain) {
+		return ec.clusterSetLocalPolicySelector(svc)
+	}
+
+	return ec.clusterLocalPolicySelector(svc)
+}
+
+func (ec *serviceExportCacheImpl) ClusterSetHostname(name types.NamespacedName) host.Name {
+	// All of the clusters must agree on the hostname of the service, including those only importing it, so the
+	// override of the exporting cluster with the lowest ID applies across the mesh.
+	if override, ok := ec.meshHostnameOverrides.get(name); ok {
+		return override
+	}
+	return ec.defaultClusterSetHostname(name)
+}
+
+func (ec *serviceExportCacheImpl) ClusterSetNamespace(name types.NamespacedName) string {
+	canonical := canonicalNamespace(name.Namespace)
+	if canonical == name.Namespace {
+		return canonical
+	}
+	// The service would be merged with a different service of the same name exported from the canonical namespace
+	// in this cluster, so the mapping is ignored.
+	if ec.isExported(types.NamespacedName{Namespace: canonical, Name: name.Name}) {
+		log.Debugf("ignoring the canonical namespace %s of service %s in cluster %s: a service of the same name is exported from it",
+			canonical, name, ec.Cluster())
+		return name.Namespace
+	}
+	return canonical
+}
+
+// defaultClusterSetHostname returns the clusterset.local hostname of the given service without any override, in the
+// namespace returned by ClusterSetNamespace.
+func (ec *serviceExportCacheImpl) defaultClusterSetHostname(name types.NamespacedName) host.Name {
+	return clusterSetLocalHostname(name.Name, ec.ClusterSetNamespace(name))
+}
+
+// updateHostnameOverrides updates the clusterset.local hostname overrides of the services exported in this cluster
+// once the ServiceExports of the given services changed, returning the services whose override changed. As an
+// override is ignored if another export claims the same hostname, the overrides of the services claiming the
+// hostnames the given services claimed before, or claim now, are reevaluated as well.
+func (ec *serviceExportCacheImpl) updateHostnameOverrides(names ...types.NamespacedName) []types.NamespacedName {
+	// Each export claims its default hostname and the override it requests, if any.
+	claims := make(map[types.NamespacedName][]host.Name, len(names))
+	requested := make(map[types.NamespacedName]host.Name, len(names))
+	for _, name := range names {
+		se := ec.appliedServiceExport(name)
+		if se == nil {
+			continue
+		}
+		claims[name] = []host.Name{ec.defaultClusterSetHostname(name)}
+		if override, ok := ec.requestedHostname(se); ok {
+			claims[name] = append(claims[name], override)
+			requested[name] = override
+		}
+	}
+
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	affected := make(map[types.NamespacedName]struct{})
+	for _, name := range names {
+		affected[name] = struct{}{}
+		for _, hostname := range ec.claimedHostnames[name] {
+			delete(ec.hostnameClaims[hostname], name)
+			for other := range ec.hostnameClaims[hostname] {
+				affected[other] = struct{}{}
+			}
+			if len(ec.hostnameClaims[hostname]) == 0 {
+				delete(ec.hostnameClaims, hostname)
+			}
+		}
+		delete(ec.claimedHostnames, name)
+		delete(ec.requestedHostnames, name)
+	}
+	for name, hostnames := range claims {
+		for _, hostname := range hostnames {
+			if ec.hostnameClaims[hostname] == nil {
+				ec.hostnameClaims[hostname] = make(map[types.NamespacedName]struct{})
+			}
+			for other := range ec.hostnameClaims[hostname] {
+				affected[other] = struct{}{}
+			}
+			ec.hostnameClaims[hostname][name] = struct{}{}
+		}
+		ec.claimedHostnames[name] = hostnames
+		if override, ok := requested[name]; ok {
+			ec.requestedHostnames[name] = override
+		}
+	}
+
+	var changed []types.NamespacedName
+	for name := range affected {
+		override, ok := ec.validHostnameOverride(name)
+		if prev, found := ec.hostnameOverrides[name]; found == ok && prev == override {
+			continue
+		}
+		if ok {
+			ec.hostnameOverrides[name] = override
+		} else {
+			delete(ec.hostnameOverrides, name)
+		}
+		ec.meshHostnameOverrides.set(name, ec.Cluster(), override)
+		changed = append(changed, name)
+	}
+	return changed
+}
+
+// validHostnameOverride returns the hostname override requested by the ServiceExport of the given service, unless
+// another export claims the same hostname or it is the default hostname of the service. ec.mutex must be held.
+func (ec *serviceExportCacheImpl) validHostnameOverride(name types.NamespacedName) (override host.Name, ok bool) {
+	override, ok = ec.requestedHostnames[name]
+	if !ok || override == ec.claimedHostnames[name][0] {
+		return "", false
+	}
+	for other := range ec.hostnameClaims[override] {
+		if other != name {
+			log.Warnf("ignoring %s annotation on ServiceExport %s in cluster %s: hostname %s conflicts with ServiceExport %s",
+				exportHostnameAnnotation, name, ec.Cluster(), override, other)
+			return "", false
+		}
+	}
+	return override, true
+}
+
+// requestedHostname returns the clusterset.local hostname override requested by se. ok is false if se doesn't
+// request a valid one.
+func (ec *serviceExportCacheImpl) requestedHostname(se *mcsCore.ServiceExport) (override host.Name, ok bool) {
+	value, found := se.Annotations[exportHostnameAnnotation]
+	if !found {
+		return "", false
+	}
+	if !strings.HasSuffix(value, mcsDomainSuffix) || len(validation.IsDNS1123Subdomain(value)) > 0 {
+		log.Warnf("ignoring invalid %s annotation value %q on ServiceExport %s/%s in cluster %s: must be a hostname in the %s domain",
+			exportHostnameAnnotation, value, se.Namespace, se.Name, ec.Cluster(), constants.DefaultClusterSetLocalDomain)
+		return "", false
+	}
+	return host.Name(value), true
+}
+
+// forgetHostnameOverrides removes the hostname overrides of this cluster from the mesh once its cache is stopped,
+// e.g. as the cluster leaves the mesh, and moves the synthetic clusterset.local services of the other clusters
+// accordingly.
+func (ec *serviceExportCacheImpl) forgetHostnameOverrides() {
+	for _, name := range ec.meshHostnameOverrides.removeCluster(ec.Cluster()) {
+		ec.notifyHostnameOverride(name)
+	}
+}
+
+// notifyHostnameOverride moves the synthetic clusterset.local service of the given service in the other clusters of
+// the mesh once its hostname override changed in this cluster.
+func (ec *serviceExportCacheImpl) notifyHostnameOverride(name types.NamespacedName) {
+	for _, peer := range ec.peerExportCaches() {
+		peer := peer
+		peer.queue.Push(func() error {
+			peer.updateClusterSetHostname(name)
+			return nil
+		})
+	}
+}
+
+// hostnameOverrideIndex holds the valid clusterset.local hostname overrides of the exported services in all of the
+// clusters of the mesh, by service and then by cluster. It is shared by the export caches of the clusters, each of
+// which records its own overrides in updateHostnameOverrides.
+type hostnameOverrideIndex struct {
+	mutex     sync.RWMutex
+	overrides map[types.NamespacedName]map[cluster.ID]host.Name
+}
+
+func newHostnameOverrideIndex() *hostnameOverrideIndex {
+	return &hostnameOverrideIndex{overrides: make(map[types.NamespacedName]map[cluster.ID]host.Name)}
+}
+
+// get returns the override of the given service applying across the mesh, i.e. that of the exporting cluster with
+// the lowest ID. ok is false if no cluster exports the service with a valid override.
+func (i *hostnameOverrideIndex) get(name types.NamespacedName) (override host.Name, ok bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	var owner cluster.ID
+	for clusterID, hostname := range i.overrides[name] {
+		if !ok || clusterID < owner {
+			override, owner, ok = hostname, clusterID, true
+		}
+	}
+	return override, ok
+}
+
+// set records the override of the given service in the given cluster, or removes it if override is empty.
+func (i *hostnameOverrideIndex) set(name types.NamespacedName, clusterID cluster.ID, override host.Name) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if override == "" {
+		delete(i.overrides[name], clusterID)
+		if len(i.overrides[name]) == 0 {
+			delete(i.overrides, name)
+		}
+		return
+	}
+	if i.overrides[name] == nil {
+		i.overrides[name] = make(map[cluster.ID]host.Name)
+	}
+	i.overrides[name][clusterID] = override
+}
+
+// removeCluster removes the overrides of the given cluster, returning the services that had one.
+func (i *hostnameOverrideIndex) removeCluster(clusterID cluster.ID) []types.NamespacedName {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	var removed []types.NamespacedName
+	for name, overrides := range i.overrides {
+		if _, ok := overrides[clusterID]; !ok {
+			continue
+		}
+		delete(overrides, clusterID)
+		if len(overrides) == 0 {
+			delete(i.overrides, name)
+		}
+		removed = append(removed, name)
+	}
+	return removed
+}
+
+func (ec *serviceExportCacheImpl) isExported(name types.NamespacedName) bool {
+	return ec.getServiceExport(name) != nil
+}
+
+func (ec *serviceExportCacheImpl) getServiceExport(name types.NamespacedName) *mcsCore.ServiceExport {
+	se, err := ec.lister.ServiceExports(name.Namespace).Get(name.Name)
+	if err != nil {
+		return nil
+	}
+	return se
+}
+
+// appliedServiceExport returns the ServiceExport of the given service if it applies to the service, i.e. unless
+// the controller runs in dry-run mode, in which the service is treated as unexported.
+func (ec *serviceExportCacheImpl) appliedServiceExport(name types.NamespacedName) *mcsCore.ServiceExport {
+	if ec.dryRun {
+		return nil
+	}
+	return ec.getServiceExport(name)
+}
+
+// getServiceExportOrDraining returns the applied ServiceExport of the given service or, if it was deleted less than
+// unexportGrace ago, the deleted ServiceExport.
+func (ec *serviceExportCacheImpl) getServiceExportOrDraining(name types.NamespacedName) *mcsCore.ServiceExport {
+	if ec.dryRun {
+		return nil
+	}
+	if se := ec.getServiceExport(name); se != nil {
+		return se
+	}
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.draining[name]
+}
+
+func (ec *serviceExportCacheImpl) LoadBalancerPolicy(name types.NamespacedName) *networking.LoadBalancerSettings_SimpleLB {
+	se := ec.appliedServiceExport(name)
+	if se == nil {
+		return nil
+	}
+	value, ok := se.Annotations[exportLoadBalancerAnnotation]
+	if !ok {
+		return nil
+	}
+	lb, ok := networking.LoadBalancerSettings_SimpleLB_value[value]
+	if !ok {
+		log.Warnf("ignoring unknown %s annotation value %q on ServiceExport %s/%s in cluster %s",
+			exportLoadBalancerAnnotation, value, se.Namespace, se.Name, ec.Cluster())
+		return nil
+	}
+	out := networking.LoadBalancerSettings_SimpleLB(lb)
+	return &out
+}
+
+func (ec *serviceExportCacheImpl) ExportGeneration(svc *model.Service) int64 {
+	if svc == nil {
+		return 0
+	}
+	if se := ec.appliedServiceExport(namespacedNameForService(svc)); se != nil {
+		return se.Generation
+	}
+	return 0
+}
+
+func (ec *serviceExportCacheImpl) PodExportable(pod *v1.Pod) bool {
+	// Endpoints without a pod, e.g. of a Service without selector, have no phase.
+	return pod == nil || pod.Status.Phase == v1.PodRunning
+}
+
+func (ec *serviceExportCacheImpl) ExportsTLSMode(svc *model.Service) bool {
+	if svc == nil {
+		return false
+	}
+	se := ec.appliedServiceExport(namespacedNameForService(svc))
+	return se != nil && se.Annotations[exportTLSModeAnnotation] == "true"
+}
+
+func (ec *serviceExportCacheImpl) EndpointALPNHints(svc *model.Service) map[string][]string {
+	if svc == nil || ec.appliedServiceExport(namespacedNameForService(svc)) == nil {
+		return nil
+	}
+	hints := make(map[string][]string)
+	for _, port := range svc.Ports {
+		if alpn := alpnForProtocol(port.Protocol); alpn != nil {
+			hints[port.Name] = alpn
+		}
+	}
+	return hints
+}
+
+// alpnForProtocol returns the ALPN protocols negotiated by the clusters of a port with the given protocol, or nil
+// if the protocol doesn't use HTTP.
+func alpnForProtocol(p protocol.Instance) []string {
+	switch {
+	case p.IsHTTP2():
+		return util.ALPNH2Only
+	case p.IsHTTP():
+		return util.ALPNHttp
+	}
+	return nil
+}
+
+func (ec *serviceExportCacheImpl) EndpointLbWeight(svc *model.Service, pod *v1.Pod) uint32 {
+	if svc == nil || ec.appliedServiceExport(namespacedNameForService(svc)) == nil {
+		return 0
+	}
+	weight := ec.healthWeight(pod)
+	capacity := ec.opts.ClusterCapacities[ec.Cluster()]
+	if capacity == 0 {
+		return weight
+	}
+	// Endpoints without a health check pass rate count as healthy, so that the weights of all of the endpoints
+	// contributed by the cluster scale with its capacity.
+	if weight == 0 {
+		weight = healthWeightScale
+	}
+	return weight * capacity
+}
+
+// healthWeight returns the load balancing weight of an endpoint backed by the pod, derived from the health check
+// pass rate of the pod, or 0 if the pod has no valid healthCheckPassRateAnnotation.
+func (ec *serviceExportCacheImpl) healthWeight(pod *v1.Pod) uint32 {
+	if pod == nil {
+		return 0
+	}
+	value, ok := pod.Annotations[healthCheckPassRateAnnotation]
+	if !ok {
+		return 0
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		log.Warnf("ignoring invalid %s annotation value %q on Pod %s/%s in cluster %s",
+			healthCheckPassRateAnnotation, value, pod.Namespace, pod.Name, ec.Cluster())
+		return 0
+	}
+	// Endpoints failing all of their health checks keep the minimum weight, leaving outlier detection to decide
+	// whether they receive traffic at all.
+	if weight := uint32(math.Round(rate * healthWeightScale)); weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// exportedPorts returns the names of the service ports referenced by the exportPortsAnnotation of the ServiceExport,
+// along with the referenced ports that the service doesn't expose. ok is false if the annotation isn't set.
+func (ec *serviceExportCacheImpl) exportedPorts(se *mcsCore.ServiceExport) (ports map[string]bool, unknown []string, ok bool) {
+	value, ok := se.Annotations[exportPortsAnnotation]
+	if !ok {
+		return nil, nil, false
+	}
+
+	ports = make(map[string]bool)
+	svc := ec.GetService(kubesr.ServiceHostname(se.Name, se.Namespace, ec.opts.DomainSuffix))
+	if svc == nil {
+		// The service doesn't exist yet, so there is nothing to validate against.
+		return ports, nil, true
+	}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, found := svc.Ports.Get(name); found {
+			ports[name] = true
+		} else {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return ports, unknown, true
+}
+
+// exportedPolicy returns the discoverability policy for the endpoints of a service exported by the given ServiceExport.
+func (ec *serviceExportCacheImpl) exportedPolicy(se *mcsCore.ServiceExport) model.EndpointDiscoverabilityPolicy {
+	if hint, ok := se.Annotations[exportDiscoverabilityAnnotation]; ok {
+		if hint == exportDiscoverabilityLocal {
+			return model.DiscoverableFromSameCluster
+		}
+		log.Warnf("ignoring unknown %s annotation value %q on ServiceExport %s/%s in cluster %s",
+			exportDiscoverabilityAnnotation, hint, se.Namespace, se.Name, ec.Cluster())
+	}
+	if ns, err := ec.nsLister.Get(se.Namespace); err == nil && namespaceKeepsExportsLocal(ns) {
+		return model.DiscoverableFromSameCluster
+	}
+	ec.mutex.Lock()
+	clusterLocalHosts := ec.clusterLocalHosts
+	ec.mutex.Unlock()
+	if clusterLocalHosts.IsClusterLocal(kubesr.ServiceHostname(se.Name, se.Namespace, ec.opts.DomainSuffix)) {
+		return model.DiscoverableFromSameCluster
+	}
+	if ec.preferOldestExport {
+		if _, conflict := ec.conflictingExport(kubesr.NamespacedNameForK8sObject(se)); conflict {
+			// The older export defines the ports of the service across the mesh.
+			return model.DiscoverableFromSameCluster
+		}
+	}
+	if threshold, ok := ec.minEndpoints(se); ok {
+		ec.mutex.Lock()
+		endpoints := ec.endpointCounts[kubesr.NamespacedNameForK8sObject(se)]
+		ec.mutex.Unlock()
+		if endpoints < threshold {
+			return model.DiscoverableFromSameCluster
+		}
+	}
+
+	var filters []endpointFilter
+
+	if value, ok := se.Annotations[exportEndpointSelectorAnnotation]; ok {
+		selector, err := klabels.Parse(value)
+		if err != nil {
+			// Fail closed, rather than exposing endpoints the user intended to keep within the cluster.
+			log.Warnf("invalid %s annotation on ServiceExport %s/%s in cluster %s: %v",
+				exportEndpointSelectorAnnotation, se.Namespace, se.Name, ec.Cluster(), err)
+			return model.DiscoverableFromSameCluster
+		}
+		filters = append(filters, endpointFilter{
+			name: "EndpointSelector(" + selector.String() + ")",
+			accept: func(ep *model.IstioEndpoint, _ *model.Proxy) bool {
+				return selector.Matches(klabels.Set(ep.Labels))
+			},
+		})
+	}
+
+	if se.Annotations[exportRequireMutualTLSAnnotation] == "true" {
+		filters = append(filters, endpointFilter{
+			name: "RequireMutualTLS",
+			accept: func(_ *model.IstioEndpoint, p *model.Proxy) bool {
+				return p != nil && p.Metadata != nil && mutualTLSModes[p.Metadata.Labels[label.SecurityTlsMode.Name]]
+			},
+		})
+	}
+
+	if canaryLabel := features.MCSCanaryLabel; canaryLabel != "" {
+		filters = append(filters, endpointFilter{
+			name: "ExcludeCanary(" + canaryLabel + ")",
+			accept: func(ep *model.IstioEndpoint, _ *model.Proxy) bool {
+				_, canary := ep.Labels[canaryLabel]
+				return !canary
+			},
+		})
+	}
+
+	if value, ok := se.Annotations[exportZonesAnnotation]; ok {
+		zones := make(map[string]bool)
+		for _, zone := range strings.Split(value, ",") {
+			if zone = strings.TrimSpace(zone); zone != "" {
+				zones[zone] = true
+			}
+		}
+		filters = append(filters, endpointFilter{
+			name: "Zones(" + value + ")",
+			accept: func(ep *model.IstioEndpoint, _ *model.Proxy) bool {
+				_, zone, _ := model.SplitLocalityLabel(ep.Locality.Label)
+				return zones[zone]
+			},
+		})
+	}
+
+	if value, ok := se.Annotations[exportClusterGroupsAnnotation]; ok {
+		members := make(map[cluster.ID]bool)
+		for _, group := range strings.Split(value, ",") {
+			if group = strings.TrimSpace(group); group == "" {
+				continue
+			}
+			clusters, found := ec.opts.ClusterGroups[group]
+			if !found {
+				// Fail closed: the group doesn't add any clusters.
+				log.Warnf("ignoring unknown cluster group %q in %s annotation on ServiceExport %s/%s in cluster %s",
+					group, exportClusterGroupsAnnotation, se.Namespace, se.Name, ec.Cluster())
+				continue
+			}
+			for _, c := range clusters {
+				members[c] = true
+			}
+		}
+		filters = append(filters, endpointFilter{
+			name: "ClusterGroups(" + value + ")",
+			accept: func(_ *model.IstioEndpoint, p *model.Proxy) bool {
+				return p != nil && p.Metadata != nil && members[p.Metadata.ClusterID]
+			},
+		})
+	}
+
+	if ports, _, ok := ec.exportedPorts(se); ok {
+		names := make([]string, 0, len(ports))
+		for name := range ports {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		filters = append(filters, endpointFilter{
+			name: "Ports(" + strings.Join(names, ",") + ")",
+			accept: func(ep *model.IstioEndpoint, _ *model.Proxy) bool {
+				return ports[ep.ServicePortName]
+			},
+		})
+	}
+
+	if len(filters) == 0 {
+		return model.AlwaysDiscoverable
+	}
+	return &filteredDiscoverabilityPolicy{filters: filters}
+}
+
+func (ec *serviceExportCacheImpl) ExportedServices() []exportedService {
+	// List all exports in this cluster.
+	exports, err := ec.lister.List(klabels.Everything())
+	if err != nil {
+		return make([]exportedService, 0)
+	}
+
+	ec.RLock()
+
+	out := make([]exportedService, 0, len(exports))
+	for _, export := range exports {
+		es := exportedService{
+			namespacedName:  kubesr.NamespacedNameForK8sObject(export),
+			discoverability: make(map[host.Name]string),
+		}
+
+		// Generate the map of all hosts for this service to their discoverability policies.
+		clusterLocalHost := kubesr.ServiceHostname(export.Name, export.Namespace, ec.opts.DomainSuffix)
+		clusterSetLocalHost := ec.ClusterSetHostname(es.namespacedName)
+		for _, hostName := range []host.Name{clusterLocalHost, clusterSetLocalHost} {
+			if svc := ec.servicesMap[hostName]; svc != nil {
+				es.discoverability[hostName] = ec.EndpointDiscoverabilityPolicy(svc).String()
+			}
+		}
+		if svc := ec.servicesMap[clusterLocalHost]; svc != nil {
+			es.vips = svc.ClusterVIPs.GetAddressesFor(ec.Cluster())
+		}
+
+		out = append(out, es)
+	}
+
+	ec.RUnlock()
+
+	return out
+}
+
+func (ec *serviceExportCacheImpl) HasSynced() bool {
+	return ec.informer.HasSynced()
+}
+
+// endpointFilter restricts the discoverability of endpoints from proxies in other clusters.
+type endpointFilter struct {
+	name   string
+	accept func(ep *model.IstioEndpoint, p *model.Proxy) bool
+}
+
+// filteredDiscoverabilityPolicy is an EndpointDiscoverabilityPolicy for the endpoints of an exported service. An
+// endpoint is always discoverable from within the same cluster, but is only discoverable from other clusters if the
+// proxy supports its address family and it is accepted by all of the filters.
+type filteredDiscoverabilityPolicy struct {
+	filters []endpointFilter
+}
+
+var _ model.EndpointDiscoverabilityPolicy = &filteredDiscoverabilityPolicy{}
+
+func (p *filteredDiscoverabilityPolicy) IsDiscoverableFromProxy(ep *model.IstioEndpoint, proxy *model.Proxy) bool {
+	if proxy.InCluster(ep.Locality.ClusterID) {
+		return true
+	}
+	if !supportsAddressFamily(proxy, ep.Address) {
+		return false
+	}
+	for _, f := range p.filters {
+		if !f.accept(ep, proxy) {
+			return false
+		}
+	}
+	return true
+}
+
+// supportsAddressFamily returns true if the families of the proxy's IP addresses intersect with the family of the
+// given endpoint address. A dual-stack proxy therefore supports single-stack endpoints of either family. Proxies
+// whose IP families are unknown, as well as endpoints whose address isn't an IP, are assumed to be supported.
+func supportsAddressFamily(proxy *model.Proxy, address string) bool {
+	if proxy == nil || (!proxy.SupportsIPv4() && !proxy.SupportsIPv6()) {
+		return true
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return true
+	}
+	if ip.To4() != nil {
+		return proxy.SupportsIPv4()
+	}
+	return proxy.SupportsIPv6()
+}
+
+func (p *filteredDiscoverabilityPolicy) String() string {
+	names := make([]string, 0, len(p.filters))
+	for _, f := range p.filters {
+		names = append(names, f.name)
+	}
+	return "FilteredDiscoverable[" + strings.Join(names, ",") + "]"
+}
+
+type disabledServiceExportCache struct{}
+
+var _ serviceExportCache = disabledServiceExportCache{}
+
+func (c disabledServiceExportCache) EndpointDiscoverabilityPolicy(*model.Service) model.EndpointDiscoverabilityPolicy {
+	return model.AlwaysDiscoverable
+}
+
+func (c disabledServiceExportCache) LoadBalancerPolicy(types.NamespacedName) *networking.LoadBalancerSettings_SimpleLB {
+	return nil
+}
+
+func (c disabledServiceExportCache) ExportGeneration(*model.Service) int64 {
+	return 0
+}
+
+func (c disabledServiceExportCache) PodExportable(*v1.Pod) bool {
+	return true
+}
+
+func (c disabledServiceExportCache) ExportsTLSMode(*model.Service) bool {
+	return false
+}
+
+func (c disabledServiceExportCache) EndpointALPNHints(*model.Service) map[string][]string {
+	return nil
+}
+
+func (c disabledServiceExportCache) EndpointLbWeight(*model.Service, *v1.Pod) uint32 {
+	return 0
+}
+
+func (c disabledServiceExportCache) EndpointsUpdated(types.NamespacedName, int) {}
+
+func (c disabledServiceExportCache) ClusterSetHostname(name types.NamespacedName) host.Name {
+	return serviceClusterSetLocalHostname(name)
+}
+
+func (c disabledServiceExportCache) ClusterSetNamespace(name types.NamespacedName) string {
+	return canonicalNamespace(name.Namespace)
+}
+
+func (c disabledServiceExportCache) PodInstancesByPort(*model.Service, int, labels.Collection) ([]*model.ServiceInstance, bool) {
+	return nil, false
+}
+
+func (c disabledServiceExportCache) runResync(<-chan struct{}) {}
+
+func (c disabledServiceExportCache) runStatusWriter(<-chan struct{}) {}
+
 func (c disabledServiceExportCache) HasSynced() bool {
 	return true
 }
@@ -18,18 +18,29 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"go.opencensus.io/stats/view"
+	coreV1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
 	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 
 	"istio.io/api/label"
+	meshconfig "istio.io/api/mesh/v1alpha1"
 	"istio.io/istio/pilot/pkg/features"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/serviceregistry/kube"
+	"istio.io/istio/pkg/cluster"
 	"istio.io/istio/pkg/config/host"
+	"istio.io/istio/pkg/config/labels"
+	"istio.io/istio/pkg/config/mesh"
 	"istio.io/istio/pkg/test/util/retry"
 )
 
@@ -116,6 +127,1725 @@ func TestServiceUnexported(t *testing.T) {
 	}
 }
 
+func TestServiceExportedWithWorkloadEntry(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Back the service with a VM as well, selected through a WorkloadEntry.
+			workloadEntryIP := "2.2.2.2"
+			ec.WorkloadInstanceHandler(&model.WorkloadInstance{
+				Name:      "vm",
+				Namespace: serviceExportNamespace,
+				Endpoint: &model.IstioEndpoint{
+					Labels:         labels.Instance{"app": "prod-app"},
+					ServiceAccount: "account",
+					Address:        workloadEntryIP,
+					EndpointPort:   8080,
+					Locality:       model.Locality{ClusterID: ec.Cluster()},
+				},
+			}, model.EventAdd)
+
+			// Like the pods, the VM is kept local to the cluster until the service is exported.
+			retry.UntilSuccessOrFail(t, func() error {
+				ep := ec.endpointsByAddress()[workloadEntryIP]
+				if ep == nil {
+					return fmt.Errorf("failed to find endpoint %s", workloadEntryIP)
+				}
+				if err := ec.checkDiscoverableFromSameCluster(ep); err != nil {
+					return err
+				}
+				return ec.checkNotDiscoverableFromDifferentCluster(ep)
+			}, serviceExportTimeout)
+
+			ec.export(t)
+			retry.UntilSuccessOrFail(t, func() error {
+				eps := ec.endpointsByAddress()
+				if len(eps) != 2 {
+					return fmt.Errorf("expected 2 endpoints, found %d", len(eps))
+				}
+				for _, ep := range eps {
+					if err := ec.checkDiscoverableFromDifferentCluster(ep); err != nil {
+						return fmt.Errorf("endpoint %s: %v", ep.Address, err)
+					}
+				}
+				return nil
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportedWithEndpointSelector(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Back the service with one labeled and one unlabeled pod.
+			labeledIP, unlabeledIP := "128.0.0.3", "128.0.0.4"
+			ec.addPods(t,
+				generatePod(labeledIP, "labeled", serviceExportNamespace, "account", "node1",
+					map[string]string{"app": "prod-app", "traffic": "external"}, nil),
+				generatePod(unlabeledIP, "unlabeled", serviceExportNamespace, "account", "node1",
+					map[string]string{"app": "prod-app"}, nil))
+			ec.setEndpoints(t, labeledIP, unlabeledIP)
+
+			// Export only the labeled endpoints.
+			ec.exportWithAnnotations(t, map[string]string{exportEndpointSelectorAnnotation: "traffic=external"})
+
+			retry.UntilSuccessOrFail(t, func() error {
+				eps := ec.endpointsByAddress()
+				if len(eps) != 2 {
+					return fmt.Errorf("expected 2 endpoints, found %d", len(eps))
+				}
+				if err := ec.checkDiscoverableFromSameCluster(eps[unlabeledIP]); err != nil {
+					return err
+				}
+				if err := ec.checkDiscoverableFromDifferentCluster(eps[labeledIP]); err != nil {
+					return err
+				}
+				return ec.checkNotDiscoverableFromDifferentCluster(eps[unlabeledIP])
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportedWithCanaryEndpoint(t *testing.T) {
+	prevMCSCanaryLabel := features.MCSCanaryLabel
+	features.MCSCanaryLabel = "canary"
+	defer func() { features.MCSCanaryLabel = prevMCSCanaryLabel }()
+
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Back the service with a canary and a stable pod.
+			canaryIP, stableIP := "128.0.0.3", "128.0.0.4"
+			ec.addPods(t,
+				generatePod(canaryIP, "canary", serviceExportNamespace, "account", "node1",
+					map[string]string{"app": "prod-app", "canary": "true"}, nil),
+				generatePod(stableIP, "stable", serviceExportNamespace, "account", "node1",
+					map[string]string{"app": "prod-app"}, nil))
+			ec.setEndpoints(t, canaryIP, stableIP)
+
+			// Export the service.
+			ec.exportWithAnnotations(t, nil)
+
+			retry.UntilSuccessOrFail(t, func() error {
+				eps := ec.endpointsByAddress()
+				if len(eps) != 2 {
+					return fmt.Errorf("expected 2 endpoints, found %d", len(eps))
+				}
+				if err := ec.checkDiscoverableFromSameCluster(eps[canaryIP]); err != nil {
+					return err
+				}
+				if err := ec.checkDiscoverableFromDifferentCluster(eps[stableIP]); err != nil {
+					return err
+				}
+				return ec.checkNotDiscoverableFromDifferentCluster(eps[canaryIP])
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportedWithZones(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Back the service with pods in two zones.
+			eastIP, westIP := "128.0.0.3", "128.0.0.4"
+			ec.addPods(t,
+				generatePod(eastIP, "east", serviceExportNamespace, "account", "node1",
+					map[string]string{"app": "prod-app", model.LocalityLabel: "us-east.us-east-1a"}, nil),
+				generatePod(westIP, "west", serviceExportNamespace, "account", "node1",
+					map[string]string{"app": "prod-app", model.LocalityLabel: "us-west.us-west-1a"}, nil))
+			ec.setEndpoints(t, eastIP, westIP)
+
+			// Export only the endpoints in us-east-1a.
+			ec.exportWithAnnotations(t, map[string]string{exportZonesAnnotation: "us-east-1a"})
+
+			retry.UntilSuccessOrFail(t, func() error {
+				eps := ec.endpointsByAddress()
+				if len(eps) != 2 {
+					return fmt.Errorf("expected 2 endpoints, found %d", len(eps))
+				}
+				if err := ec.checkDiscoverableFromSameCluster(eps[westIP]); err != nil {
+					return err
+				}
+				if err := ec.checkDiscoverableFromDifferentCluster(eps[eastIP]); err != nil {
+					return err
+				}
+				return ec.checkNotDiscoverableFromDifferentCluster(eps[westIP])
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportedWithPodPhases(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Back the service with pods in different phases, all listed in the endpoints.
+			phases := map[string]coreV1.PodPhase{
+				"128.0.0.3": coreV1.PodRunning,
+				"128.0.0.4": coreV1.PodPending,
+				"128.0.0.5": coreV1.PodFailed,
+			}
+			var ips []string
+			for ip, phase := range phases {
+				ec.addPods(t, generatePod(ip, "pod-"+strings.ToLower(string(phase)), serviceExportNamespace, "account", "node1",
+					map[string]string{"app": "prod-app"}, nil))
+				ips = append(ips, ip)
+			}
+			for ip, phase := range phases {
+				pod := ec.pods.getPodByIP(ip)
+				if pod == nil {
+					t.Fatalf("pod %s not found", ip)
+				}
+				pod = pod.DeepCopy()
+				pod.Status.Phase = phase
+				if _, err := ec.client.CoreV1().Pods(serviceExportNamespace).UpdateStatus(context.TODO(), pod, v12.UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+				retry.UntilSuccessOrFail(t, func() error {
+					if got := ec.pods.getPodByIP(ip); got == nil || got.Status.Phase != phase {
+						return fmt.Errorf("pod %s not updated to phase %s", ip, phase)
+					}
+					return nil
+				}, serviceExportTimeout)
+			}
+			ec.setEndpoints(t, ips...)
+
+			ec.export(t)
+
+			// Only the endpoint of the running pod is discoverable from other clusters.
+			retry.UntilSuccessOrFail(t, func() error {
+				eps := ec.endpointsByAddress()
+				for ip, phase := range phases {
+					ep := eps[ip]
+					if ep == nil {
+						return fmt.Errorf("failed to find endpoint %s", ip)
+					}
+					if err := ec.checkDiscoverableFromSameCluster(ep); err != nil {
+						return err
+					}
+					if phase == coreV1.PodRunning {
+						if err := ec.checkDiscoverableFromDifferentCluster(ep); err != nil {
+							return err
+						}
+					} else if err := ec.checkNotDiscoverableFromDifferentCluster(ep); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportedWithTLSMode(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Back the service with a pod with a sidecar and a pod without.
+			tlsModes := map[string]string{
+				"128.0.0.3": model.IstioMutualTLSModeLabel,
+				"128.0.0.4": model.DisabledTLSModeLabel,
+			}
+			ec.addPods(t,
+				generatePod("128.0.0.3", "pod-with-sidecar", serviceExportNamespace, "account", "node1",
+					map[string]string{"app": "prod-app", label.SecurityTlsMode.Name: model.IstioMutualTLSModeLabel}, nil),
+				generatePod("128.0.0.4", "pod-without-sidecar", serviceExportNamespace, "account", "node1",
+					map[string]string{"app": "prod-app"}, nil))
+			ec.setEndpoints(t, "128.0.0.3", "128.0.0.4")
+
+			// Export the service with the TLS mode, only for the endpoints that negotiate TLS.
+			ec.exportWithAnnotations(t, map[string]string{
+				exportTLSModeAnnotation:          "true",
+				exportEndpointSelectorAnnotation: label.SecurityTlsMode.Name + "!=" + model.DisabledTLSModeLabel,
+			})
+
+			retry.UntilSuccessOrFail(t, func() error {
+				eps := ec.endpointsByAddress()
+				for ip, tlsMode := range tlsModes {
+					ep := eps[ip]
+					if ep == nil {
+						return fmt.Errorf("failed to find endpoint %s", ip)
+					}
+					if ep.TLSMode != tlsMode {
+						return fmt.Errorf("endpoint %s has TLS mode %q, expected %q", ip, ep.TLSMode, tlsMode)
+					}
+					if got := ep.Labels[label.SecurityTlsMode.Name]; got != tlsMode {
+						return fmt.Errorf("endpoint %s has TLS mode label %q, expected %q", ip, got, tlsMode)
+					}
+					if err := ec.checkDiscoverableFromSameCluster(ep); err != nil {
+						return err
+					}
+					if tlsMode == model.IstioMutualTLSModeLabel {
+						if err := ec.checkDiscoverableFromDifferentCluster(ep); err != nil {
+							return err
+						}
+					} else if err := ec.checkNotDiscoverableFromDifferentCluster(ep); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportTLSModeToggled(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+	fx := ec.opts.XDSUpdater.(*FakeXdsUpdater)
+
+	// Back the service with a pod without a sidecar, whose endpoint is only labeled with its TLS mode if exported.
+	ec.addPods(t, generatePod("128.0.0.3", "pod-without-sidecar", serviceExportNamespace, "account", "node1",
+		map[string]string{"app": "prod-app"}, nil))
+	ec.setEndpoints(t, "128.0.0.3")
+	ec.export(t)
+
+	waitForTLSModeLabel := func(want string) {
+		t.Helper()
+		retry.UntilSuccessOrFail(t, func() error {
+			event := fx.Wait("eds")
+			if event == nil {
+				return errors.New("failed waiting for XDS event")
+			}
+			if len(event.Endpoints) != 1 {
+				return fmt.Errorf("expected 1 endpoint, found %d", len(event.Endpoints))
+			}
+			if got := event.Endpoints[0].Labels[label.SecurityTlsMode.Name]; got != want {
+				return fmt.Errorf("pushed endpoint has TLS mode label %q, expected %q", got, want)
+			}
+			return nil
+		}, serviceExportTimeout)
+	}
+
+	// Toggling the annotation on the existing export pushes the endpoints, with or without the TLS mode label.
+	ec.updateServiceExport(t, serviceExportName, func(se *v1alpha1.ServiceExport) {
+		se.Annotations = map[string]string{exportTLSModeAnnotation: "true"}
+	})
+	waitForTLSModeLabel(model.DisabledTLSModeLabel)
+
+	ec.updateServiceExport(t, serviceExportName, func(se *v1alpha1.ServiceExport) {
+		se.Annotations = nil
+	})
+	waitForTLSModeLabel("")
+}
+
+func TestServiceExportedWithHealthWeights(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Back the service with pods passing all, half and none of their health checks, and one without a
+			// reported pass rate.
+			healthyIP, degradedIP, failingIP, unknownIP := "128.0.0.3", "128.0.0.4", "128.0.0.5", "128.0.0.6"
+			passRates := map[string]string{healthyIP: "1", degradedIP: "0.5", failingIP: "0"}
+			for name, ip := range map[string]string{
+				"healthy": healthyIP, "degraded": degradedIP, "failing": failingIP, "unknown": unknownIP,
+			} {
+				var annotations map[string]string
+				if rate, ok := passRates[ip]; ok {
+					annotations = map[string]string{healthCheckPassRateAnnotation: rate}
+				}
+				ec.addPods(t, generatePod(ip, name, serviceExportNamespace, "account", "node1",
+					map[string]string{"app": "prod-app"}, annotations))
+			}
+			ec.setEndpoints(t, healthyIP, degradedIP, failingIP, unknownIP)
+
+			// The weights only apply once the service is exported.
+			eps := ec.endpointsByAddress()
+			for ip, ep := range eps {
+				if ep.LbWeight != 0 {
+					t.Fatalf("expected the default weight for endpoint %s of the unexported service, found %d", ip, ep.LbWeight)
+				}
+			}
+
+			ec.export(t)
+			expected := map[string]uint32{
+				healthyIP:  healthWeightScale,
+				degradedIP: healthWeightScale / 2,
+				failingIP:  1,
+				unknownIP:  0,
+			}
+			retry.UntilSuccessOrFail(t, func() error {
+				eps := ec.endpointsByAddress()
+				if len(eps) != len(expected) {
+					return fmt.Errorf("expected %d endpoints, found %d", len(expected), len(eps))
+				}
+				for ip, weight := range expected {
+					if got := eps[ip].LbWeight; got != weight {
+						return fmt.Errorf("expected weight %d for endpoint %s, found %d", weight, ip, got)
+					}
+				}
+				return nil
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportedWithALPNHints(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Expose the service on an HTTP/2 port alongside its TCP port.
+			svc, err := ec.client.CoreV1().Services(serviceExportNamespace).Get(context.TODO(), serviceExportName, v12.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			svc.Spec.Ports = []coreV1.ServicePort{
+				{Name: "http2-port", Port: 8080, Protocol: coreV1.ProtocolTCP},
+				{Name: "tcp-port", Port: 9090, Protocol: coreV1.ProtocolTCP},
+			}
+			if _, err := ec.client.CoreV1().Services(serviceExportNamespace).Update(context.TODO(), svc, v12.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			createEndpoints(t, &FakeController{ec.Controller}, serviceExportName, serviceExportNamespace,
+				[]string{"http2-port", "tcp-port"}, []string{serviceExportPodIP}, nil, nil)
+
+			endpointsByPortName := func() map[string]*model.IstioEndpoint {
+				out := make(map[string]*model.IstioEndpoint)
+				if svc := ec.GetService(ec.serviceHostname()); svc != nil {
+					for _, ep := range ec.buildEndpointsForService(svc, true) {
+						out[ep.ServicePortName] = ep
+					}
+				}
+				return out
+			}
+
+			// The hints only apply once the service is exported.
+			retry.UntilSuccessOrFail(t, func() error {
+				eps := endpointsByPortName()
+				if len(eps) != 2 {
+					return fmt.Errorf("expected endpoints for 2 ports, found %d", len(eps))
+				}
+				for name, ep := range eps {
+					if len(ep.ALPNHints) != 0 {
+						return fmt.Errorf("expected no ALPN hints for port %s of the unexported service, found %v", name, ep.ALPNHints)
+					}
+				}
+				return nil
+			}, serviceExportTimeout)
+
+			ec.export(t)
+			retry.UntilSuccessOrFail(t, func() error {
+				eps := endpointsByPortName()
+				if len(eps) != 2 {
+					return fmt.Errorf("expected endpoints for 2 ports, found %d", len(eps))
+				}
+				if got := eps["http2-port"].ALPNHints; len(got) != 1 || got[0] != "h2" {
+					return fmt.Errorf("expected ALPN hints [h2] for the HTTP/2 port, found %v", got)
+				}
+				if got := eps["tcp-port"].ALPNHints; len(got) != 0 {
+					return fmt.Errorf("expected no ALPN hints for the TCP port, found %v", got)
+				}
+				return nil
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportedWithMinEndpoints(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Export the service, which only has one endpoint, requiring two.
+			ec.exportWithAnnotations(t, map[string]string{exportMinEndpointsAnnotation: "2"})
+			ec.waitForXDS(t, false)
+			retry.UntilSuccessOrFail(t, func() error {
+				ep := ec.endpointsByAddress()[serviceExportPodIP]
+				if ep == nil {
+					return fmt.Errorf("failed to find endpoint %s", serviceExportPodIP)
+				}
+				return ec.checkNotDiscoverableFromDifferentCluster(ep)
+			}, serviceExportTimeout)
+
+			// Add the second endpoint. Both endpoints are pushed as mesh-wide.
+			ec.setEndpoints(t, serviceExportPodIP, "128.0.0.3")
+			retry.UntilSuccessOrFail(t, func() error {
+				event := ec.opts.XDSUpdater.(*FakeXdsUpdater).Wait("eds")
+				if event == nil {
+					return errors.New("failed waiting for XDS event")
+				}
+				if len(event.Endpoints) != 2 {
+					return fmt.Errorf("expected 2 endpoints, found %d", len(event.Endpoints))
+				}
+				for _, ep := range event.Endpoints {
+					if err := ec.checkDiscoverableFromDifferentCluster(ep); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportedWithMinEndpointsMultiPort(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Expose the service on three ports, so that its single pod has an endpoint per port.
+			svc, err := ec.client.CoreV1().Services(serviceExportNamespace).Get(context.TODO(), serviceExportName, v12.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			portNames := []string{"http-port", "grpc-port", "tcp-port"}
+			svc.Spec.Ports = []coreV1.ServicePort{
+				{Name: portNames[0], Port: 8080, Protocol: coreV1.ProtocolTCP},
+				{Name: portNames[1], Port: 8081, Protocol: coreV1.ProtocolTCP},
+				{Name: portNames[2], Port: 9090, Protocol: coreV1.ProtocolTCP},
+			}
+			if _, err := ec.client.CoreV1().Services(serviceExportNamespace).Update(context.TODO(), svc, v12.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			createEndpoints(t, &FakeController{ec.Controller}, serviceExportName, serviceExportNamespace,
+				portNames, []string{serviceExportPodIP}, nil, nil)
+
+			endpointsForAddress := func(ip string) []*model.IstioEndpoint {
+				var out []*model.IstioEndpoint
+				if svc := ec.GetService(ec.serviceHostname()); svc != nil {
+					for _, ep := range ec.buildEndpointsForService(svc, true) {
+						if ep.Address == ip {
+							out = append(out, ep)
+						}
+					}
+				}
+				return out
+			}
+
+			// Export the service requiring three endpoints. The three endpoints of the single pod are not enough.
+			ec.exportWithAnnotations(t, map[string]string{exportMinEndpointsAnnotation: "3"})
+			retry.UntilSuccessOrFail(t, func() error {
+				eps := endpointsForAddress(serviceExportPodIP)
+				if len(eps) != len(portNames) {
+					return fmt.Errorf("expected %d endpoints for %s, found %d", len(portNames), serviceExportPodIP, len(eps))
+				}
+				for _, ep := range eps {
+					if err := ec.checkNotDiscoverableFromDifferentCluster(ep); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, serviceExportTimeout)
+
+			// Scale the service to three pods, which makes their endpoints discoverable.
+			ips := []string{serviceExportPodIP, "128.0.0.3", "128.0.0.4"}
+			createEndpoints(t, &FakeController{ec.Controller}, serviceExportName, serviceExportNamespace,
+				portNames, ips, nil, nil)
+			retry.UntilSuccessOrFail(t, func() error {
+				for _, ip := range ips {
+					eps := endpointsForAddress(ip)
+					if len(eps) != len(portNames) {
+						return fmt.Errorf("expected %d endpoints for %s, found %d", len(portNames), ip, len(eps))
+					}
+					for _, ep := range eps {
+						if err := ec.checkDiscoverableFromDifferentCluster(ep); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportGracePeriods(t *testing.T) {
+	const unexportGrace, endpointRemovalGrace = 800 * time.Millisecond, 200 * time.Millisecond
+	prevUnexportGrace, prevEndpointRemovalGrace := features.MCSUnexportGracePeriod, features.MCSEndpointRemovalGracePeriod
+	features.MCSUnexportGracePeriod, features.MCSEndpointRemovalGracePeriod = unexportGrace, endpointRemovalGrace
+	defer func() {
+		features.MCSUnexportGracePeriod, features.MCSEndpointRemovalGracePeriod = prevUnexportGrace, prevEndpointRemovalGrace
+	}()
+
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+
+	discoverable := func() (bool, error) {
+		ep := ec.endpointsByAddress()[serviceExportPodIP]
+		if ep == nil {
+			return false, fmt.Errorf("failed to find endpoint %s", serviceExportPodIP)
+		}
+		return ec.isDiscoverableFromDifferentCluster(ep), nil
+	}
+	waitForDiscoverable := func(want bool) {
+		t.Helper()
+		retry.UntilSuccessOrFail(t, func() error {
+			got, err := discoverable()
+			if err != nil {
+				return err
+			}
+			if got != want {
+				return fmt.Errorf("expected the endpoint to be discoverable from a different cluster: %v", want)
+			}
+			return nil
+		}, serviceExportTimeout, retry.Delay(10*time.Millisecond))
+	}
+
+	// Export the service, requiring the two endpoints it has.
+	ec.setEndpoints(t, serviceExportPodIP, "128.0.0.3")
+	ec.exportWithAnnotations(t, map[string]string{exportMinEndpointsAnnotation: "2"})
+	waitForDiscoverable(true)
+
+	// Removing an endpoint holds the service cluster-local once the endpoint removal grace period elapsed.
+	removed := time.Now()
+	ec.setEndpoints(t, serviceExportPodIP)
+	waitForDiscoverable(false)
+	if elapsed := time.Since(removed); elapsed < endpointRemovalGrace || elapsed >= unexportGrace {
+		t.Fatalf("expected the endpoint removal to apply after %v, applied after %v", endpointRemovalGrace, elapsed)
+	}
+
+	// Unexporting the service keeps the endpoints discoverable until the unexport grace period elapsed.
+	ec.setEndpoints(t, serviceExportPodIP, "128.0.0.3")
+	waitForDiscoverable(true)
+	unexported := time.Now()
+	if err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Delete(
+		context.TODO(), serviceExportName, v12.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	retry.UntilOrFail(t, func() bool {
+		return !ec.isExported(serviceExportNamespacedName)
+	}, serviceExportTimeout)
+	if got, err := discoverable(); err != nil || (!got && time.Since(unexported) < unexportGrace) {
+		t.Fatalf("expected the endpoint to remain discoverable while draining, got %v (%v)", got, err)
+	}
+	waitForDiscoverable(false)
+	if elapsed := time.Since(unexported); elapsed < unexportGrace {
+		t.Fatalf("expected the unexport to apply after %v, applied after %v", unexportGrace, elapsed)
+	}
+}
+
+func TestServiceExportGracePeriodsStopped(t *testing.T) {
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+
+	// A grace period pending when the controller stops is cancelled.
+	var ran int32
+	ec.afterGrace(100*time.Millisecond, func() error {
+		atomic.StoreInt32(&ran, 1)
+		return nil
+	})
+	cleanup()
+	retry.UntilOrFail(t, func() bool {
+		ec.mutex.Lock()
+		defer ec.mutex.Unlock()
+		return ec.timers == nil
+	}, serviceExportTimeout)
+
+	// No grace period starts once the controller stopped.
+	ec.afterGrace(0, func() error {
+		atomic.StoreInt32(&ran, 1)
+		return nil
+	})
+	time.Sleep(200 * time.Millisecond)
+	if atomic.LoadInt32(&ran) != 0 {
+		t.Fatal("expected the grace periods to be cancelled once the controller stopped")
+	}
+}
+
+func TestServiceExportHeldClusterLocalCondition(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+
+	heldCondition := func() (*v1alpha1.ServiceExportCondition, error) {
+		se, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Get(
+			context.TODO(), serviceExportName, v12.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range se.Status.Conditions {
+			if c.Type == serviceExportHeldClusterLocal {
+				c := c
+				return &c, nil
+			}
+		}
+		return nil, nil
+	}
+
+	// Export the service, which only has one endpoint, requiring two.
+	ec.exportWithAnnotations(t, map[string]string{exportMinEndpointsAnnotation: "2"})
+	retry.UntilSuccessOrFail(t, func() error {
+		c, err := heldCondition()
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			return errors.New("HeldClusterLocal condition not found")
+		}
+		if c.Status != coreV1.ConditionTrue || c.Reason == nil || *c.Reason != serviceExportReasonInsufficientEndpoints {
+			return fmt.Errorf("unexpected HeldClusterLocal condition: %+v", c)
+		}
+		return nil
+	}, serviceExportTimeout)
+
+	// Add the second endpoint. The condition is cleared.
+	ec.setEndpoints(t, serviceExportPodIP, "128.0.0.3")
+	retry.UntilSuccessOrFail(t, func() error {
+		c, err := heldCondition()
+		if err != nil {
+			return err
+		}
+		if c != nil {
+			return fmt.Errorf("unexpected HeldClusterLocal condition: %+v", c)
+		}
+		return nil
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportedToClusterGroup(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+	ec.opts.ClusterGroups = map[string][]cluster.ID{
+		"prod-east": {"east-1", "east-2"},
+		"prod-west": {"west-1"},
+	}
+
+	// Export the service to the prod-east group only.
+	ec.exportWithAnnotations(t, map[string]string{exportClusterGroupsAnnotation: "prod-east"})
+
+	retry.UntilSuccessOrFail(t, func() error {
+		ep := ec.endpointsByAddress()[serviceExportPodIP]
+		if ep == nil {
+			return fmt.Errorf("failed to find endpoint %s", serviceExportPodIP)
+		}
+		if err := ec.checkDiscoverableFromSameCluster(ep); err != nil {
+			return err
+		}
+		for clusterID, want := range map[cluster.ID]bool{"east-1": true, "east-2": true, "west-1": false, "other": false} {
+			proxy := &model.Proxy{Metadata: &model.NodeMetadata{ClusterID: clusterID}}
+			if got := ep.IsDiscoverableFromProxy(proxy); got != want {
+				return fmt.Errorf("discoverable from cluster %s: got %v, want %v", clusterID, got, want)
+			}
+		}
+		return nil
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportDiscoverabilityCondition(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+	ec.opts.ClusterGroups = map[string][]cluster.ID{
+		"prod-east": {"east-1", "east-2"},
+	}
+
+	checkCondition := func(reason, message string) {
+		t.Helper()
+		retry.UntilSuccessOrFail(t, func() error {
+			se, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Get(
+				context.TODO(), serviceExportName, v12.GetOptions{})
+			if err != nil {
+				return err
+			}
+			for _, c := range se.Status.Conditions {
+				if c.Type != serviceExportDiscoverability {
+					continue
+				}
+				if c.Status != coreV1.ConditionTrue || c.Reason == nil || *c.Reason != reason || c.Message == nil || *c.Message != message {
+					return fmt.Errorf("unexpected Discoverability condition: %+v", c)
+				}
+				return nil
+			}
+			return errors.New("Discoverability condition not found")
+		}, serviceExportTimeout)
+	}
+
+	// Export the service to an allowlist of clusters.
+	ec.exportWithAnnotations(t, map[string]string{exportClusterGroupsAnnotation: "prod-east"})
+	checkCondition(serviceExportReasonFiltered, "FilteredDiscoverable[ClusterGroups(prod-east)]")
+
+	// Keep the export local.
+	ec.updateServiceExport(t, serviceExportName, func(se *v1alpha1.ServiceExport) {
+		se.Annotations = map[string]string{exportDiscoverabilityAnnotation: exportDiscoverabilityLocal}
+	})
+	checkCondition(serviceExportReasonClusterLocal, model.DiscoverableFromSameCluster.String())
+
+	// Export the service mesh-wide.
+	ec.updateServiceExport(t, serviceExportName, func(se *v1alpha1.ServiceExport) {
+		se.Annotations = nil
+	})
+	checkCondition(serviceExportReasonMeshWide, model.AlwaysDiscoverable.String())
+}
+
+func TestServiceExportStatusWrittenByStatusWriter(t *testing.T) {
+	// Create and run the controller, which doesn't write the status until it is elected to.
+	ec, cleanup := newTestServiceExportCacheWithOptions(t, meshWide, FakeControllerOptions{Mode: EndpointSliceOnly})
+	defer cleanup()
+
+	discoverabilityCondition := func() (*v1alpha1.ServiceExportCondition, error) {
+		se, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Get(
+			context.TODO(), serviceExportName, v12.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range se.Status.Conditions {
+			if c.Type == serviceExportDiscoverability {
+				c := c
+				return &c, nil
+			}
+		}
+		return nil, nil
+	}
+
+	// The export applies, but its status isn't written.
+	ec.export(t)
+	if c, err := discoverabilityCondition(); err != nil || c != nil {
+		t.Fatalf("expected no Discoverability condition, found %+v (err: %v)", c, err)
+	}
+
+	// Once elected, the status of the existing exports is written.
+	leaderStop := make(chan struct{})
+	go ec.runStatusWriter(leaderStop)
+	retry.UntilSuccessOrFail(t, func() error {
+		c, err := discoverabilityCondition()
+		if err != nil {
+			return err
+		}
+		if c == nil || c.Reason == nil || *c.Reason != serviceExportReasonMeshWide {
+			return fmt.Errorf("unexpected Discoverability condition: %+v", c)
+		}
+		return nil
+	}, serviceExportTimeout)
+
+	// Once another istiod is elected, the status is left to it.
+	close(leaderStop)
+	retry.UntilOrFail(t, func() bool {
+		return !ec.statusWriter.Load()
+	}, serviceExportTimeout)
+	ec.updateServiceExport(t, serviceExportName, func(se *v1alpha1.ServiceExport) {
+		se.Annotations = map[string]string{exportDiscoverabilityAnnotation: exportDiscoverabilityLocal}
+	})
+	ec.waitForXDS(t, false)
+	if c, err := discoverabilityCondition(); err != nil || c == nil || c.Reason == nil || *c.Reason != serviceExportReasonMeshWide {
+		t.Fatalf("expected the Discoverability condition to be left unchanged, found %+v (err: %v)", c, err)
+	}
+}
+
+func TestServiceExportDiscoverabilityOfMultipleServices(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+
+	// Create additional services next to the test service.
+	const localName, unexportedName = "local-svc", "unexported-svc"
+	for _, name := range []string{localName, unexportedName} {
+		createService(&FakeController{ec.Controller}, name, serviceExportNamespace, map[string]string{},
+			[]int32{8080}, map[string]string{"app": name}, t)
+	}
+
+	// Export the test service mesh-wide and another service with a hint to keep it local.
+	ec.export(t)
+	se := newServiceExport()
+	se.Name = localName
+	se.Annotations = map[string]string{exportDiscoverabilityAnnotation: exportDiscoverabilityLocal}
+	if _, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+		context.TODO(), se, v12.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	hostname := func(name string) host.Name {
+		return kube.ServiceHostname(name, serviceExportNamespace, ec.opts.DomainSuffix)
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		return ec.AssertDiscoverability(map[host.Name]model.EndpointDiscoverabilityPolicy{
+			ec.serviceHostname():     model.AlwaysDiscoverable,
+			hostname(localName):      model.DiscoverableFromSameCluster,
+			hostname(unexportedName): model.DiscoverableFromSameCluster,
+		})
+	}, serviceExportTimeout)
+
+	// A wrong expectation is reported.
+	err := ec.AssertDiscoverability(map[host.Name]model.EndpointDiscoverabilityPolicy{
+		hostname(localName):                 model.AlwaysDiscoverable,
+		"missing.test-ns.svc.cluster.local": model.AlwaysDiscoverable,
+	})
+	if err == nil {
+		t.Fatal("expected the mismatches to be reported")
+	}
+	for _, want := range []string{string(hostname(localName)), "missing.test-ns.svc.cluster.local: expected"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected the report to mention %q, found:\n%v", want, err)
+		}
+	}
+
+	// A filtered policy differs from the unfiltered one, even though it accepts an endpoint of this cluster.
+	ec.updateServiceExport(t, serviceExportName, func(se *v1alpha1.ServiceExport) {
+		se.Annotations = map[string]string{exportPortsAnnotation: "tcp-port"}
+	})
+	retry.UntilSuccessOrFail(t, func() error {
+		err := ec.AssertDiscoverability(map[host.Name]model.EndpointDiscoverabilityPolicy{
+			ec.serviceHostname(): model.AlwaysDiscoverable,
+		})
+		if err == nil || !strings.Contains(err.Error(), "Ports(tcp-port)") {
+			return fmt.Errorf("expected the filtered policy to be reported, found: %v", err)
+		}
+		return nil
+	}, serviceExportTimeout)
+}
+
+func TestHeadlessServiceExportedWithPodHostnames(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+
+	// Create a headless service backed by two named pods.
+	const headlessName = "mysql"
+	podIPs := map[string]string{"mysql-0": "128.0.1.1", "mysql-1": "128.0.1.2"}
+	createServiceWithoutClusterIP(&FakeController{ec.Controller}, headlessName, serviceExportNamespace, nil,
+		[]int32{3306}, map[string]string{"app": headlessName}, t)
+	for _, name := range []string{"mysql-0", "mysql-1"} {
+		pod := generatePod(podIPs[name], name, serviceExportNamespace, "account", "node1",
+			map[string]string{"app": headlessName}, nil)
+		pod.Spec.Hostname = name
+		pod.Spec.Subdomain = headlessName
+		ec.addPods(t, pod)
+	}
+	createEndpoints(t, &FakeController{ec.Controller}, headlessName, serviceExportNamespace, []string{"tcp-port"},
+		[]string{podIPs["mysql-0"], podIPs["mysql-1"]}, nil, nil)
+
+	// Export the headless service.
+	se := newServiceExport()
+	se.Name = headlessName
+	if _, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+		context.TODO(), se, v12.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each pod is reachable from other clusters at its own clusterset.local hostname.
+	clusterSetHostname := serviceClusterSetLocalHostname(types.NamespacedName{Namespace: serviceExportNamespace, Name: headlessName})
+	retry.UntilSuccessOrFail(t, func() error {
+		for name, ip := range podIPs {
+			hostname := podClusterSetHostname(name, ec.Cluster(), clusterSetHostname)
+			svc := ec.GetService(hostname)
+			if svc == nil {
+				return fmt.Errorf("service %s not found", hostname)
+			}
+			instances := ec.InstancesByPort(svc, 3306, nil)
+			if len(instances) != 1 || instances[0].Endpoint.Address != ip {
+				return fmt.Errorf("expected the single instance %s for %s, found %d instances", ip, hostname, len(instances))
+			}
+			if err := ec.checkDiscoverableFromDifferentCluster(instances[0].Endpoint); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportDryRun(t *testing.T) {
+	prevMCSDryRun := features.MCSDryRun
+	features.MCSDryRun = true
+	defer func() { features.MCSDryRun = prevMCSDryRun }()
+
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+	fx := ec.opts.XDSUpdater.(*FakeXdsUpdater)
+	fx.Clear()
+
+	// Export the service.
+	ec.export(t)
+
+	// The would-be discoverability is reported, but not applied.
+	retry.UntilSuccessOrFail(t, func() error {
+		se, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Get(
+			context.TODO(), serviceExportName, v12.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, c := range se.Status.Conditions {
+			if c.Type != serviceExportDiscoverability {
+				continue
+			}
+			if c.Status != coreV1.ConditionFalse || c.Reason == nil || *c.Reason != serviceExportReasonMeshWide {
+				return fmt.Errorf("unexpected Discoverability condition: %+v", c)
+			}
+			return nil
+		}
+		return errors.New("Discoverability condition not found")
+	}, serviceExportTimeout)
+	ec.checkNoPush(t)
+	ec.checkServiceInstancesOrFail(t, false)
+}
+
+func TestServiceExportedWithLocalDiscoverabilityHint(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Export the service, but hint that it should remain local.
+			ec.exportWithAnnotations(t, map[string]string{exportDiscoverabilityAnnotation: exportDiscoverabilityLocal})
+
+			// Check that the endpoint is cluster-local
+			retry.UntilSuccessOrFail(t, func() error {
+				return ec.checkServiceInstances(false)
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportedWithNamespaceDiscoverabilityLabel(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+
+	// Export the service.
+	ec.export(t)
+
+	ec.checkDiscoverabilityOrFail(t, true)
+
+	// Keep the exports in the namespace local.
+	ns := &coreV1.Namespace{
+		ObjectMeta: v12.ObjectMeta{
+			Name:   serviceExportNamespace,
+			Labels: map[string]string{exportNamespaceDiscoverabilityLabel: exportDiscoverabilityLocal},
+		},
+	}
+	ns, err := ec.client.CoreV1().Namespaces().Create(context.TODO(), ns, v12.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec.waitForXDS(t, false)
+	ec.checkDiscoverabilityOrFail(t, false)
+
+	// Remove the label.
+	ns.Labels = nil
+	if _, err := ec.client.CoreV1().Namespaces().Update(context.TODO(), ns, v12.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ec.waitForXDS(t, true)
+	ec.checkDiscoverabilityOrFail(t, true)
+}
+
+func TestServiceExportedWithClusterLocalMeshConfig(t *testing.T) {
+	meshWatcher := mesh.NewTestWatcher(&meshconfig.MeshConfig{})
+
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCacheWithOptions(t, meshWide, FakeControllerOptions{
+		Mode:        EndpointSliceOnly,
+		MeshWatcher: meshWatcher,
+	})
+	defer cleanup()
+
+	// Export the service.
+	ec.export(t)
+	ec.checkDiscoverabilityOrFail(t, true)
+
+	// The mesh config marks the service cluster-local, so its endpoints are no longer discoverable from other clusters.
+	if err := meshWatcher.Update(&meshconfig.MeshConfig{
+		ServiceSettings: []*meshconfig.MeshConfig_ServiceSettings{
+			{
+				Settings: &meshconfig.MeshConfig_ServiceSettings_Settings{ClusterLocal: true},
+				Hosts:    []string{"*." + serviceExportNamespace + ".svc." + ec.opts.DomainSuffix},
+			},
+		},
+	}, 5); err != nil {
+		t.Fatal(err)
+	}
+	ec.waitForXDS(t, false)
+	ec.checkDiscoverabilityOrFail(t, false)
+
+	// Reverting the mesh config makes the endpoints discoverable from other clusters again.
+	if err := meshWatcher.Update(&meshconfig.MeshConfig{}, 5); err != nil {
+		t.Fatal(err)
+	}
+	ec.waitForXDS(t, true)
+	ec.checkDiscoverabilityOrFail(t, true)
+}
+
+func TestServiceExportResync(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCacheWithOptions(t, meshWide, FakeControllerOptions{
+		Mode:            EndpointSliceOnly,
+		MCSResyncPeriod: 100 * time.Millisecond,
+	})
+	defer cleanup()
+	fx := ec.opts.XDSUpdater.(*FakeXdsUpdater)
+
+	// Export the service.
+	ec.export(t)
+	ec.waitForXDS(t, true)
+	hostName := ec.serviceHostname()
+	var pushed string
+	retry.UntilSuccessOrFail(t, func() error {
+		ec.mutex.Lock()
+		defer ec.mutex.Unlock()
+		pushed = ec.policies[serviceExportNamespacedName][hostName]
+		if !strings.HasPrefix(pushed, model.AlwaysDiscoverable.String()) {
+			return fmt.Errorf("expected a mesh-wide policy to be pushed for %s, found %q", hostName, pushed)
+		}
+		return nil
+	}, serviceExportTimeout)
+	fx.Clear()
+
+	// Drift: the policy recorded as pushed no longer matches the policy of the export, e.g. after a missed event.
+	ec.mutex.Lock()
+	ec.policies[serviceExportNamespacedName][hostName] = model.DiscoverableFromSameCluster.String()
+	ec.mutex.Unlock()
+
+	// The next resync pushes the endpoints with the policy of the export again.
+	if event := fx.Wait("eds"); event == nil || event.ID != hostName.String() {
+		t.Fatalf("expected the endpoints of %s to be pushed, found %v", hostName, event)
+	}
+	ec.mutex.Lock()
+	got := ec.policies[serviceExportNamespacedName][hostName]
+	ec.mutex.Unlock()
+	if got != pushed {
+		t.Fatalf("expected the pushed policy %q, found %q", pushed, got)
+	}
+
+	// Without drift, resyncs don't push.
+	ec.checkNoPush(t)
+}
+
+func TestServiceExportedFromTerminatingNamespace(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+
+	ns, err := ec.client.CoreV1().Namespaces().Create(context.TODO(), &coreV1.Namespace{
+		ObjectMeta: v12.ObjectMeta{Name: serviceExportNamespace},
+		Status:     coreV1.NamespaceStatus{Phase: coreV1.NamespaceActive},
+	}, v12.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Export the service.
+	ec.export(t)
+	ec.checkDiscoverabilityOrFail(t, true)
+
+	// The namespace starts terminating, so its exported services revert to cluster-local.
+	ns.Status.Phase = coreV1.NamespaceTerminating
+	if _, err := ec.client.CoreV1().Namespaces().UpdateStatus(context.TODO(), ns, v12.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ec.waitForXDS(t, false)
+	ec.checkDiscoverabilityOrFail(t, false)
+}
+
+func TestServiceExportUpdatePushesAffectedService(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+	fx := ec.opts.XDSUpdater.(*FakeXdsUpdater)
+
+	// Export the service.
+	ec.export(t)
+	fx.Clear()
+
+	updateAnnotations := func(annotations map[string]string) {
+		t.Helper()
+		ec.updateServiceExport(t, serviceExportName, func(se *v1alpha1.ServiceExport) {
+			se.Annotations = annotations
+		})
+	}
+
+	// An annotation that doesn't change the discoverability is followed by one that does.
+	updateAnnotations(map[string]string{"example.com/owner": "team-a"})
+	updateAnnotations(map[string]string{"example.com/owner": "team-a", exportZonesAnnotation: "us-east-1a"})
+
+	// Only an EDS push for the affected service is expected, and only for the change in discoverability.
+	event := fx.Wait("eds")
+	if event == nil {
+		t.Fatal("failed waiting for XDS event")
+	}
+	if event.ID != ec.serviceHostname().String() {
+		t.Fatalf("expected EDS push for %s, found %s", ec.serviceHostname(), event.ID)
+	}
+	for _, ep := range event.Endpoints {
+		if policy := ep.DiscoverabilityPolicy.String(); !strings.Contains(policy, "Zones(us-east-1a)") {
+			t.Fatalf("expected endpoint %s to be pushed with the zones policy, found %s", ep.Address, policy)
+		}
+	}
+	ec.checkNoPush(t)
+}
+
+func TestServiceExportGenerationStampedOnEndpoints(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+			fx := ec.opts.XDSUpdater.(*FakeXdsUpdater)
+
+			// The endpoints of an unexported service have no generation.
+			if ep := ec.endpointsByAddress()[serviceExportPodIP]; ep == nil || ep.ServiceExportGeneration != 0 {
+				t.Fatalf("expected endpoint %s without an export generation, found %v", serviceExportPodIP, ep)
+			}
+
+			// Export the service.
+			se := newServiceExport()
+			se.Generation = 1
+			if _, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+				context.TODO(), se, v12.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			retry.UntilSuccessOrFail(t, func() error {
+				ep := ec.endpointsByAddress()[serviceExportPodIP]
+				if ep == nil {
+					return fmt.Errorf("failed to find endpoint %s", serviceExportPodIP)
+				}
+				if ep.ServiceExportGeneration != 1 {
+					return fmt.Errorf("expected export generation 1, found %d", ep.ServiceExportGeneration)
+				}
+				return nil
+			}, serviceExportTimeout)
+
+			// Re-exporting the service pushes the endpoints with the new generation.
+			fx.Clear()
+			ec.updateServiceExport(t, serviceExportName, func(se *v1alpha1.ServiceExport) {
+				se.Generation = 2
+			})
+			retry.UntilSuccessOrFail(t, func() error {
+				event := fx.Wait("eds")
+				if event == nil {
+					return errors.New("failed waiting for XDS event")
+				}
+				if event.ID != ec.serviceHostname().String() || len(event.Endpoints) != 1 {
+					return fmt.Errorf("unexpected EDS push for %s with %d endpoints", event.ID, len(event.Endpoints))
+				}
+				if gen := event.Endpoints[0].ServiceExportGeneration; gen != 2 {
+					return fmt.Errorf("expected export generation 2, found %d", gen)
+				}
+				return nil
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestExportedServiceEndpointDelta(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Export the service.
+			ec.export(t)
+
+			// Add a second endpoint to the service.
+			addedIP := "128.0.0.3"
+			ec.setEndpoints(t, serviceExportPodIP, addedIP)
+
+			// Wait for an XDS event reporting only the new endpoint as added.
+			retry.UntilSuccessOrFail(t, func() error {
+				event := ec.opts.XDSUpdater.(*FakeXdsUpdater).Wait("eds")
+				if event == nil {
+					return errors.New("failed waiting for XDS event")
+				}
+				if len(event.AddedEndpoints) != 1 || event.AddedEndpoints[0].Address != addedIP {
+					return fmt.Errorf("expected endpoint %s to be added, found %v", addedIP, event.AddedEndpoints)
+				}
+				if len(event.RemovedEndpoints) != 0 {
+					return fmt.Errorf("expected no endpoints to be removed, found %v", event.RemovedEndpoints)
+				}
+				return nil
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestExportedServiceEndpointsAllRemoved(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Export the service.
+			ec.export(t)
+
+			// Remove every endpoint of the service.
+			ec.setEndpoints(t)
+
+			// Wait for an XDS event reporting the endpoint as removed.
+			retry.UntilSuccessOrFail(t, func() error {
+				event := ec.opts.XDSUpdater.(*FakeXdsUpdater).Wait("eds")
+				if event == nil {
+					return errors.New("failed waiting for XDS event")
+				}
+				if len(event.Endpoints) != 0 {
+					return fmt.Errorf("expected no endpoints, found %v", event.Endpoints)
+				}
+				if len(event.RemovedEndpoints) != 1 || event.RemovedEndpoints[0].Address != serviceExportPodIP {
+					return fmt.Errorf("expected endpoint %s to be removed, found %v", serviceExportPodIP, event.RemovedEndpoints)
+				}
+				return nil
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestExportedServiceEndpointIPChanged(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+			fx := ec.opts.XDSUpdater.(*FakeXdsUpdater)
+
+			// Export the service.
+			ec.export(t)
+			fx.Clear()
+
+			// Replace the endpoint, as if the pod was recreated with a new IP.
+			newIP := "128.0.0.3"
+			ec.setEndpoints(t, newIP)
+
+			// Expect a single XDS event with the delta, with the new endpoint still exported.
+			event := fx.Wait("eds")
+			if event == nil {
+				t.Fatal("failed waiting for XDS event")
+			}
+			if len(event.AddedEndpoints) != 1 || event.AddedEndpoints[0].Address != newIP {
+				t.Fatalf("expected endpoint %s to be added, found %v", newIP, event.AddedEndpoints)
+			}
+			if len(event.RemovedEndpoints) != 1 || event.RemovedEndpoints[0].Address != serviceExportPodIP {
+				t.Fatalf("expected endpoint %s to be removed, found %v", serviceExportPodIP, event.RemovedEndpoints)
+			}
+			if err := ec.checkDiscoverableFromDifferentCluster(event.AddedEndpoints[0]); err != nil {
+				t.Fatal(err)
+			}
+			if !ec.isExported(serviceExportNamespacedName) {
+				t.Fatal("expected the service to remain exported")
+			}
+			if e := fx.WaitForDuration("eds", 200*time.Millisecond); e != nil {
+				t.Fatalf("unexpected XDS event for %s: added %v, removed %v", e.ID, e.AddedEndpoints, e.RemovedEndpoints)
+			}
+		})
+	}
+}
+
+func TestExportedServiceEndpointsMetric(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Back the service with three endpoints and export it.
+			ec.setEndpoints(t, serviceExportPodIP, "128.0.0.3", "128.0.0.4")
+			ec.exportWithAnnotations(t, nil)
+			retry.UntilSuccessOrFail(t, func() error {
+				return ec.checkEndpointsMetric(3)
+			}, serviceExportTimeout)
+
+			// Scale the service down.
+			ec.setEndpoints(t, serviceExportPodIP)
+			retry.UntilSuccessOrFail(t, func() error {
+				return ec.checkEndpointsMetric(1)
+			}, serviceExportTimeout)
+
+			// The gauge of the service is removed once the service is unexported.
+			ec.unExport(t)
+			retry.UntilSuccessOrFail(t, func() error {
+				if got, ok := ec.endpointsMetric(); ok {
+					return fmt.Errorf("expected no endpoints to be reported, found %v", got)
+				}
+				return nil
+			}, serviceExportTimeout)
+
+			// Endpoint events of the unexported service don't report it again.
+			ec.setEndpoints(t, serviceExportPodIP, "128.0.0.3")
+			fx := ec.opts.XDSUpdater.(*FakeXdsUpdater)
+			retry.UntilSuccessOrFail(t, func() error {
+				if event := fx.Wait("eds"); event == nil || len(event.Endpoints) != 2 {
+					return errors.New("failed waiting for the endpoints to be updated")
+				}
+				return nil
+			}, serviceExportTimeout)
+			// The endpoint event is handled on the queue, so it has been fully handled once a later task runs.
+			handled := make(chan struct{})
+			ec.queue.Push(func() error {
+				close(handled)
+				return nil
+			})
+			<-handled
+			if got, ok := ec.endpointsMetric(); ok {
+				t.Fatalf("expected no endpoints to be reported, found %v", got)
+			}
+		})
+	}
+}
+
+func TestServiceExportStateForgotten(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// The endpoints of a service that isn't exported aren't tracked.
+			ec.setEndpoints(t, serviceExportPodIP, "128.0.0.3")
+			retry.UntilSuccessOrFail(t, func() error {
+				if n := len(ec.endpointsByAddress()); n != 2 {
+					return fmt.Errorf("expected 2 endpoints, found %d", n)
+				}
+				return nil
+			}, serviceExportTimeout)
+			if err := ec.checkServiceState(false); err != nil {
+				t.Fatal(err)
+			}
+
+			// The endpoints are counted once exported.
+			ec.export(t)
+			retry.UntilSuccessOrFail(t, func() error {
+				return ec.checkServiceState(true)
+			}, serviceExportTimeout)
+
+			// The state is dropped once unexported.
+			ec.unExport(t)
+			retry.UntilSuccessOrFail(t, func() error {
+				return ec.checkServiceState(false)
+			}, serviceExportTimeout)
+
+			// And once the service is deleted, even though it is still exported.
+			ec.export(t)
+			retry.UntilSuccessOrFail(t, func() error {
+				return ec.checkServiceState(true)
+			}, serviceExportTimeout)
+			if err := ec.client.CoreV1().Services(serviceExportNamespace).Delete(
+				context.TODO(), serviceExportName, v12.DeleteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			retry.UntilSuccessOrFail(t, func() error {
+				ec.mutex.Lock()
+				defer ec.mutex.Unlock()
+				if _, ok := ec.policies[serviceExportNamespacedName]; ok {
+					return errors.New("expected the policies of the deleted service to be dropped")
+				}
+				return nil
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestMCSClusterLastSyncMetric(t *testing.T) {
+	var syncTime int64 = 1000
+	prevMCSSyncClock := mcsSyncClock
+	mcsSyncClock = func() time.Time { return time.Unix(atomic.LoadInt64(&syncTime), 0) }
+	defer func() { mcsSyncClock = prevMCSSyncClock }()
+
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			atomic.StoreInt64(&syncTime, 1000)
+
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Exporting the service syncs the cluster.
+			ec.export(t)
+			retry.UntilSuccessOrFail(t, func() error {
+				return ec.checkLastSyncMetric(1000)
+			}, serviceExportTimeout)
+
+			// Later changes to the endpoints of the exported service advance the sync time.
+			atomic.StoreInt64(&syncTime, 2000)
+			ec.setEndpoints(t, serviceExportPodIP, "128.0.0.3")
+			retry.UntilSuccessOrFail(t, func() error {
+				return ec.checkLastSyncMetric(2000)
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportedAcrossEndpointSlices(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+
+	// Split the endpoints of the service across a second slice.
+	secondIP := "128.0.0.3"
+	ec.addEndpointSlice(t, serviceExportName+"-2", secondIP)
+	retry.UntilSuccessOrFail(t, func() error {
+		if eps := ec.endpointsByAddress(); len(eps) != 2 {
+			return fmt.Errorf("expected 2 endpoints, found %d", len(eps))
+		}
+		return nil
+	}, serviceExportTimeout)
+
+	// Export the service.
+	ec.exportWithAnnotations(t, nil)
+
+	// Wait for a single XDS event carrying the endpoints of both slices.
+	retry.UntilSuccessOrFail(t, func() error {
+		event := ec.opts.XDSUpdater.(*FakeXdsUpdater).Wait("eds")
+		if event == nil {
+			return errors.New("failed waiting for XDS event")
+		}
+		if len(event.Endpoints) != 2 {
+			return fmt.Errorf("expected 2 endpoints, found %d", len(event.Endpoints))
+		}
+		for _, ep := range event.Endpoints {
+			if err := ec.checkDiscoverableFromDifferentCluster(ep); err != nil {
+				return fmt.Errorf("endpoint %s: %v", ep.Address, err)
+			}
+		}
+		return nil
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportedRequiringMutualTLS(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Export the service, only to proxies using mutual TLS.
+			ec.exportWithAnnotations(t, map[string]string{exportRequireMutualTLSAnnotation: "true"})
+
+			proxyWithTLSMode := func(tlsMode string) *model.Proxy {
+				return &model.Proxy{
+					Metadata: &model.NodeMetadata{
+						ClusterID: "some-other-cluster",
+						Labels: map[string]string{
+							label.SecurityTlsMode.Name: tlsMode,
+						},
+					},
+				}
+			}
+
+			retry.UntilSuccessOrFail(t, func() error {
+				ep := ec.endpointsByAddress()[serviceExportPodIP]
+				if ep == nil {
+					return fmt.Errorf("failed to find endpoint %s", serviceExportPodIP)
+				}
+				if !ep.IsDiscoverableFromProxy(proxyWithTLSMode("mutual")) {
+					return errors.New("endpoint was not discoverable from a mutual TLS proxy in a different cluster")
+				}
+				if ep.IsDiscoverableFromProxy(proxyWithTLSMode(model.DisabledTLSModeLabel)) {
+					return errors.New("endpoint was discoverable from a non-mutual TLS proxy in a different cluster")
+				}
+				return ec.checkDiscoverableFromSameCluster(ep)
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportedToDualStackProxy(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Export the service with a filter, so that the exported endpoints are filtered by address family.
+			ec.exportWithAnnotations(t, map[string]string{exportRequireMutualTLSAnnotation: "true"})
+
+			proxyWithIPs := func(ips ...string) *model.Proxy {
+				proxy := &model.Proxy{
+					IPAddresses: ips,
+					Metadata: &model.NodeMetadata{
+						ClusterID: "some-other-cluster",
+						Labels: map[string]string{
+							label.SecurityTlsMode.Name: "mutual",
+						},
+					},
+				}
+				proxy.DiscoverIPVersions()
+				return proxy
+			}
+
+			retry.UntilSuccessOrFail(t, func() error {
+				// The exported endpoint only has an IPv4 address.
+				ep := ec.endpointsByAddress()[serviceExportPodIP]
+				if ep == nil {
+					return fmt.Errorf("failed to find endpoint %s", serviceExportPodIP)
+				}
+				if !ep.IsDiscoverableFromProxy(proxyWithIPs("10.1.0.1", "fd00::1")) {
+					return errors.New("IPv4 endpoint was not discoverable from a dual-stack proxy in a different cluster")
+				}
+				if !ep.IsDiscoverableFromProxy(proxyWithIPs("10.1.0.1")) {
+					return errors.New("IPv4 endpoint was not discoverable from an IPv4 proxy in a different cluster")
+				}
+				if ep.IsDiscoverableFromProxy(proxyWithIPs("fd00::1")) {
+					return errors.New("IPv4 endpoint was discoverable from an IPv6-only proxy in a different cluster")
+				}
+				return ec.checkDiscoverableFromSameCluster(ep)
+			}, serviceExportTimeout)
+		})
+	}
+}
+
+func TestServiceExportedWithUnknownPort(t *testing.T) {
+	for _, endpointMode := range EndpointModes {
+		t.Run(endpointMode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			ec, cleanup := newTestServiceExportCache(t, meshWide, endpointMode)
+			defer cleanup()
+
+			// Export only a port that the service doesn't expose.
+			ec.exportWithAnnotations(t, map[string]string{exportPortsAnnotation: "missing-port"})
+
+			retry.UntilSuccessOrFail(t, func() error {
+				se, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Get(
+					context.TODO(), serviceExportName, v12.GetOptions{})
+				if err != nil {
+					return err
+				}
+				for _, c := range se.Status.Conditions {
+					if c.Type != v1alpha1.ServiceExportValid {
+						continue
+					}
+					if c.Status != coreV1.ConditionFalse || c.Reason == nil || *c.Reason != serviceExportReasonUnknownPort {
+						return fmt.Errorf("unexpected Valid condition: %+v", c)
+					}
+					return nil
+				}
+				return errors.New("Valid condition not found")
+			}, serviceExportTimeout)
+
+			// The endpoints for the exposed port are not exported.
+			ep := ec.endpointsByAddress()[serviceExportPodIP]
+			if ep == nil {
+				t.Fatalf("failed to find endpoint %s", serviceExportPodIP)
+			}
+			if err := ec.checkNotDiscoverableFromDifferentCluster(ep); err != nil {
+				t.Fatal(err)
+			}
+			if err := ec.checkDiscoverableFromSameCluster(ep); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestExternalNameServiceExportedWithHostname(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+
+	// Create an ExternalName service and export it.
+	name := "external-svc"
+	externalName := "db.example.com"
+	svc := &coreV1.Service{
+		ObjectMeta: v12.ObjectMeta{Name: name, Namespace: serviceExportNamespace},
+		Spec: coreV1.ServiceSpec{
+			Ports:        []coreV1.ServicePort{{Name: "tcp-port", Port: 5432}},
+			Type:         coreV1.ServiceTypeExternalName,
+			ExternalName: externalName,
+		},
+	}
+	if _, err := ec.client.CoreV1().Services(serviceExportNamespace).Create(context.TODO(), svc, v12.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	se := newServiceExport()
+	se.Name = name
+	if _, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+		context.TODO(), se, v12.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	hostname := kube.ServiceHostname(name, serviceExportNamespace, ec.opts.DomainSuffix)
+	retry.UntilSuccessOrFail(t, func() error {
+		svc := ec.GetService(hostname)
+		if svc == nil {
+			return fmt.Errorf("failed to find service %s", hostname)
+		}
+		if svc.Resolution != model.DNSLB {
+			return fmt.Errorf("expected resolution %v, found %v", model.DNSLB, svc.Resolution)
+		}
+		instances := ec.InstancesByPort(svc, 5432, nil)
+		if len(instances) != 1 {
+			return fmt.Errorf("expected 1 instance, found %d", len(instances))
+		}
+		ep := instances[0].Endpoint
+		if ep.Address != externalName {
+			return fmt.Errorf("expected endpoint address %s, found %s", externalName, ep.Address)
+		}
+		return ec.checkDiscoverableFromDifferentCluster(ep)
+	}, serviceExportTimeout)
+}
+
+func TestServiceExportReappliedWithoutPush(t *testing.T) {
+	// Create and run the controller.
+	ec, cleanup := newTestServiceExportCache(t, meshWide, EndpointSliceOnly)
+	defer cleanup()
+	fx := ec.opts.XDSUpdater.(*FakeXdsUpdater)
+
+	// Export the test service, as well as an ExternalName service, whose endpoints are pushed with CDS.
+	name := "external-svc"
+	svc := &coreV1.Service{
+		ObjectMeta: v12.ObjectMeta{Name: name, Namespace: serviceExportNamespace},
+		Spec: coreV1.ServiceSpec{
+			Ports:        []coreV1.ServicePort{{Name: "tcp-port", Port: 5432}},
+			Type:         coreV1.ServiceTypeExternalName,
+			ExternalName: "db.example.com",
+		},
+	}
+	if _, err := ec.client.CoreV1().Services(serviceExportNamespace).Create(context.TODO(), svc, v12.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	se := newServiceExport()
+	se.Name = name
+	if _, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
+		context.TODO(), se, v12.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ec.export(t)
+	hostname := kube.ServiceHostname(name, serviceExportNamespace, ec.opts.DomainSuffix)
+	retry.UntilSuccessOrFail(t, func() error {
+		svc := ec.GetService(hostname)
+		if svc == nil {
+			return fmt.Errorf("failed to find service %s", hostname)
+		}
+		instances := ec.InstancesByPort(svc, 5432, nil)
+		if len(instances) != 1 {
+			return fmt.Errorf("expected 1 instance, found %d", len(instances))
+		}
+		return ec.checkDiscoverableFromDifferentCluster(instances[0].Endpoint)
+	}, serviceExportTimeout)
+	ec.checkServiceInstancesOrFail(t, true)
+	fx.Clear()
+
+	// Re-apply both exports, with an annotation that doesn't affect the discoverability of the endpoints.
+	for _, name := range []string{serviceExportName, name} {
+		ec.updateServiceExport(t, name, func(se *v1alpha1.ServiceExport) {
+			se.Annotations = map[string]string{"kubectl.kubernetes.io/last-applied-configuration": "{}"}
+		})
+	}
+
+	// The discoverability of the endpoints is unchanged, so neither service is pushed.
+	ec.checkNoPush(t)
+}
+
 func newServiceExport() *v1alpha1.ServiceExport {
 	return &v1alpha1.ServiceExport{
 		TypeMeta: v12.TypeMeta{
@@ -131,6 +1861,14 @@ func newServiceExport() *v1alpha1.ServiceExport {
 
 func newTestServiceExportCache(t *testing.T, clusterLocalMode ClusterLocalMode, endpointMode EndpointMode) (ec *serviceExportCacheImpl, cleanup func()) {
 	t.Helper()
+	return newTestServiceExportCacheWithOptions(t, clusterLocalMode, FakeControllerOptions{Mode: endpointMode, WriteMCSStatus: true})
+}
+
+// newTestServiceExportCacheWithOptions is like newTestServiceExportCache, but the controller is created with the
+// given options, e.g. to observe a mesh config. The stop channel and the cluster ID are set by the function.
+func newTestServiceExportCacheWithOptions(t *testing.T, clusterLocalMode ClusterLocalMode,
+	opts FakeControllerOptions) (ec *serviceExportCacheImpl, cleanup func()) {
+	t.Helper()
 
 	stopCh := make(chan struct{})
 	prevEnableMCSServiceDiscovery := features.EnableMCSServiceDiscovery
@@ -143,11 +1881,9 @@ func newTestServiceExportCache(t *testing.T, clusterLocalMode ClusterLocalMode,
 		features.EnableMCSClusterLocal = prevEnableMCSClusterLocal
 	}
 
-	c, _ := NewFakeControllerWithOptions(FakeControllerOptions{
-		Stop:      stopCh,
-		ClusterID: testCluster,
-		Mode:      endpointMode,
-	})
+	opts.Stop = stopCh
+	opts.ClusterID = testCluster
+	c, _ := NewFakeControllerWithOptions(opts)
 
 	// Create the test service and endpoints.
 	createService(c, serviceExportName, serviceExportNamespace, map[string]string{},
@@ -174,18 +1910,171 @@ func (ec *serviceExportCacheImpl) serviceHostname() host.Name {
 func (ec *serviceExportCacheImpl) export(t *testing.T) {
 	t.Helper()
 
+	ec.exportWithAnnotations(t, nil)
+
+	// Wait for the XDS event.
+	ec.waitForXDS(t, true)
+}
+
+func (ec *serviceExportCacheImpl) exportWithAnnotations(t *testing.T, annotations map[string]string) {
+	t.Helper()
+
+	se := newServiceExport()
+	se.Annotations = annotations
 	_, _ = ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Create(
 		context.TODO(),
-		newServiceExport(),
+		se,
 		v12.CreateOptions{})
 
 	// Wait for the export to be processed by the controller.
 	retry.UntilOrFail(t, func() bool {
 		return ec.isExported(serviceExportNamespacedName)
 	}, serviceExportTimeout)
+}
 
-	// Wait for the XDS event.
-	ec.waitForXDS(t, true)
+func (ec *serviceExportCacheImpl) addPods(t *testing.T, pods ...*coreV1.Pod) {
+	t.Helper()
+	addPods(t, &FakeController{ec.Controller}, ec.opts.XDSUpdater.(*FakeXdsUpdater), pods...)
+}
+
+// setEndpoints replaces the endpoints of the test service with the given addresses.
+func (ec *serviceExportCacheImpl) setEndpoints(t *testing.T, ips ...string) {
+	t.Helper()
+	createEndpoints(t, &FakeController{ec.Controller}, serviceExportName, serviceExportNamespace, []string{"tcp-port"}, ips, nil, nil)
+}
+
+// addEndpointSlice adds an additional EndpointSlice for the test service.
+func (ec *serviceExportCacheImpl) addEndpointSlice(t *testing.T, name string, ips ...string) {
+	t.Helper()
+	portName := "tcp-port"
+	var portNum int32 = 1001
+	slice := &discovery.EndpointSlice{
+		ObjectMeta: v12.ObjectMeta{
+			Name:      name,
+			Namespace: serviceExportNamespace,
+			Labels: map[string]string{
+				discovery.LabelServiceName: serviceExportName,
+			},
+		},
+		Endpoints: []discovery.Endpoint{{Addresses: ips}},
+		Ports:     []discovery.EndpointPort{{Name: &portName, Port: &portNum}},
+	}
+	if _, err := ec.client.DiscoveryV1().EndpointSlices(serviceExportNamespace).Create(context.TODO(), slice, v12.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// endpointsByAddress rebuilds the endpoints of the test service, indexed by address.
+func (ec *serviceExportCacheImpl) endpointsByAddress() map[string]*model.IstioEndpoint {
+	out := make(map[string]*model.IstioEndpoint)
+	svc := ec.GetService(ec.serviceHostname())
+	if svc == nil {
+		return out
+	}
+	for _, ep := range ec.buildEndpointsForService(svc, true) {
+		out[ep.Address] = ep
+	}
+	return out
+}
+
+// checkEndpointsMetric checks the value of the pilot_mcs_service_endpoints gauge for the test service.
+func (ec *serviceExportCacheImpl) checkEndpointsMetric(want int64) error {
+	got, ok := ec.endpointsMetric()
+	if !ok {
+		return errors.New("no pilot_mcs_service_endpoints data for the test service")
+	}
+	if got != want {
+		return fmt.Errorf("expected %v endpoints, found %v", want, got)
+	}
+	return nil
+}
+
+// checkServiceState checks whether the policies and endpoint count of the test service are tracked.
+func (ec *serviceExportCacheImpl) checkServiceState(tracked bool) error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	_, hasPolicies := ec.policies[serviceExportNamespacedName]
+	_, hasCount := ec.endpointCounts[serviceExportNamespacedName]
+	_, hasEvents := ec.endpointEvents[serviceExportNamespacedName]
+	if tracked && (!hasPolicies || !hasCount) {
+		return fmt.Errorf("expected the service to be tracked: policies %v, endpoint count %v", hasPolicies, hasCount)
+	}
+	if !tracked && (hasPolicies || hasCount || hasEvents) {
+		return fmt.Errorf("expected the service not to be tracked: policies %v, endpoint count %v, endpoint events %v",
+			hasPolicies, hasCount, hasEvents)
+	}
+	return nil
+}
+
+// endpointsMetric returns the value of the pilot_mcs_service_endpoints gauge for the test service. ok is false if
+// the gauge has no value for the service.
+func (ec *serviceExportCacheImpl) endpointsMetric() (value int64, ok bool) {
+	for _, m := range mcsMetrics.Read() {
+		if m.Descriptor.Name != "pilot_mcs_service_endpoints" {
+			continue
+		}
+		for _, ts := range m.TimeSeries {
+			// The label values are in the order of the label keys: service, then cluster.
+			if ts.LabelValues[0].Value != serviceExportNamespacedName.String() || ts.LabelValues[1].Value != ec.Cluster().String() {
+				continue
+			}
+			if len(ts.Points) > 0 {
+				return ts.Points[0].Value.(int64), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// checkLastSyncMetric checks the value of the pilot_mcs_cluster_last_sync_seconds gauge for the test cluster.
+func (ec *serviceExportCacheImpl) checkLastSyncMetric(want float64) error {
+	rows, err := view.RetrieveData("pilot_mcs_cluster_last_sync_seconds")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		for _, tag := range row.Tags {
+			if tag.Key.Name() != "cluster" || tag.Value != ec.Cluster().String() {
+				continue
+			}
+			if got := row.Data.(*view.LastValueData).Value; got != want {
+				return fmt.Errorf("expected last sync at %v, found %v", want, got)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no last sync reported for cluster %s", ec.Cluster())
+}
+
+// updateServiceExport re-applies the ServiceExport with the given name, after modifying it with update.
+func (ec *serviceExportCacheImpl) updateServiceExport(t *testing.T, name string, update func(se *v1alpha1.ServiceExport)) {
+	t.Helper()
+	se, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Get(
+		context.TODO(), name, v12.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update(se)
+	if _, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(serviceExportNamespace).Update(
+		context.TODO(), se, v12.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkNoPush fails the test if a push of the services or their endpoints is received shortly.
+func (ec *serviceExportCacheImpl) checkNoPush(t *testing.T) {
+	t.Helper()
+	fx := ec.opts.XDSUpdater.(*FakeXdsUpdater)
+	for {
+		select {
+		case e := <-fx.Events:
+			if e.Type == "eds" || e.Type == "xds" || e.Type == "service" {
+				t.Fatalf("unexpected %s event for %s", e.Type, e.ID)
+			}
+		case <-time.After(200 * time.Millisecond):
+			return
+		}
+	}
 }
 
 func (ec *serviceExportCacheImpl) unExport(t *testing.T) {
@@ -276,6 +2165,19 @@ func (ec *serviceExportCacheImpl) checkServiceInstance(exported bool, si *model.
 	return ec.checkNotDiscoverableFromDifferentCluster(ep)
 }
 
+// checkDiscoverabilityOrFail waits for the test service to be discoverable mesh-wide if exported, or only from the
+// same cluster otherwise.
+func (ec *serviceExportCacheImpl) checkDiscoverabilityOrFail(t *testing.T, exported bool) {
+	t.Helper()
+	want := model.DiscoverableFromSameCluster
+	if exported {
+		want = model.AlwaysDiscoverable
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		return ec.AssertDiscoverability(map[host.Name]model.EndpointDiscoverabilityPolicy{ec.serviceHostname(): want})
+	}, serviceExportTimeout)
+}
+
 func (ec *serviceExportCacheImpl) checkDiscoverableFromSameCluster(ep *model.IstioEndpoint) error {
 	if !ec.isDiscoverableFromSameCluster(ep) {
 		return fmt.Errorf("endpoint was not discoverable from the same cluster")
@@ -297,6 +2199,37 @@ func (ec *serviceExportCacheImpl) checkNotDiscoverableFromDifferentCluster(ep *m
 	return nil
 }
 
+// AssertDiscoverability checks the discoverability policy of each of the tracked services against the expected
+// policies, keyed by hostname. Policies are compared by name, which describes any filters they apply (e.g. the zones
+// or ports an export is restricted to). The error reports every expected host that is missing or has a different
+// policy.
+func (ec *serviceExportCacheImpl) AssertDiscoverability(expected map[host.Name]model.EndpointDiscoverabilityPolicy) error {
+	services, err := ec.Services()
+	if err != nil {
+		return err
+	}
+	actual := make(map[host.Name]model.EndpointDiscoverabilityPolicy, len(services))
+	for _, svc := range services {
+		actual[svc.Hostname] = ec.EndpointDiscoverabilityPolicy(svc)
+	}
+
+	var mismatches []string
+	for hostname, want := range expected {
+		got, found := actual[hostname]
+		switch {
+		case !found:
+			mismatches = append(mismatches, fmt.Sprintf("%s: expected %s, service not found", hostname, want))
+		case got.String() != want.String():
+			mismatches = append(mismatches, fmt.Sprintf("%s: expected %s, found %s", hostname, want, got))
+		}
+	}
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("unexpected discoverability of %d services:\n%s", len(mismatches), strings.Join(mismatches, "\n"))
+	}
+	return nil
+}
+
 func (ec *serviceExportCacheImpl) isDiscoverableFromSameCluster(ep *model.IstioEndpoint) bool {
 	return ep.IsDiscoverableFromProxy(&model.Proxy{
 		Metadata: &model.NodeMetadata{
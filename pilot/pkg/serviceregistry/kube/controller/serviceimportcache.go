@@ -60,6 +60,9 @@ type serviceImportCache interface {
 	GetClusterSetIPs(name types.NamespacedName) []string
 	HasSynced() bool
 	ImportedServices() []importedService
+
+	// IsImported indicates whether the cluster holds a ServiceImport for the given service.
+	IsImported(name types.NamespacedName) bool
 }
 
 // newServiceImportCache creates a new cache of ServiceImport resources in the cluster.
@@ -102,7 +105,7 @@ func (ic *serviceImportCacheImpl) onServiceEvent(svc *model.Service, event model
 	namespacedName := namespacedNameForService(svc)
 
 	// Lookup the previous MCS service if there was one.
-	mcsHost := serviceClusterSetLocalHostname(namespacedName)
+	mcsHost := ic.exports.ClusterSetHostname(namespacedName)
 	prevMcsService := ic.GetService(mcsHost)
 
 	// Get the ClusterSet VIPs for this service in this cluster. Will only be populated if the
@@ -139,12 +142,14 @@ func (ic *serviceImportCacheImpl) onServiceImportEvent(obj interface{}, event mo
 			return fmt.Errorf("tombstone contained object that is not a ServiceImport %#v", obj)
 		}
 	}
+	defer recordMCSSync(ic.Cluster())
+	defer ic.notifyExporters(kube.NamespacedNameForK8sObject(si))
 
 	// We need a full push if the cluster VIP changes.
 	needsFullPush := false
 
 	// Get the updated MCS service.
-	mcsHost := serviceClusterSetLocalHostnameForKR(si)
+	mcsHost := ic.exports.ClusterSetHostname(kube.NamespacedNameForK8sObject(si))
 	mcsService := ic.GetService(mcsHost)
 	if mcsService == nil {
 		if event == model.EventDelete || len(si.Spec.IPs) == 0 {
@@ -188,7 +193,7 @@ func (ic *serviceImportCacheImpl) onServiceImportEvent(obj interface{}, event mo
 			ConfigsUpdated: map[model.ConfigKey]struct{}{{
 				Kind:      gvk.ServiceEntry,
 				Name:      mcsHost.String(),
-				Namespace: si.Namespace,
+				Namespace: mcsService.Attributes.Namespace,
 			}: {}},
 			Reason: []model.TriggerReason{model.ServiceUpdate},
 		}
@@ -201,6 +206,9 @@ func (ic *serviceImportCacheImpl) onServiceImportEvent(obj interface{}, event mo
 func (ic *serviceImportCacheImpl) genMCSService(realService *model.Service, mcsHost host.Name, vips []string) *model.Service {
 	mcsService := realService.DeepCopy()
 	mcsService.Hostname = mcsHost
+	name := namespacedNameForService(realService)
+	setClusterSetNamespace(mcsService, name, ic.exports.ClusterSetNamespace(name))
+	mcsService.Attributes.LoadBalancer = ic.exports.LoadBalancerPolicy(name)
 
 	if len(vips) > 0 {
 		mcsService.DefaultAddress = vips[0]
@@ -237,7 +245,7 @@ func (ic *serviceImportCacheImpl) ImportedServices() []importedService {
 		}
 
 		// Lookup the synthetic MCS service.
-		hostName := serviceClusterSetLocalHostnameForKR(si)
+		hostName := ic.exports.ClusterSetHostname(info.namespacedName)
 		svc := ic.servicesMap[hostName]
 		if svc != nil {
 			if vips := svc.ClusterVIPs.GetAddressesFor(ic.Cluster()); len(vips) > 0 {
@@ -252,6 +260,31 @@ func (ic *serviceImportCacheImpl) ImportedServices() []importedService {
 	return out
 }
 
+func (ic *serviceImportCacheImpl) IsImported(name types.NamespacedName) bool {
+	_, err := ic.lister.ServiceImports(name.Namespace).Get(name.Name)
+	return err == nil
+}
+
+// notifyExporters reports a change of the ServiceImport of the given service in this cluster to the export caches
+// of the other clusters in the mesh, which reflect the clusters importing their exports.
+func (ic *serviceImportCacheImpl) notifyExporters(name types.NamespacedName) {
+	if ic.opts.MeshServiceController == nil {
+		return
+	}
+	for _, r := range ic.opts.MeshServiceController.GetRegistries() {
+		c, ok := r.(*Controller)
+		if !ok || c.Cluster() == ic.Cluster() {
+			continue
+		}
+		if ec, ok := c.exports.(*serviceExportCacheImpl); ok {
+			ec.queue.Push(func() error {
+				ec.importsUpdated(name)
+				return nil
+			})
+		}
+	}
+}
+
 func (ic *serviceImportCacheImpl) HasSynced() bool {
 	return ic.informer.HasSynced()
 }
@@ -268,6 +301,10 @@ func (c disabledServiceImportCache) HasSynced() bool {
 	return true
 }
 
+func (c disabledServiceImportCache) IsImported(types.NamespacedName) bool {
+	return false
+}
+
 func (c disabledServiceImportCache) ImportedServices() []importedService {
 	// MCS is disabled - returning `nil`, which is semantically different here than an empty list.
 	return nil
@@ -29,6 +29,7 @@ import (
 	mcs "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 
 	"istio.io/api/label"
+	networking "istio.io/api/networking/v1alpha3"
 	"istio.io/istio/pilot/pkg/features"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/serviceregistry/kube"
@@ -195,6 +196,141 @@ func TestUpdateServiceImportVIPs(t *testing.T) {
 	}
 }
 
+func TestImportedServiceLoadBalancerFromExport(t *testing.T) {
+	prevEnableMCSServiceDiscovery := features.EnableMCSServiceDiscovery
+	features.EnableMCSServiceDiscovery = true
+	defer func() {
+		features.EnableMCSServiceDiscovery = prevEnableMCSServiceDiscovery
+	}()
+
+	for _, mode := range []EndpointMode{EndpointsOnly, EndpointSliceOnly} {
+		t.Run(mode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			c, ic, cleanup := newTestServiceImportCache(mode)
+			defer cleanup()
+
+			ic.createKubeService(t, c)
+
+			// Export the service, requesting a load balancing policy.
+			ec := ic.exports.(*serviceExportCacheImpl)
+			ec.exportWithAnnotations(t, map[string]string{exportLoadBalancerAnnotation: "LEAST_CONN"})
+			ic.createServiceImport(t, mcs.ClusterSetIP, serviceImportVIPs)
+
+			// Check that the policy was applied to the synthetic MCS service.
+			retry.UntilSuccessOrFail(t, func() error {
+				svc := ic.GetService(serviceImportClusterSetHost)
+				if svc == nil {
+					return fmt.Errorf("failed to find service for host %s", serviceImportClusterSetHost)
+				}
+				lb := svc.Attributes.LoadBalancer
+				if lb == nil || *lb != networking.LoadBalancerSettings_LEAST_CONN {
+					return fmt.Errorf("expected load balancer LEAST_CONN, found %v", lb)
+				}
+				return nil
+			}, serviceImportTimeout)
+		})
+	}
+}
+
+func TestServiceImportedWithNamespaceSameness(t *testing.T) {
+	prevEnableMCSServiceDiscovery := features.EnableMCSServiceDiscovery
+	features.EnableMCSServiceDiscovery = true
+	prevMCSNamespaceSameness := features.MCSNamespaceSameness
+	features.MCSNamespaceSameness = map[string]string{serviceImportNamespace: "canonical-ns"}
+	defer func() {
+		features.EnableMCSServiceDiscovery = prevEnableMCSServiceDiscovery
+		features.MCSNamespaceSameness = prevMCSNamespaceSameness
+	}()
+	canonicalHost := host.Name(serviceImportName + ".canonical-ns.svc.clusterset.local")
+
+	for _, mode := range []EndpointMode{EndpointsOnly, EndpointSliceOnly} {
+		t.Run(mode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			c, ic, cleanup := newTestServiceImportCache(mode)
+			defer cleanup()
+
+			ic.createKubeService(t, c)
+
+			// Export and import the service.
+			ec := ic.exports.(*serviceExportCacheImpl)
+			ec.exportWithAnnotations(t, nil)
+			if _, err := ic.client.MCSApis().MulticlusterV1alpha1().ServiceImports(serviceImportNamespace).Create(
+				context.TODO(), newServiceImport(mcs.ClusterSetIP, serviceImportVIPs), kubeMeta.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			// Check that the synthetic MCS service uses the canonical namespace.
+			retry.UntilSuccessOrFail(t, func() error {
+				if svc := ic.GetService(canonicalHost); svc == nil {
+					return fmt.Errorf("failed to find service for host %s", canonicalHost)
+				}
+				if svc := ic.GetService(serviceImportClusterSetHost); svc != nil {
+					return fmt.Errorf("found unexpected service for host %s", serviceImportClusterSetHost)
+				}
+				for _, es := range ec.ExportedServices() {
+					if _, ok := es.discoverability[canonicalHost]; ok {
+						return nil
+					}
+				}
+				return fmt.Errorf("host %s is not exported", canonicalHost)
+			}, serviceImportTimeout)
+		})
+	}
+}
+
+func TestImportedServiceWithHostnameOverride(t *testing.T) {
+	prevEnableMCSServiceDiscovery := features.EnableMCSServiceDiscovery
+	features.EnableMCSServiceDiscovery = true
+	defer func() {
+		features.EnableMCSServiceDiscovery = prevEnableMCSServiceDiscovery
+	}()
+	overrideHost := host.Name("legacy.team-a.svc.clusterset.local")
+
+	for _, mode := range []EndpointMode{EndpointsOnly, EndpointSliceOnly} {
+		t.Run(mode.String(), func(t *testing.T) {
+			// Create and run the controller.
+			c, ic, cleanup := newTestServiceImportCache(mode)
+			defer cleanup()
+
+			ic.createKubeService(t, c)
+
+			// Export the service under another hostname and import it.
+			ec := ic.exports.(*serviceExportCacheImpl)
+			ec.exportWithAnnotations(t, map[string]string{exportHostnameAnnotation: overrideHost.String()})
+			if _, err := ic.client.MCSApis().MulticlusterV1alpha1().ServiceImports(serviceImportNamespace).Create(
+				context.TODO(), newServiceImport(mcs.ClusterSetIP, serviceImportVIPs), kubeMeta.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			// Check that the synthetic MCS service uses the overridden hostname.
+			retry.UntilSuccessOrFail(t, func() error {
+				if svc := ic.GetService(overrideHost); svc == nil {
+					return fmt.Errorf("failed to find service for host %s", overrideHost)
+				}
+				if svc := ic.GetService(serviceImportClusterSetHost); svc != nil {
+					return fmt.Errorf("found unexpected service for host %s", serviceImportClusterSetHost)
+				}
+				return nil
+			}, serviceImportTimeout)
+
+			// An override conflicting with the hostname of another exported service is ignored.
+			other := newServiceExport()
+			other.Name = "legacy"
+			other.Namespace = "team-a"
+			if _, err := ec.client.MCSApis().MulticlusterV1alpha1().ServiceExports(other.Namespace).Create(
+				context.TODO(), other, kubeMeta.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			retry.UntilSuccessOrFail(t, func() error {
+				if got := ec.ClusterSetHostname(serviceImportNamespacedName); got != serviceImportClusterSetHost {
+					return fmt.Errorf("expected hostname %s, found %s", serviceImportClusterSetHost, got)
+				}
+				return nil
+			}, serviceImportTimeout)
+		})
+	}
+}
+
 func newTestServiceImportCache(mode EndpointMode) (c *FakeController, ic *serviceImportCacheImpl, cleanup func()) {
 	stopCh := make(chan struct{})
 	prevEnableMCSHost := features.EnableMCSHost
@@ -222,7 +358,7 @@ func (ic *serviceImportCacheImpl) createKubeService(t *testing.T, c *FakeControl
 		[]int32{8080}, map[string]string{"app": "prod-app"}, t)
 	createEndpoints(t, c, serviceImportName, serviceImportNamespace, []string{"tcp-port"}, []string{serviceImportPodIP}, nil, nil)
 
-	isImported := ic.isImported(serviceImportNamespacedName)
+	isImported := ic.IsImported(serviceImportNamespacedName)
 
 	// Wait for the resources to be processed by the controller.
 	retry.UntilSuccessOrFail(t, func() error {
@@ -397,7 +533,7 @@ func (ic *serviceImportCacheImpl) createServiceImport(t *testing.T, importType m
 
 	// Wait for the export to be processed by the controller.
 	retry.UntilSuccessOrFail(t, func() error {
-		if !ic.isImported(serviceImportNamespacedName) {
+		if !ic.IsImported(serviceImportNamespacedName) {
 			return fmt.Errorf("serviceImport not found for %s", serviceImportClusterSetHost)
 		}
 		if shouldCreateMCSService && ic.GetService(serviceImportClusterSetHost) == nil {
@@ -467,7 +603,7 @@ func (ic *serviceImportCacheImpl) unimportService(t *testing.T) {
 
 	// Wait for the export to be processed by the controller.
 	retry.UntilSuccessOrFail(t, func() error {
-		if ic.isImported(serviceImportNamespacedName) {
+		if ic.IsImported(serviceImportNamespacedName) {
 			return fmt.Errorf("serviceImport found for %s", serviceImportClusterSetHost)
 		}
 		if ic.GetService(serviceImportClusterSetHost) != nil {
@@ -477,11 +613,6 @@ func (ic *serviceImportCacheImpl) unimportService(t *testing.T) {
 	}, serviceImportTimeout)
 }
 
-func (ic *serviceImportCacheImpl) isImported(name types.NamespacedName) bool {
-	_, err := ic.lister.ServiceImports(name.Namespace).Get(name.Name)
-	return err == nil
-}
-
 func (ic *serviceImportCacheImpl) waitForXDS(t *testing.T) {
 	t.Helper()
 
@@ -29,6 +29,7 @@ import (
 	listerv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 
+	"istio.io/istio/pilot/pkg/features"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/serviceregistry/kube"
 	"istio.io/istio/pkg/config/constants"
@@ -225,16 +226,47 @@ func convertToService(obj interface{}) (*v1.Service, error) {
 	return cm, nil
 }
 
+// namespacedNameForService returns the name of the Kubernetes service of svc. For a synthetic clusterset.local
+// service moved to its canonical namespace, that's the namespace it was generated from.
 func namespacedNameForService(svc *model.Service) types.NamespacedName {
+	namespace := svc.Attributes.Namespace
+	if svc.Attributes.SourceNamespace != "" {
+		namespace = svc.Attributes.SourceNamespace
+	}
 	return types.NamespacedName{
-		Namespace: svc.Attributes.Namespace,
+		Namespace: namespace,
 		Name:      svc.Attributes.Name,
 	}
 }
 
-// serviceClusterSetLocalHostname produces Kubernetes Multi-Cluster Services (MCS) ClusterSet FQDN for a k8s service
+// serviceClusterSetLocalHostname produces Kubernetes Multi-Cluster Services (MCS) ClusterSet FQDN for a k8s service.
+// The namespace is replaced by its canonical namespace, if one is configured by features.MCSNamespaceSameness.
 func serviceClusterSetLocalHostname(nn types.NamespacedName) host.Name {
-	return host.Name(nn.Name + "." + nn.Namespace + "." + "svc" + "." + constants.DefaultClusterSetLocalDomain)
+	return clusterSetLocalHostname(nn.Name, canonicalNamespace(nn.Namespace))
+}
+
+// clusterSetLocalHostname produces the MCS ClusterSet FQDN of the named service in the given namespace, as is.
+func clusterSetLocalHostname(name, namespace string) host.Name {
+	return host.Name(name + "." + namespace + "." + "svc" + "." + constants.DefaultClusterSetLocalDomain)
+}
+
+// canonicalNamespace returns the canonical namespace of the given namespace configured by
+// features.MCSNamespaceSameness, or the namespace itself if it isn't mapped.
+func canonicalNamespace(namespace string) string {
+	if canonical, ok := features.MCSNamespaceSameness[namespace]; ok {
+		return canonical
+	}
+	return namespace
+}
+
+// setClusterSetNamespace moves the synthetic clusterset.local service of the given service to namespace,
+// recording the namespace of the service if they differ.
+func setClusterSetNamespace(mcsService *model.Service, name types.NamespacedName, namespace string) {
+	mcsService.Attributes.Namespace = namespace
+	mcsService.Attributes.SourceNamespace = ""
+	if namespace != name.Namespace {
+		mcsService.Attributes.SourceNamespace = name.Namespace
+	}
 }
 
 // serviceClusterSetLocalHostnameForKR calls serviceClusterSetLocalHostname with the name and namespace of the given kubernetes resource.
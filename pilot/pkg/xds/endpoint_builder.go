@@ -274,6 +274,16 @@ func (b *EndpointBuilder) buildLocalityLbEndpointsFromShards(
 	// and should, therefore, not be accessed from outside the cluster.
 	isClusterLocal := b.clusterLocal
 
+	// The shards may hold duplicate endpoints, e.g. when the clusters sharing a VIP in a flat network report the
+	// same endpoints. Only include each endpoint once.
+	type endpointKey struct {
+		address string
+		port    uint32
+		scope   string
+	}
+	dupScope := model.DuplicateEndpointScope(b.service)
+	seen := make(map[endpointKey]struct{})
+
 	shards.mutex.Lock()
 	// Extract shard keys so we can iterate in order. This ensures a stable EDS output. Since
 	// len(shards) ~= number of remote clusters which isn't too large, doing this sort shouldn't be
@@ -307,6 +317,13 @@ func (b *EndpointBuilder) buildLocalityLbEndpointsFromShards(
 			if !epLabels.HasSubsetOf(ep.Labels) {
 				continue
 			}
+			if dupScope != nil {
+				key := endpointKey{address: ep.Address, port: ep.EndpointPort, scope: dupScope(ep)}
+				if _, dup := seen[key]; dup {
+					continue
+				}
+				seen[key] = struct{}{}
+			}
 
 			locLbEps, found := localityEpMap[ep.Locality.Label]
 			if !found {
@@ -414,6 +431,7 @@ func buildEnvoyLbEndpoint(e *model.IstioEndpoint) *endpoint.LbEndpoint {
 	// Istio endpoint level tls transport socket configuration depends on this logic
 	// Do not remove pilot/pkg/xds/fake.go
 	ep.Metadata = util.BuildLbEndpointMetadata(e.Network, e.TLSMode, e.WorkloadName, e.Namespace, e.Locality.ClusterID, e.Labels)
+	ep.Metadata = util.AddALPNHintsMetadata(ep.Metadata, e.ALPNHints)
 
 	return ep
 }
@@ -0,0 +1,247 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xds
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	mcs "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pilot/pkg/features"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/util"
+	memregistry "istio.io/istio/pilot/pkg/serviceregistry/memory"
+	"istio.io/istio/pilot/test/xdstest"
+	"istio.io/istio/pkg/cluster"
+	"istio.io/istio/pkg/config/host"
+	"istio.io/istio/pkg/config/mesh"
+	"istio.io/istio/pkg/config/protocol"
+	"istio.io/istio/pkg/config/schema/collections"
+	kubelib "istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+func TestBuildLocalityLbEndpointsFromShardsDedup(t *testing.T) {
+	const (
+		clusterSetHost = "example.ns.svc.clusterset.local"
+		sharedVIPHost  = "shared.ns.svc.cluster.local"
+		plainHost      = "example.ns.svc.cluster.local"
+	)
+	sd := memregistry.NewServiceDiscovery([]*model.Service{
+		{
+			Hostname:   clusterSetHost,
+			Attributes: model.ServiceAttributes{Name: "example", Namespace: "ns"},
+		},
+		{
+			Hostname: sharedVIPHost,
+			ClusterVIPs: model.AddressMap{
+				Addresses: map[cluster.ID][]string{
+					"cluster1": {"240.0.0.1"},
+					"cluster2": {"240.0.0.1"},
+				},
+			},
+			Attributes: model.ServiceAttributes{Name: "shared", Namespace: "ns"},
+		},
+		{
+			Hostname:   plainHost,
+			Attributes: model.ServiceAttributes{Name: "example", Namespace: "ns"},
+		},
+	})
+	env := &model.Environment{
+		ServiceDiscovery: sd,
+		IstioConfigStore: model.MakeIstioStore(memory.Make(collections.Pilot)),
+		Watcher:          mesh.NewFixedWatcher(&meshconfig.MeshConfig{RootNamespace: "istio-system"}),
+		NetworksWatcher:  mesh.NewFixedNetworksWatcher(&meshconfig.MeshNetworks{}),
+	}
+	env.Init()
+	push := model.NewPushContext()
+	_ = push.InitContext(env, nil, nil)
+
+	shards := func(hostname host.Name, addresses map[cluster.ID][]string) *EndpointShards {
+		out := &EndpointShards{Shards: make(map[model.ShardKey][]*model.IstioEndpoint)}
+		for clusterID, ips := range addresses {
+			for _, ip := range ips {
+				ep := &model.IstioEndpoint{
+					Address:         ip,
+					EndpointPort:    8080,
+					ServicePortName: "http",
+					Namespace:       "ns",
+					HostName:        string(hostname),
+					Network:         "network1",
+				}
+				ep.Locality.ClusterID = clusterID
+				key := model.ShardKey(clusterID)
+				out.Shards[key] = append(out.Shards[key], ep)
+			}
+		}
+		return out
+	}
+
+	cases := []struct {
+		name      string
+		hostname  host.Name
+		addresses map[cluster.ID][]string
+		want      []string
+	}{
+		{
+			name:     "clusterset host dedups within a cluster",
+			hostname: clusterSetHost,
+			addresses: map[cluster.ID][]string{
+				"cluster1": {"10.0.0.1", "10.0.0.1", "10.0.0.2"},
+				"cluster2": {"10.0.0.1"},
+			},
+			want: []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name:     "shared VIP dedups within a network",
+			hostname: sharedVIPHost,
+			addresses: map[cluster.ID][]string{
+				"cluster1": {"10.0.0.1"},
+				"cluster2": {"10.0.0.1", "10.0.0.2"},
+			},
+			want: []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name:     "other hosts are not deduped",
+			hostname: plainHost,
+			addresses: map[cluster.ID][]string{
+				"cluster1": {"10.0.0.1"},
+				"cluster2": {"10.0.0.1"},
+			},
+			want: []string{"10.0.0.1", "10.0.0.1"},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := xdsConnection("network1", "cluster1").proxy
+			b := NewEndpointBuilder(model.BuildSubsetKey(model.TrafficDirectionOutbound, "", tt.hostname, 80), proxy, push)
+			eps := b.buildLocalityLbEndpointsFromShards(shards(tt.hostname, tt.addresses),
+				&model.Port{Name: "http", Port: 80, Protocol: protocol.HTTP})
+			var got []string
+			for _, locEps := range eps {
+				locEps.AssertInvarianceInTest()
+				got = append(got, getLbEndpointAddrs(&locEps.llbEndpoints)...)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected endpoints %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEndpointsOfOverlappingServiceExports(t *testing.T) {
+	prevEnableMCSServiceDiscovery := features.EnableMCSServiceDiscovery
+	prevEnableMCSHost := features.EnableMCSHost
+	features.EnableMCSServiceDiscovery = true
+	features.EnableMCSHost = true
+	t.Cleanup(func() {
+		features.EnableMCSServiceDiscovery = prevEnableMCSServiceDiscovery
+		features.EnableMCSHost = prevEnableMCSHost
+	})
+
+	// Both clusters export the service, and their endpoints overlap: 10.0.0.2 is listed by both of them, and twice
+	// in cluster1.
+	serviceObjects := func(ips ...string) []runtime.Object {
+		var addresses []corev1.EndpointAddress
+		for _, ip := range ips {
+			addresses = append(addresses, corev1.EndpointAddress{IP: ip})
+		}
+		ports := []corev1.EndpointPort{{Name: "http", Port: 8080}}
+		return []runtime.Object{
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "ns"},
+				Spec: corev1.ServiceSpec{
+					ClusterIP: "10.96.0.1",
+					Ports:     []corev1.ServicePort{{Name: "http", Port: 80}},
+				},
+			},
+			&corev1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "ns"},
+				Subsets: []corev1.EndpointSubset{
+					{Addresses: addresses, Ports: ports},
+					{Addresses: addresses[len(addresses)-1:], Ports: ports},
+				},
+			},
+		}
+	}
+	s := NewFakeDiscoveryServer(t, FakeOptions{
+		DefaultClusterName: "cluster1",
+		KubernetesObjectsByCluster: map[cluster.ID][]runtime.Object{
+			"cluster1": serviceObjects("10.0.0.1", "10.0.0.2"),
+			"cluster2": serviceObjects("10.0.0.3", "10.0.0.2"),
+		},
+		KubeClientModifier: func(c kubelib.Client) {
+			se := &mcs.ServiceExport{ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "ns"}}
+			if _, err := c.MCSApis().MulticlusterV1alpha1().ServiceExports("ns").Create(
+				context.TODO(), se, metav1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			si := &mcs.ServiceImport{
+				ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "ns"},
+				Spec:       mcs.ServiceImportSpec{Type: mcs.ClusterSetIP, IPs: []string{"240.240.0.1"}},
+			}
+			if _, err := c.MCSApis().MulticlusterV1alpha1().ServiceImports("ns").Create(
+				context.TODO(), si, metav1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		},
+	})
+
+	// The EDS shards of the clusters are merged into a single load assignment, holding each endpoint once.
+	const clusterName = "outbound|80||example.ns.svc.clusterset.local"
+	want := []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080"}
+	retry.UntilSuccessOrFail(t, func() error {
+		proxy := s.SetupProxy(&model.Proxy{Metadata: &model.NodeMetadata{ClusterID: "cluster1"}})
+		got := xdstest.ExtractLoadAssignments(s.Endpoints(proxy))[clusterName]
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("expected endpoints %v for %s, got %v", want, clusterName, got)
+		}
+		return nil
+	}, retry.Timeout(5*time.Second))
+}
+
+func TestBuildEnvoyLbEndpointALPNHints(t *testing.T) {
+	ep := buildEnvoyLbEndpoint(&model.IstioEndpoint{
+		Address:      "10.0.0.1",
+		EndpointPort: 8080,
+		TLSMode:      model.IstioMutualTLSModeLabel,
+		ALPNHints:    []string{"h2"},
+	})
+	values := ep.GetMetadata().GetFilterMetadata()[util.IstioMetadataKey].GetFields()["alpn"].GetListValue().GetValues()
+	if len(values) != 1 || values[0].GetStringValue() != "h2" {
+		t.Fatalf("expected the ALPN hints [h2] in the endpoint metadata, got %v", ep.GetMetadata())
+	}
+
+	ep = buildEnvoyLbEndpoint(&model.IstioEndpoint{
+		Address:      "10.0.0.1",
+		EndpointPort: 8080,
+		TLSMode:      model.IstioMutualTLSModeLabel,
+	})
+	if alpn, ok := ep.GetMetadata().GetFilterMetadata()[util.IstioMetadataKey].GetFields()["alpn"]; ok {
+		t.Fatalf("expected no ALPN hints in the endpoint metadata, got %v", alpn)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// InterceptorOption configures the interceptors of this package.
+type InterceptorOption func(*interceptorOptions)
+
+type interceptorOptions struct {
+	suppressedCodes map[codes.Code]bool
+}
+
+// WithSuppressedDetails strips the details of the errors with the given codes before they are sent
+// to clients, which prevents leaking details for codes such as Internal. The errors returned by the
+// handlers are unchanged, so the details remain available server-side.
+func WithSuppressedDetails(c ...codes.Code) InterceptorOption {
+	return func(o *interceptorOptions) {
+		for _, code := range c {
+			o.suppressedCodes[code] = true
+		}
+	}
+}
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor that normalizes the errors returned by
+// handlers to errors of this package. Errors that are already from this package are returned as is, so
+// their gogo details are preserved, unless the details of their code are suppressed (see
+// WithSuppressedDetails). Other errors are converted with Convert.
+func UnaryServerInterceptor(opts ...InterceptorOption) grpc.UnaryServerInterceptor {
+	o := &interceptorOptions{suppressedCodes: make(map[codes.Code]bool)}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		s := Convert(err)
+		if o.suppressedCodes[s.Code()] {
+			return resp, New(s.Code(), s.Message()).Err()
+		}
+		if _, ok := err.(*statusError); ok {
+			return resp, err
+		}
+		return resp, s.Err()
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	rpc "istio.io/gogo-genproto/googleapis/google/rpc"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	intercept := func(t *testing.T, handlerErr error) error {
+		t.Helper()
+		handler := func(context.Context, interface{}) (interface{}, error) {
+			return nil, handlerErr
+		}
+		_, err := UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test/Method"}, handler)
+		return err
+	}
+
+	t.Run("gogo status", func(t *testing.T) {
+		err := intercept(t, newStatusWithRetryInfo(t, codes.Unavailable, "unavailable").Err())
+		s := Convert(err)
+		if s.Code() != codes.Unavailable || s.Message() != "unavailable" {
+			t.Fatalf("unexpected status %v", s.Proto())
+		}
+		details := s.Details()
+		if len(details) != 1 {
+			t.Fatalf("expected 1 detail, got %v", details)
+		}
+		if ri, ok := details[0].(*rpc.RetryInfo); !ok || ri.GetRetryDelay().GetSeconds() != 1 {
+			t.Fatalf("unexpected detail %v", details[0])
+		}
+		if got := wireStatus(t, err).Details(); len(got) != 1 {
+			t.Fatalf("expected 1 detail on the wire, got %v", got)
+		}
+	})
+
+	t.Run("grpc status", func(t *testing.T) {
+		err := intercept(t, grpcstatus.Error(codes.NotFound, "not found"))
+		if _, ok := err.(*statusError); !ok {
+			t.Fatalf("expected a status error, got %T", err)
+		}
+		if Code(err) != codes.NotFound {
+			t.Fatalf("expected code %v, got %v", codes.NotFound, Code(err))
+		}
+	})
+
+	t.Run("plain error", func(t *testing.T) {
+		err := intercept(t, errors.New("boom"))
+		s := Convert(err)
+		if s.Code() != codes.Unknown || s.Message() != "boom" {
+			t.Fatalf("unexpected status %v", s.Proto())
+		}
+	})
+
+	t.Run("suppressed details", func(t *testing.T) {
+		handlerErr := newStatusWithRetryInfo(t, codes.Internal, "internal").Err()
+		handler := func(context.Context, interface{}) (interface{}, error) {
+			return nil, handlerErr
+		}
+		interceptor := UnaryServerInterceptor(WithSuppressedDetails(codes.Internal))
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test/Method"}, handler)
+		if Code(err) != codes.Internal || Convert(err).Message() != "internal" {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got := wireStatus(t, err).Details(); len(got) != 0 {
+			t.Fatalf("expected no details on the wire, got %v", got)
+		}
+
+		// The details remain available server-side.
+		if got := Convert(handlerErr).Details(); len(got) != 1 {
+			t.Fatalf("expected 1 server-side detail, got %v", got)
+		}
+		if got := wireStatus(t, handlerErr).Details(); len(got) != 1 {
+			t.Fatalf("expected 1 detail on the handler error, got %v", got)
+		}
+
+		// The details of the other codes are sent.
+		handlerErr = newStatusWithRetryInfo(t, codes.Unavailable, "unavailable").Err()
+		_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test/Method"}, handler)
+		if got := wireStatus(t, err).Details(); len(got) != 1 {
+			t.Fatalf("expected 1 detail on the wire, got %v", got)
+		}
+	})
+
+	t.Run("no error", func(t *testing.T) {
+		if err := intercept(t, nil); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics records the distribution of the status codes returned by gRPC servers.
+package metrics
+
+import (
+	"istio.io/istio/pkg/mcp/status"
+	"istio.io/pkg/monitoring"
+)
+
+// codeTag holds the name of the status code, e.g. NotFound.
+var codeTag = monitoring.MustCreateLabel("code")
+
+var statusCodes = monitoring.NewSum(
+	"grpc_status_codes_total",
+	"Total number of statuses returned, by status code.",
+	monitoring.WithLabels(codeTag),
+)
+
+func init() {
+	monitoring.MustRegister(statusCodes)
+}
+
+// RecordCode increments the counter for the status code of err, as returned by status.Code. A nil error is
+// recorded as OK.
+func RecordCode(err error) {
+	statusCodes.With(codeTag.Value(status.Code(err).String())).Increment()
+}
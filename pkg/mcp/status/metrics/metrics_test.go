@@ -0,0 +1,65 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"errors"
+	"testing"
+
+	"go.opencensus.io/stats/view"
+	"google.golang.org/grpc/codes"
+
+	"istio.io/istio/pkg/mcp/status"
+)
+
+// recordedCodes returns the counts of the recorded status codes, by code name.
+func recordedCodes(t *testing.T) map[string]float64 {
+	t.Helper()
+	rows, err := view.RetrieveData("grpc_status_codes_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]float64)
+	for _, row := range rows {
+		for _, tag := range row.Tags {
+			if tag.Key.Name() == "code" {
+				out[tag.Value] = row.Data.(*view.SumData).Value
+			}
+		}
+	}
+	return out
+}
+
+func TestRecordCode(t *testing.T) {
+	before := recordedCodes(t)
+
+	RecordCode(nil)
+	RecordCode(nil)
+	RecordCode(status.Error(codes.NotFound, "not found"))
+	RecordCode(status.Error(codes.Internal, "internal"))
+	RecordCode(errors.New("not a status"))
+
+	after := recordedCodes(t)
+	for code, want := range map[string]float64{
+		codes.OK.String():       2,
+		codes.NotFound.String(): 1,
+		codes.Internal.String(): 1,
+		codes.Unknown.String():  1,
+	} {
+		if got := after[code] - before[code]; got != want {
+			t.Errorf("code %s: recorded %v, want %v", code, got, want)
+		}
+	}
+}
@@ -28,31 +28,83 @@
 package status
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/gogo/protobuf/jsonpb"
 	"github.com/gogo/protobuf/proto"
 	"github.com/gogo/protobuf/types"
 	spb "google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	any "google.golang.org/protobuf/types/known/anypb"
 
 	rpc "istio.io/gogo-genproto/googleapis/google/rpc"
 )
 
-// statusError is an alias of a status proto.  It implements error and Status,
+// statusError wraps a status proto.  It implements error and Status,
 // and a nil statusError should never be returned by this package.
-type statusError rpc.Status
+type statusError struct {
+	p *rpc.Status
+
+	// converted caches the conversion of p to a grpc/status with its details, as GRPCStatus is called on hot
+	// error paths. p is never modified, so the conversion is computed at most once.
+	convertOnce sync.Once
+	converted   *status.Status
+}
+
+func newStatusError(p *rpc.Status) *statusError {
+	return &statusError{p: p}
+}
 
 func (se *statusError) Error() string {
-	p := (*rpc.Status)(se)
-	return fmt.Sprintf("rpc error: code = %s desc = %s", codes.Code(p.GetCode()), p.GetMessage())
+	return fmt.Sprintf("rpc error: code = %s desc = %s", codes.Code(se.p.GetCode()), se.p.GetMessage())
 }
 
-// GRPCStatus converts the gogo/statusError to a grpc/status.
+// GRPCStatus converts the gogo/statusError to a grpc/status. The conversion with the details is cached, and
+// shared by the calls, which is safe since a grpc/status is immutable.
 func (se *statusError) GRPCStatus() *status.Status {
-	p := (*rpc.Status)(se)
+	se.convertOnce.Do(func() {
+		se.converted = status.FromProto(toSPB(se.p))
+	})
+	return se.converted
+}
+
+// Is returns true if target is or wraps a status error with the same code as se (see Status.Is).
+func (se *statusError) Is(target error) bool {
+	return (&Status{s: se.p}).Is(target)
+}
+
+// grpcStatus is implemented by the errors of this package and of the standard grpc/status package.
+type grpcStatus interface {
+	GRPCStatus() *status.Status
+}
+
+// wrappingStatusError is the error of a status converted by FromError from an error wrapping a status error,
+// e.g. with fmt.Errorf("...: %w", err). It unwraps to the converted error, so that errors.Is and errors.As
+// still find the errors in its chain.
+type wrappingStatusError struct {
+	*statusError
+	wrapped error
+}
+
+func (e *wrappingStatusError) Unwrap() error {
+	return e.wrapped
+}
+
+// toSPB converts the gogo rpc.Status to the canonical protobuf status.
+func toSPB(p *rpc.Status) *spb.Status {
 	s := &spb.Status{
 		Code:    p.GetCode(),
 		Message: p.GetMessage(),
@@ -63,13 +115,21 @@ func (se *statusError) GRPCStatus() *status.Status {
 			Value:   detail.GetValue(),
 		})
 	}
-	return status.FromProto(s)
+	return s
 }
 
 // Status represents an RPC status code, message, and details.  It is immutable
 // and should be created with New, Newf, or FromProto.
 type Status struct {
 	s *rpc.Status
+
+	// wrapped is the error s was converted from by FromError, if it wrapped a status error.
+	wrapped error
+
+	// err is the error returned by Err. It is only created once, so that the errors of a Status compare equal and
+	// share the cached conversion to a grpc/status.
+	errOnce sync.Once
+	err     error
 }
 
 // Code returns the status code contained in s.
@@ -88,6 +148,27 @@ func (s *Status) Message() string {
 	return s.s.Message
 }
 
+// String returns the code name of s, followed by its message and the number of its details if any, e.g.
+// "OK" or "NotFound: service missing [2 details]". A nil status is formatted as OK.
+func (s *Status) String() string {
+	var b strings.Builder
+	b.WriteString(s.Code().String())
+	if msg := s.Message(); msg != "" {
+		b.WriteString(": ")
+		b.WriteString(msg)
+	}
+	if s != nil && s.s != nil {
+		switch n := len(s.s.Details); n {
+		case 0:
+		case 1:
+			b.WriteString(" [1 detail]")
+		default:
+			fmt.Fprintf(&b, " [%d details]", n)
+		}
+	}
+	return b.String()
+}
+
 // Proto returns s's status as an rpc.Status proto message.
 func (s *Status) Proto() *rpc.Status {
 	if s == nil {
@@ -96,13 +177,314 @@ func (s *Status) Proto() *rpc.Status {
 	return proto.Clone(s.s).(*rpc.Status)
 }
 
+// ToSPB returns s's status as a canonical (non-gogo) protobuf status message,
+// for use at boundaries with code built on google.golang.org/genproto.
+func (s *Status) ToSPB() *spb.Status {
+	if s == nil {
+		return nil
+	}
+	return toSPB(s.s)
+}
+
+// Hash returns a hash of s's code, message, and details, for use as a deduplication key. The
+// hash does not depend on the order of the details, and is stable across processes. Like Equal,
+// it hashes the deterministic serialization of the details, so that e.g. the order of map
+// entries doesn't matter. An OK status is only hashed by code, as it is only compared by code.
+func (s *Status) Hash() uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.BigEndian.PutUint32(buf[:4], uint32(s.Code()))
+	_, _ = h.Write(buf[:4])
+	if s.Code() == codes.OK {
+		return h.Sum64()
+	}
+	_, _ = h.Write([]byte(s.Message()))
+
+	// Combine the hashes of the details with a commutative operation, so their order doesn't matter.
+	var details uint64
+	if s != nil && s.s != nil {
+		for _, detail := range s.s.Details {
+			dh := fnv.New64a()
+			_, _ = dh.Write([]byte(detail.GetTypeUrl()))
+			_, _ = dh.Write([]byte{0})
+			_, _ = dh.Write(deterministicValue(detail))
+			details += dh.Sum64()
+		}
+	}
+	binary.BigEndian.PutUint64(buf[:], details)
+	_, _ = h.Write(buf[:])
+	return h.Sum64()
+}
+
+// Equal returns true if s and other have the same code, message, and details. The details are compared in
+// order, by type URL and deterministic serialization, so that e.g. the order of map entries doesn't matter.
+// A nil or OK status is only compared by code, so that it is equal to any OK status regardless of its message.
+func (s *Status) Equal(other *Status) bool {
+	if s == nil || other == nil || s.Code() == codes.OK || other.Code() == codes.OK {
+		return s.Code() == other.Code()
+	}
+	if s.Code() != other.Code() || s.Message() != other.Message() {
+		return false
+	}
+	var details, otherDetails []*types.Any
+	if s != nil && s.s != nil {
+		details = s.s.Details
+	}
+	if other != nil && other.s != nil {
+		otherDetails = other.s.Details
+	}
+	if len(details) != len(otherDetails) {
+		return false
+	}
+	for i := range details {
+		if details[i].GetTypeUrl() != otherDetails[i].GetTypeUrl() ||
+			!bytes.Equal(deterministicValue(details[i]), deterministicValue(otherDetails[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// deterministicValue returns the deterministic serialization of the message held by detail, or its value as is if
+// the message cannot be decoded.
+func deterministicValue(detail *types.Any) []byte {
+	msg := &types.DynamicAny{}
+	if err := types.UnmarshalAny(detail, msg); err != nil {
+		return detail.GetValue()
+	}
+	b := proto.NewBuffer(nil)
+	b.SetDeterministic(true)
+	if err := b.Marshal(msg.Message); err != nil {
+		return detail.GetValue()
+	}
+	return b.Bytes()
+}
+
+// Is returns true if target is or wraps a status error with the code of s, so that errors.Is matches the errors
+// of this package by code.
+func (s *Status) Is(target error) bool {
+	ts, ok := FromError(target)
+	return ok && ts.Code() == s.Code()
+}
+
+// statusJSON is the JSON representation of a Status, see MarshalJSON.
+type statusJSON struct {
+	Code     int32        `json:"code"`
+	CodeName string       `json:"code_name"`
+	Message  string       `json:"message,omitempty"`
+	Details  []detailJSON `json:"details,omitempty"`
+}
+
+// detailJSON is the JSON representation of a detail of a Status: either the JSON of the decoded message, or the
+// serialized message in base64 if it cannot be decoded.
+type detailJSON struct {
+	TypeURL     string          `json:"type_url"`
+	Value       json.RawMessage `json:"value,omitempty"`
+	ValueBase64 []byte          `json:"value_base64,omitempty"`
+}
+
+// MarshalJSON returns s as a JSON object, e.g. for structured logging. The object holds the code, both as a number
+// and as the name of the code, the message, and the details. A detail is rendered as its type URL and the JSON of
+// the decoded message, or the serialized message in base64 if it cannot be decoded.
+func (s *Status) MarshalJSON() ([]byte, error) {
+	out := statusJSON{
+		Code:     int32(s.Code()),
+		CodeName: s.Code().String(),
+		Message:  s.Message(),
+	}
+	if s != nil && s.s != nil {
+		for _, detail := range s.s.Details {
+			d := detailJSON{TypeURL: detail.GetTypeUrl()}
+			msg := &types.DynamicAny{}
+			if err := types.UnmarshalAny(detail, msg); err == nil {
+				if value, err := (&jsonpb.Marshaler{OrigName: true}).MarshalToString(msg.Message); err == nil {
+					d.Value = json.RawMessage(value)
+				}
+			}
+			if d.Value == nil {
+				d.ValueBase64 = detail.GetValue()
+			}
+			out.Details = append(out.Details, d)
+		}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON sets s to the status represented by the JSON object produced by MarshalJSON. The code is read from
+// its number. Since a Status is immutable, s must be a new, zero Status, e.g. &Status{}; an error is returned for a
+// Status that is already set, including the statuses returned by this package.
+func (s *Status) UnmarshalJSON(data []byte) error {
+	if s == okStatus || s.s != nil || s.wrapped != nil {
+		return errors.New("cannot unmarshal into a Status that is already set")
+	}
+	var in statusJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	p := &rpc.Status{Code: in.Code, Message: in.Message}
+	for _, d := range in.Details {
+		detail := &types.Any{TypeUrl: d.TypeURL, Value: d.ValueBase64}
+		if d.Value != nil {
+			msg, err := types.EmptyAny(detail)
+			if err != nil {
+				return err
+			}
+			if err := jsonpb.UnmarshalString(string(d.Value), msg); err != nil {
+				return err
+			}
+			if detail.Value, err = proto.Marshal(msg); err != nil {
+				return err
+			}
+		}
+		p.Details = append(p.Details, detail)
+	}
+	s.s = p
+	s.wrapped = nil
+	return nil
+}
+
+// Kinds of status returned by Status.Kind.
+const (
+	KindOK     = "ok"
+	KindClient = "client"
+	KindServer = "server"
+)
+
+// Kind classifies s by whether its code indicates a client error or a server error, for use as a
+// metrics label. The classification follows the HTTP mapping of the codes: codes mapped to a 4xx
+// status are client errors, and codes mapped to a 5xx status are server errors.
+func (s *Status) Kind() string {
+	switch s.Code() {
+	case codes.OK:
+		return KindOK
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.ResourceExhausted, codes.FailedPrecondition, codes.Aborted, codes.OutOfRange, codes.Unauthenticated:
+		return KindClient
+	default:
+		// Unknown, DeadlineExceeded, Unimplemented, Internal, Unavailable, DataLoss and any unrecognized codes.
+		return KindServer
+	}
+}
+
+// httpStatuses maps the codes to HTTP status codes, as gRPC gateways do. Unrecognized codes map to 500.
+var httpStatuses = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499, // Client Closed Request
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+func httpStatus(c codes.Code) int {
+	if httpCode, ok := httpStatuses[c]; ok {
+		return httpCode
+	}
+	return http.StatusInternalServerError
+}
+
+// HTTPStatusCode returns the HTTP status code corresponding to the code of s, e.g. 404 for NotFound and 503 for
+// Unavailable, for gateways translating statuses into HTTP responses. Unrecognized codes map to 500.
+func (s *Status) HTTPStatusCode() int {
+	return httpStatus(s.Code())
+}
+
+// ProblemTypePrefix starts the type of the problem documents produced by ToProblemJSON, which ends with the name of
+// the code, e.g. "urn:grpc:status:NotFound".
+const ProblemTypePrefix = "urn:grpc:status:"
+
+// problem is an RFC 7807 problem details document.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// ToProblemJSON returns s as an RFC 7807 problem details document (application/problem+json), for the HTTP error
+// responses of REST gateways. The type and title are derived from the code, the status is the HTTP status code
+// the code maps to, and the detail is the message of s. instance identifies the occurrence of the problem, e.g.
+// the request path, and is omitted if empty.
+func (s *Status) ToProblemJSON(instance string) []byte {
+	c := s.Code()
+	out, _ := json.Marshal(problem{
+		Type:     ProblemTypePrefix + c.String(),
+		Title:    c.String(),
+		Status:   httpStatus(c),
+		Detail:   s.Message(),
+		Instance: instance,
+	})
+	return out
+}
+
+// severities ranks the codes by severity, from OK to DataLoss. Codes of the same rank are equally severe:
+//  0. OK
+//  1. Canceled, NotFound, AlreadyExists: expected outcomes of a request.
+//  2. InvalidArgument, FailedPrecondition, Aborted, OutOfRange: invalid requests.
+//  3. Unauthenticated, PermissionDenied, ResourceExhausted: requests denied by policy or quota.
+//  4. DeadlineExceeded, Unavailable: transient server errors, which may be retried.
+//  5. Unknown, Unimplemented: server errors, including any unrecognized code.
+//  6. Internal: broken invariants of the server.
+//  7. DataLoss: unrecoverable data loss or corruption.
+var severities = map[codes.Code]int{
+	codes.OK:                 0,
+	codes.Canceled:           1,
+	codes.NotFound:           1,
+	codes.AlreadyExists:      1,
+	codes.InvalidArgument:    2,
+	codes.FailedPrecondition: 2,
+	codes.Aborted:            2,
+	codes.OutOfRange:         2,
+	codes.Unauthenticated:    3,
+	codes.PermissionDenied:   3,
+	codes.ResourceExhausted:  3,
+	codes.DeadlineExceeded:   4,
+	codes.Unavailable:        4,
+	codes.Unknown:            5,
+	codes.Unimplemented:      5,
+	codes.Internal:           6,
+	codes.DataLoss:           7,
+}
+
+func severity(c codes.Code) int {
+	if rank, ok := severities[c]; ok {
+		return rank
+	}
+	return severities[codes.Unknown]
+}
+
+// SeverityAtLeast returns true if the code of s is at least as severe as min, according to the ranking of
+// severities, e.g. to only alert on statuses of a given severity and above.
+func (s *Status) SeverityAtLeast(min codes.Code) bool {
+	return severity(s.Code()) >= severity(min)
+}
+
 // Err returns an immutable error representing s; returns nil if s.Code() is
-// OK.
+// OK. Every call returns the same error, so the errors of s compare equal.
 func (s *Status) Err() error {
 	if s.Code() == codes.OK {
 		return nil
 	}
-	return (*statusError)(s.s)
+	s.errOnce.Do(func() {
+		if s.wrapped != nil {
+			s.err = &wrappingStatusError{statusError: newStatusError(s.s), wrapped: s.wrapped}
+			return
+		}
+		s.err = newStatusError(s.s)
+	})
+	return s.err
 }
 
 // New returns a Status representing c and msg.
@@ -135,19 +517,59 @@ func FromProto(s *rpc.Status) *Status {
 	return &Status{s: proto.Clone(s).(*rpc.Status)}
 }
 
+// okStatus is returned by FromError for a nil error, so that the common path doesn't allocate. It is shared,
+// which is safe since a Status is immutable: its proto is only handed out as a clone.
+var okStatus = &Status{s: &rpc.Status{Code: int32(codes.OK)}}
+
 // FromError returns a Status representing err if it was produced from this
-// package or the standard grpc/status package. Otherwise, ok is false and
-// a Status is returned with codes.Unknown and the original error message.
+// package or the standard grpc/status package, or if it wraps such an error.
+// In the latter case, the Status has the code and details of the wrapped
+// error and the message of err, and its Err unwraps to err. Otherwise, ok is
+// false and a Status is returned with the original error message and, as Code
+// does, codes.Canceled or codes.DeadlineExceeded if err is or wraps the
+// corresponding context error, or codes.Unknown.
 func FromError(err error) (s *Status, ok bool) {
 	if err == nil {
-		return &Status{s: &rpc.Status{Code: int32(codes.OK)}}, true
+		return okStatus, true
 	}
-	if se, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
-		return FromGRPCStatus(se.GRPCStatus()), true
+	var gs grpcStatus
+	if errors.As(err, &gs) {
+		s := FromGRPCStatus(gs.GRPCStatus())
+		if _, direct := err.(grpcStatus); !direct {
+			s.s.Message = err.Error()
+			s.wrapped = err
+		}
+		return s, true
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(codes.DeadlineExceeded, err.Error()), false
+	case errors.Is(err, context.Canceled):
+		return New(codes.Canceled, err.Error()), false
 	}
 	return New(codes.Unknown, err.Error()), false
 }
 
+// FromErrorWith returns a Status representing err as translated by mapper, so that callers can map their own
+// errors, e.g. sentinel errors, to codes. If mapper is nil or doesn't handle err, i.e. it returns false or a nil
+// Status, the Status is returned as by Convert. mapper isn't consulted for a nil err.
+func FromErrorWith(err error, mapper func(error) (*Status, bool)) *Status {
+	if err != nil && mapper != nil {
+		if s, ok := mapper(err); ok && s != nil {
+			return s
+		}
+	}
+	return Convert(err)
+}
+
+// FromContextError converts a context error, or an error wrapping one, into a Status: context.Canceled
+// maps to codes.Canceled and context.DeadlineExceeded to codes.DeadlineExceeded, keeping the message of
+// err. It returns an OK status for a nil err, and converts other errors as Convert does, which maps the
+// context errors the same way.
+func FromContextError(err error) *Status {
+	return Convert(err)
+}
+
 // FromGRPCStatus converts a grpc.Status to gogo.Status.
 func FromGRPCStatus(st *status.Status) *Status {
 	p := st.Proto()
@@ -189,6 +611,107 @@ func (s *Status) WithDetails(details ...proto.Message) (*Status, error) {
 	return &Status{s: p}, nil
 }
 
+// ErrDetailsTruncated is returned by WithDetailsCapped when some of the details were dropped.
+var ErrDetailsTruncated = errors.New("status details truncated")
+
+// WithDetailsCapped returns a new status with the provided details messages appended to the status until it holds
+// max details, e.g. to bound the details of a status accumulated by an aggregator. If details were dropped, the
+// capped status is returned along with an error wrapping ErrDetailsTruncated. As with WithDetails, it returns nil
+// and an error if the code of s is OK, or the first error encountered marshaling the details.
+func (s *Status) WithDetailsCapped(max int, details ...proto.Message) (*Status, error) {
+	if s.Code() == codes.OK {
+		return nil, errors.New("no error details for status with code OK")
+	}
+	p := s.Proto()
+	room := max - len(p.Details)
+	if room < 0 {
+		room = 0
+	}
+	kept := details
+	if len(kept) > room {
+		kept = kept[:room]
+	}
+	for _, detail := range kept {
+		body, err := types.MarshalAny(detail)
+		if err != nil {
+			return nil, err
+		}
+		p.Details = append(p.Details, body)
+	}
+	if dropped := len(details) - len(kept); dropped > 0 {
+		return &Status{s: p}, fmt.Errorf("%w: dropped %d of %d details beyond the maximum of %d",
+			ErrDetailsTruncated, dropped, len(details), max)
+	}
+	return &Status{s: p}, nil
+}
+
+// WithDetailsV2 returns a new status with the provided details messages of the google.golang.org/protobuf runtime
+// appended to the status. They are marshaled with that runtime and stored as the other details, so they can be
+// read with Details as well as DetailsV2. As with WithDetails, an error is returned if the code of s is OK, or the
+// first error encountered marshaling the details.
+func (s *Status) WithDetailsV2(details ...protoreflect.ProtoMessage) (*Status, error) {
+	if s.Code() == codes.OK {
+		return nil, errors.New("no error details for status with code OK")
+	}
+	p := s.Proto()
+	for _, detail := range details {
+		body, err := any.New(detail)
+		if err != nil {
+			return nil, err
+		}
+		p.Details = append(p.Details, &types.Any{
+			TypeUrl: body.GetTypeUrl(),
+			Value:   body.GetValue(),
+		})
+	}
+	return &Status{s: p}, nil
+}
+
+// WithDetailsAny returns a new status with the provided details appended to the status as they are, e.g. details
+// received off the wire, without unmarshaling and marshaling them again. As with WithDetails, an error is
+// returned if the code of s is OK.
+func (s *Status) WithDetailsAny(details ...*types.Any) (*Status, error) {
+	if s.Code() == codes.OK {
+		return nil, errors.New("no error details for status with code OK")
+	}
+	p := s.Proto()
+	for _, detail := range details {
+		p.Details = append(p.Details, proto.Clone(detail).(*types.Any))
+	}
+	return &Status{s: p}, nil
+}
+
+// WithoutDetail returns a new status with all of the details of the given type URL removed, e.g.
+// "type.googleapis.com/google.rpc.DebugInfo". The other details are kept in order.
+func (s *Status) WithoutDetail(typeURL string) *Status {
+	return s.WithoutDetails(typeURL)
+}
+
+// WithoutDetails returns a new status with all of the details of any of the given type URLs removed, e.g. to
+// redact internal details before returning the status to external clients. Type URLs are matched exactly. The
+// code, the message and the other details are kept, in order.
+func (s *Status) WithoutDetails(typeURLs ...string) *Status {
+	p := s.Proto()
+	if p == nil || len(p.Details) == 0 {
+		return &Status{s: p}
+	}
+	removed := make(map[string]bool, len(typeURLs))
+	for _, typeURL := range typeURLs {
+		removed[typeURL] = true
+	}
+	details := p.Details[:0]
+	for _, detail := range p.Details {
+		if !removed[detail.GetTypeUrl()] {
+			details = append(details, detail)
+		}
+	}
+	if len(details) == 0 {
+		details = nil
+	}
+	p.Details = details
+	return &Status{s: p}
+}
+
 // Details returns a slice of details messages attached to the status.
 // If a detail cannot be decoded, the error is returned in place of the detail.
 func (s *Status) Details() []interface{} {
@@ -207,15 +730,330 @@ func (s *Status) Details() []interface{} {
 	return details
 }
 
-// Code returns the Code of the error if it is a Status error, codes.OK if err
-// is nil, or codes.Unknown otherwise.
+// DetailsV2 returns the details messages attached to the status, decoded with the google.golang.org/protobuf
+// runtime, e.g. those appended by WithDetailsV2. If a detail cannot be decoded, the error is returned in place of
+// the detail.
+func (s *Status) DetailsV2() []interface{} {
+	if s == nil || s.s == nil {
+		return nil
+	}
+	details := make([]interface{}, 0, len(s.s.Details))
+	for _, body := range s.s.Details {
+		detail, err := (&any.Any{TypeUrl: body.GetTypeUrl(), Value: body.GetValue()}).UnmarshalNew()
+		if err != nil {
+			details = append(details, err)
+			continue
+		}
+		details = append(details, detail)
+	}
+	return details
+}
+
+// MessageSeparator joins the messages of the statuses combined by Merge into the message of the merged status.
+// The messages are also recorded individually (see mergedDetail), so that Decompose recovers them even if they
+// contain MessageSeparator.
+const MessageSeparator = "; "
+
+// mergedDetail is the Detail of the DebugInfo recorded by Merge, whose stack entries hold the messages of the
+// merged statuses. It distinguishes the DebugInfo from other DebugInfo details, e.g. the one of WithCause.
+const mergedDetail = "merged statuses"
+
+// mergedMessages returns the messages of the merged statuses recorded by Merge in detail. ok is false if detail
+// isn't the DebugInfo recorded by Merge.
+func mergedMessages(detail *types.Any) (messages []string, ok bool) {
+	info := &rpc.DebugInfo{}
+	if !types.Is(detail, info) || types.UnmarshalAny(detail, info) != nil || info.GetDetail() != mergedDetail {
+		return nil, false
+	}
+	return info.GetStackEntries(), true
+}
+
+// Decompose separates a status combined from several statuses by Merge back into its components: the code, the
+// messages of the merged statuses and the decoded details. The message of a status that wasn't merged is its only
+// component. The detail recording the merged messages, and details that cannot be decoded, are skipped. A nil
+// status decomposes into codes.OK and no messages.
+func (s *Status) Decompose() (code codes.Code, messages []string, details []proto.Message) {
+	code = s.Code()
+	merged := false
+	for i, detail := range s.Details() {
+		if components, ok := mergedMessages(s.s.Details[i]); ok && !merged {
+			messages, merged = components, true
+			continue
+		}
+		if m, ok := detail.(proto.Message); ok {
+			details = append(details, m)
+		}
+	}
+	if msg := s.Message(); !merged && msg != "" {
+		messages = []string{msg}
+	}
+	return code, messages, details
+}
+
+// Merge combines the given statuses into a single status, e.g. the statuses of several resource pushes. It
+// returns an OK status if all of the statuses are OK or nil. Otherwise the code is the most severe of the non-OK
+// codes, according to the ranking of severities, preferring the first of equally severe codes. The messages of
+// the non-OK statuses, as returned by Decompose, are joined with MessageSeparator and recorded in a DebugInfo
+// detail, and the other details are the union of their details, in order.
+func Merge(statuses ...*Status) *Status {
+	var (
+		code     = codes.OK
+		messages []string
+		details  []*types.Any
+		seen     = make(map[string]bool)
+	)
+	for _, s := range statuses {
+		c := s.Code()
+		if c == codes.OK {
+			continue
+		}
+		if code == codes.OK || severity(c) > severity(code) {
+			code = c
+		}
+		merged := false
+		for _, detail := range s.s.Details {
+			if components, ok := mergedMessages(detail); ok && !merged {
+				// The status was merged itself, so its components are merged rather than its joined message.
+				messages, merged = append(messages, components...), true
+				continue
+			}
+			key := detail.GetTypeUrl() + "/" + string(deterministicValue(detail))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			details = append(details, proto.Clone(detail).(*types.Any))
+		}
+		if msg := s.Message(); !merged && msg != "" {
+			messages = append(messages, msg)
+		}
+	}
+	if code == codes.OK {
+		return okStatus
+	}
+	components, err := types.MarshalAny(&rpc.DebugInfo{Detail: mergedDetail, StackEntries: messages})
+	if err == nil {
+		details = append(details, components)
+	}
+	return &Status{s: &rpc.Status{
+		Code:    int32(code),
+		Message: strings.Join(messages, MessageSeparator),
+		Details: details,
+	}}
+}
+
+// DisplayMessage returns the user-facing message of s for the given locale, e.g. "en-US": the message of the
+// LocalizedMessage detail for the locale if present, otherwise the message of s. Locales are compared
+// case-insensitively.
+func (s *Status) DisplayMessage(locale string) string {
+	for _, detail := range s.Details() {
+		if lm, isLocalizedMessage := detail.(*rpc.LocalizedMessage); isLocalizedMessage && strings.EqualFold(lm.GetLocale(), locale) {
+			return lm.GetMessage()
+		}
+	}
+	return s.Message()
+}
+
+// causeDetailPrefix starts the Detail of the DebugInfo recorded by WithCause, distinguishing it from
+// other DebugInfo details.
+const causeDetailPrefix = "cause: "
+
+// Cause describes an underlying error recorded by WithCause.
+type Cause struct {
+	// Message is the message of the error.
+	Message string
+	// Chain holds the type and message of the error and of each error it wraps, outermost first,
+	// formatted as "<type>: <message>".
+	Chain []string
+}
+
+// WithCause returns a new status with a DebugInfo detail recording the message and type of err, and of
+// each error it wraps, so that server-side logs keep the chain that led to the status. s is returned
+// unchanged if err is nil. As with WithDetails, an error is returned if the code of s is OK.
+func (s *Status) WithCause(err error) (*Status, error) {
+	if err == nil {
+		return s, nil
+	}
+	info := &rpc.DebugInfo{Detail: causeDetailPrefix + err.Error()}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		info.StackEntries = append(info.StackEntries, fmt.Sprintf("%T: %v", e, e))
+	}
+	return s.WithDetails(info)
+}
+
+// Cause returns the underlying error recorded by WithCause. ok is false if s has no cause.
+func (s *Status) Cause() (cause Cause, ok bool) {
+	for _, detail := range s.Details() {
+		if info, isDebugInfo := detail.(*rpc.DebugInfo); isDebugInfo && strings.HasPrefix(info.GetDetail(), causeDetailPrefix) {
+			return Cause{
+				Message: strings.TrimPrefix(info.GetDetail(), causeDetailPrefix),
+				Chain:   info.GetStackEntries(),
+			}, true
+		}
+	}
+	return Cause{}, false
+}
+
+// WithErrorInfo returns a new status with an ErrorInfo detail appended, classifying the error by reason
+// (e.g. "RESOURCE_EXHAUSTED"), the domain of the service that generated it (e.g. "mcp.istio.io"), and
+// optional metadata. As with WithDetails, an error is returned if the code of s is OK.
+func (s *Status) WithErrorInfo(reason, domain string, metadata map[string]string) (*Status, error) {
+	return s.WithDetails(&rpc.ErrorInfo{
+		Reason:   reason,
+		Domain:   domain,
+		Metadata: metadata,
+	})
+}
+
+// ErrorInfo returns the first ErrorInfo detail of s, such as the one appended by WithErrorInfo. ok is
+// false if s has no ErrorInfo.
+func (s *Status) ErrorInfo() (info *rpc.ErrorInfo, ok bool) {
+	for _, detail := range s.Details() {
+		if ei, isErrorInfo := detail.(*rpc.ErrorInfo); isErrorInfo {
+			return ei, true
+		}
+	}
+	return nil, false
+}
+
+// RetryInfo returns the first RetryInfo detail of s. ok is false if s has no RetryInfo.
+func (s *Status) RetryInfo() (info *rpc.RetryInfo, ok bool) {
+	for _, detail := range s.Details() {
+		if ri, isRetryInfo := detail.(*rpc.RetryInfo); isRetryInfo {
+			return ri, true
+		}
+	}
+	return nil, false
+}
+
+// BadRequest returns the first BadRequest detail of s. ok is false if s has no BadRequest.
+func (s *Status) BadRequest() (request *rpc.BadRequest, ok bool) {
+	for _, detail := range s.Details() {
+		if br, isBadRequest := detail.(*rpc.BadRequest); isBadRequest {
+			return br, true
+		}
+	}
+	return nil, false
+}
+
+// QuotaFailure returns the first QuotaFailure detail of s. ok is false if s has no QuotaFailure.
+func (s *Status) QuotaFailure() (failure *rpc.QuotaFailure, ok bool) {
+	for _, detail := range s.Details() {
+		if qf, isQuotaFailure := detail.(*rpc.QuotaFailure); isQuotaFailure {
+			return qf, true
+		}
+	}
+	return nil, false
+}
+
+// RetryAfterMetadataKey is the key of the ErrorInfo metadata entry consulted by RetryAfter when the status
+// has no RetryInfo. The value is either a duration (e.g. "1.5s") or a whole number of seconds.
+const RetryAfterMetadataKey = "retry-after"
+
+// RetryAfter returns how long the client should wait before retrying, as advised by the details of s.
+// The RetryInfo detail is read first, falling back to the RetryAfterMetadataKey entry of an ErrorInfo
+// detail. ok is false if neither is present.
+func (s *Status) RetryAfter() (delay time.Duration, ok bool) {
+	details := s.Details()
+	for _, detail := range details {
+		if ri, isRetryInfo := detail.(*rpc.RetryInfo); isRetryInfo && ri.GetRetryDelay() != nil {
+			if delay, err := types.DurationFromProto(ri.GetRetryDelay()); err == nil {
+				return delay, true
+			}
+		}
+	}
+	for _, detail := range details {
+		if ei, isErrorInfo := detail.(*rpc.ErrorInfo); isErrorInfo {
+			if value, found := ei.GetMetadata()[RetryAfterMetadataKey]; found {
+				if delay, ok := parseRetryAfter(value); ok {
+					return delay, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if delay, err := time.ParseDuration(value); err == nil && delay >= 0 {
+		return delay, true
+	}
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
+
+// traceIDPrefix starts the message prefix holding the trace ID attached by WithTraceID.
+const traceIDPrefix = "[trace-id="
+
+// WithTraceID returns a new status with the given correlation or trace ID attached. The ID is stored as
+// a "[trace-id=<id>] " prefix of the message rather than as a detail, so it survives anything that
+// forwards or logs only the code and message, and TraceID reads it back without decoding any details.
+// Any previously attached ID is replaced. s is returned unchanged if its code is OK, or if id is empty
+// or contains "]".
+func (s *Status) WithTraceID(id string) *Status {
+	if s.Code() == codes.OK || id == "" || strings.Contains(id, "]") {
+		return s
+	}
+	p := s.Proto()
+	_, msg, _ := splitTraceID(p.Message)
+	p.Message = traceIDPrefix + id + "] " + msg
+	return &Status{s: p}
+}
+
+// TraceID returns the trace ID attached to s by WithTraceID. ok is false if s has no trace ID.
+func (s *Status) TraceID() (id string, ok bool) {
+	id, _, ok = splitTraceID(s.Message())
+	return id, ok
+}
+
+// splitTraceID splits a message into the trace ID of its prefix, if any, and the rest of the message.
+func splitTraceID(msg string) (id, rest string, ok bool) {
+	if !strings.HasPrefix(msg, traceIDPrefix) {
+		return "", msg, false
+	}
+	end := strings.Index(msg, "] ")
+	if end < 0 {
+		return "", msg, false
+	}
+	return msg[len(traceIDPrefix):end], msg[end+len("] "):], true
+}
+
+// Code returns the Code of the error if it is or wraps a Status error,
+// codes.Canceled or codes.DeadlineExceeded if it is or wraps the corresponding
+// context error, codes.OK if err is nil, or codes.Unknown otherwise. A Status
+// error takes precedence over a context error it wraps.
 func Code(err error) codes.Code {
 	// Don't use FromError to avoid allocation of OK status.
 	if err == nil {
 		return codes.OK
 	}
-	if se, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
-		return se.GRPCStatus().Code()
+	var gs grpcStatus
+	switch {
+	case errors.As(err, &gs):
+		return gs.GRPCStatus().Code()
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
 	}
 	return codes.Unknown
 }
+
+// BothStatuses returns true if both a and b are Status errors, i.e. errors
+// implementing GRPCStatus or wrapping such an error. A nil error is not a
+// Status error.
+func BothStatuses(a, b error) bool {
+	return isStatus(a) && isStatus(b)
+}
+
+// AnyStatus returns true if at least one of a and b is a Status error.
+func AnyStatus(a, b error) bool {
+	return isStatus(a) || isStatus(b)
+}
+
+func isStatus(err error) bool {
+	var gs grpcStatus
+	return errors.As(err, &gs)
+}
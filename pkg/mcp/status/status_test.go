@@ -0,0 +1,1224 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	rpc "istio.io/gogo-genproto/googleapis/google/rpc"
+)
+
+func newStatusWithRetryInfo(t *testing.T, c codes.Code, msg string) *Status {
+	t.Helper()
+	s, err := New(c, msg).WithDetails(&rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func wireStatus(t *testing.T, err error) *status.Status {
+	t.Helper()
+	se, ok := err.(interface{ GRPCStatus() *status.Status })
+	if !ok {
+		t.Fatalf("error %v does not implement GRPCStatus", err)
+	}
+	return se.GRPCStatus()
+}
+
+func TestGRPCStatusCached(t *testing.T) {
+	s := newStatusWithRetryInfo(t, codes.Unavailable, "unavailable")
+	err := s.Err()
+	if s.Err() != err {
+		t.Fatal("expected the error of the status to be cached")
+	}
+	first := wireStatus(t, err)
+	if second := wireStatus(t, s.Err()); second != first {
+		t.Fatal("expected the conversion to be cached")
+	}
+	if first.Code() != codes.Unavailable || first.Message() != "unavailable" || len(first.Details()) != 1 {
+		t.Fatalf("unexpected conversion: %v", first.Proto())
+	}
+}
+
+func TestToSPB(t *testing.T) {
+	s := newStatusWithRetryInfo(t, codes.Unavailable, "unavailable")
+
+	got := s.ToSPB()
+	if codes.Code(got.GetCode()) != codes.Unavailable {
+		t.Fatalf("expected code %v, got %v", codes.Unavailable, codes.Code(got.GetCode()))
+	}
+	if got.GetMessage() != "unavailable" {
+		t.Fatalf("expected message %q, got %q", "unavailable", got.GetMessage())
+	}
+	if len(got.GetDetails()) != 1 {
+		t.Fatalf("expected 1 detail, got %v", got.GetDetails())
+	}
+	detail := got.GetDetails()[0]
+	if want := "type.googleapis.com/google.rpc.RetryInfo"; detail.GetTypeUrl() != want {
+		t.Fatalf("expected detail type %q, got %q", want, detail.GetTypeUrl())
+	}
+	ri := &rpc.RetryInfo{}
+	if err := proto.Unmarshal(detail.GetValue(), ri); err != nil {
+		t.Fatal(err)
+	}
+	if ri.GetRetryDelay().GetSeconds() != 1 {
+		t.Fatalf("expected retry delay of 1s, got %v", ri.GetRetryDelay())
+	}
+
+	// The conversion must not alias the status.
+	detail.Value = nil
+	if got := s.Details(); len(got) != 1 {
+		t.Fatalf("expected the status to be unchanged, got details %v", got)
+	}
+
+	var nilStatus *Status
+	if got := nilStatus.ToSPB(); got != nil {
+		t.Fatalf("expected nil, got %v", got)
+	}
+}
+
+func TestHash(t *testing.T) {
+	retryInfo := &rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 1}}
+	debugInfo := &rpc.DebugInfo{Detail: "detail"}
+	withDetails := func(c codes.Code, msg string, details ...proto.Message) *Status {
+		s, err := New(c, msg).WithDetails(details...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	base := withDetails(codes.Unavailable, "unavailable", retryInfo, debugInfo)
+	equal := []*Status{
+		withDetails(codes.Unavailable, "unavailable", retryInfo, debugInfo),
+		withDetails(codes.Unavailable, "unavailable", debugInfo, retryInfo),
+		FromProto(base.Proto()),
+	}
+	for i, s := range equal {
+		if s.Hash() != base.Hash() {
+			t.Errorf("equal status %d: expected hash %d, got %d", i, base.Hash(), s.Hash())
+		}
+	}
+
+	// The same ErrorInfo with its metadata entries serialized in different orders, as the merge of
+	// its serialized parts.
+	errorInfo := func(parts ...*rpc.ErrorInfo) *types.Any {
+		detail, err := types.MarshalAny(&rpc.ErrorInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, part := range parts {
+			b, err := proto.Marshal(part)
+			if err != nil {
+				t.Fatal(err)
+			}
+			detail.Value = append(detail.Value, b...)
+		}
+		return detail
+	}
+	first := &rpc.ErrorInfo{Reason: "QUOTA", Domain: "mcp.istio.io", Metadata: map[string]string{"a": "1"}}
+	second := &rpc.ErrorInfo{Metadata: map[string]string{"b": "2", "c": "3"}}
+	third := &rpc.ErrorInfo{Metadata: map[string]string{"d": "4"}}
+	forward := FromProto(&rpc.Status{Code: int32(codes.ResourceExhausted), Message: "quota",
+		Details: []*types.Any{errorInfo(first, second, third)}})
+	backward := FromProto(&rpc.Status{Code: int32(codes.ResourceExhausted), Message: "quota",
+		Details: []*types.Any{errorInfo(third, second, first)}})
+	if !forward.Equal(backward) {
+		t.Fatal("expected the statuses with reordered metadata to be equal")
+	}
+	if forward.Hash() != backward.Hash() {
+		t.Errorf("statuses with reordered metadata: expected equal hashes, got %d and %d", forward.Hash(), backward.Hash())
+	}
+
+	different := []*Status{
+		withDetails(codes.Internal, "unavailable", retryInfo, debugInfo),
+		withDetails(codes.Unavailable, "other", retryInfo, debugInfo),
+		withDetails(codes.Unavailable, "unavailable", retryInfo),
+		withDetails(codes.Unavailable, "unavailable", retryInfo, &rpc.DebugInfo{Detail: "other"}),
+		New(codes.Unavailable, "unavailable"),
+	}
+	for i, s := range different {
+		if s.Hash() == base.Hash() {
+			t.Errorf("different status %d: unexpected equal hash %d", i, s.Hash())
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	withErrorInfo := func(s *Status, metadata map[string]string) *Status {
+		out, err := s.WithErrorInfo("QUOTA", "mcp.istio.io", metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	metadata := map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}
+	cases := []struct {
+		name string
+		a, b *Status
+		want bool
+	}{
+		{name: "nil", a: nil, b: nil, want: true},
+		{name: "nil and ok", a: nil, b: New(codes.OK, ""), want: true},
+		{name: "ok and nil", a: New(codes.OK, ""), b: nil, want: true},
+		{name: "nil and ok with message", a: nil, b: New(codes.OK, "done"), want: true},
+		{name: "ok with different messages", a: New(codes.OK, "done"), b: New(codes.OK, "finished"), want: true},
+		{name: "nil and error", a: nil, b: New(codes.NotFound, ""), want: false},
+		{name: "same", a: New(codes.NotFound, "missing"), b: New(codes.NotFound, "missing"), want: true},
+		{name: "different code", a: New(codes.NotFound, "missing"), b: New(codes.Internal, "missing"), want: false},
+		{name: "different message", a: New(codes.NotFound, "missing"), b: New(codes.NotFound, "gone"), want: false},
+		{
+			name: "same details",
+			a:    withErrorInfo(New(codes.ResourceExhausted, "quota"), metadata),
+			b:    withErrorInfo(New(codes.ResourceExhausted, "quota"), metadata),
+			want: true,
+		},
+		{
+			name: "different details",
+			a:    withErrorInfo(New(codes.ResourceExhausted, "quota"), metadata),
+			b:    withErrorInfo(New(codes.ResourceExhausted, "quota"), map[string]string{"a": "1"}),
+			want: false,
+		},
+		{
+			name: "missing details",
+			a:    withErrorInfo(New(codes.ResourceExhausted, "quota"), metadata),
+			b:    New(codes.ResourceExhausted, "quota"),
+			want: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.a.Equal(c.b); got != c.want {
+				t.Fatalf("expected Equal to return %v, got %v", c.want, got)
+			}
+			if c.want && c.a.Hash() != c.b.Hash() {
+				t.Fatalf("expected equal statuses to have equal hashes, got %d and %d", c.a.Hash(), c.b.Hash())
+			}
+		})
+	}
+}
+
+func TestIs(t *testing.T) {
+	err := Error(codes.NotFound, "service missing")
+	if !errors.Is(err, Error(codes.NotFound, "other service missing")) {
+		t.Fatal("expected the errors of the same code to match")
+	}
+	if !errors.Is(fmt.Errorf("lookup: %w", err), status.Error(codes.NotFound, "")) {
+		t.Fatal("expected the wrapped error to match a grpc/status error of the same code")
+	}
+	if errors.Is(err, Error(codes.Internal, "service missing")) {
+		t.Fatal("expected the errors of different codes not to match")
+	}
+	if errors.Is(err, errors.New("service missing")) {
+		t.Fatal("expected a non-status error not to match")
+	}
+	if !New(codes.NotFound, "").Is(err) {
+		t.Fatal("expected the status to match an error of the same code")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	unregistered := &types.Any{TypeUrl: "type.googleapis.com/example.Unregistered", Value: []byte{0x08, 0x01}}
+	s, err := New(codes.NotFound, "service missing").WithErrorInfo("MISSING", "mcp.istio.io", map[string]string{"name": "reviews"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.WithDetailsAny(unregistered); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"code":5,"code_name":"NotFound","message":"service missing","details":[` +
+		`{"type_url":"type.googleapis.com/google.rpc.ErrorInfo","value":{"reason":"MISSING","domain":"mcp.istio.io","metadata":{"name":"reviews"}}},` +
+		`{"type_url":"type.googleapis.com/example.Unregistered","value_base64":"CAE="}]}`
+	if string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+
+	got := &Status{}
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(s) {
+		t.Fatalf("expected %v after the round trip, got %v", s, got)
+	}
+
+	// Statuses are immutable, so unmarshaling into a status that is already set, such as the OK status shared by
+	// FromError, fails and leaves it unchanged.
+	ok, _ := FromError(nil)
+	for _, set := range []*Status{ok, s} {
+		if err := json.Unmarshal(data, set); err == nil {
+			t.Fatalf("expected unmarshaling into %v to fail", set)
+		}
+	}
+	if ok, _ := FromError(nil); ok.Code() != codes.OK || ok.Message() != "" || len(ok.Details()) != 0 {
+		t.Fatalf("expected the OK status to be unchanged, got %v", ok)
+	}
+}
+
+func TestKind(t *testing.T) {
+	cases := map[codes.Code]string{
+		codes.OK:                 KindOK,
+		codes.Canceled:           KindClient,
+		codes.Unknown:            KindServer,
+		codes.InvalidArgument:    KindClient,
+		codes.DeadlineExceeded:   KindServer,
+		codes.NotFound:           KindClient,
+		codes.AlreadyExists:      KindClient,
+		codes.PermissionDenied:   KindClient,
+		codes.ResourceExhausted:  KindClient,
+		codes.FailedPrecondition: KindClient,
+		codes.Aborted:            KindClient,
+		codes.OutOfRange:         KindClient,
+		codes.Unimplemented:      KindServer,
+		codes.Internal:           KindServer,
+		codes.Unavailable:        KindServer,
+		codes.DataLoss:           KindServer,
+		codes.Unauthenticated:    KindClient,
+		codes.Code(100):          KindServer,
+	}
+	for c, want := range cases {
+		if got := New(c, "").Kind(); got != want {
+			t.Errorf("%v: expected %q, got %q", c, want, got)
+		}
+	}
+
+	var nilStatus *Status
+	if got := nilStatus.Kind(); got != KindOK {
+		t.Errorf("nil status: expected %q, got %q", KindOK, got)
+	}
+}
+
+func TestString(t *testing.T) {
+	withDetails := func(s *Status, details ...proto.Message) *Status {
+		out, err := s.WithDetails(details...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	cases := []struct {
+		name string
+		s    *Status
+		want string
+	}{
+		{name: "nil", s: nil, want: "OK"},
+		{name: "empty", s: &Status{}, want: "OK"},
+		{name: "ok", s: New(codes.OK, ""), want: "OK"},
+		{name: "message", s: New(codes.NotFound, "service missing"), want: "NotFound: service missing"},
+		{
+			name: "one detail",
+			s:    withDetails(New(codes.Unavailable, "unavailable"), &rpc.RetryInfo{}),
+			want: "Unavailable: unavailable [1 detail]",
+		},
+		{
+			name: "details",
+			s:    withDetails(New(codes.NotFound, "service missing"), &rpc.RetryInfo{}, &rpc.DebugInfo{}),
+			want: "NotFound: service missing [2 details]",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.s.String(); got != c.want {
+				t.Fatalf("expected %q, got %q", c.want, got)
+			}
+			if got := fmt.Sprint(c.s); got != c.want {
+				t.Fatalf("expected %q from fmt, got %q", c.want, got)
+			}
+		})
+	}
+}
+
+func TestHTTPStatusCode(t *testing.T) {
+	cases := []struct {
+		status *Status
+		want   int
+	}{
+		{nil, http.StatusOK},
+		{New(codes.OK, ""), http.StatusOK},
+		{New(codes.NotFound, "not found"), http.StatusNotFound},
+		{New(codes.PermissionDenied, "denied"), http.StatusForbidden},
+		{New(codes.Unauthenticated, "unauthenticated"), http.StatusUnauthorized},
+		{New(codes.Unavailable, "unavailable"), http.StatusServiceUnavailable},
+		{New(codes.DeadlineExceeded, "timeout"), http.StatusGatewayTimeout},
+		{New(codes.ResourceExhausted, "quota"), http.StatusTooManyRequests},
+		{New(codes.Canceled, "canceled"), 499},
+		{New(codes.Code(42), "unrecognized"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		if got := c.status.HTTPStatusCode(); got != c.want {
+			t.Errorf("expected HTTP status %d for %v, got %d", c.want, c.status.Code(), got)
+		}
+	}
+}
+
+func TestToProblemJSON(t *testing.T) {
+	got := New(codes.NotFound, "service missing").ToProblemJSON("/v1/services/reviews")
+	want := `{"type":"urn:grpc:status:NotFound","title":"NotFound","status":404,"detail":"service missing",` +
+		`"instance":"/v1/services/reviews"}`
+	if string(got) != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	// The detail and instance are omitted if empty.
+	got = New(codes.Unavailable, "").ToProblemJSON("")
+	want = `{"type":"urn:grpc:status:Unavailable","title":"Unavailable","status":503}`
+	if string(got) != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	errorInfo := func(retryAfter string) *rpc.ErrorInfo {
+		return &rpc.ErrorInfo{
+			Reason:   "RATE_LIMITED",
+			Metadata: map[string]string{RetryAfterMetadataKey: retryAfter},
+		}
+	}
+	cases := []struct {
+		name    string
+		details []proto.Message
+		want    time.Duration
+		wantOK  bool
+	}{
+		{
+			name:    "retry info",
+			details: []proto.Message{&rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 2}}},
+			want:    2 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name:    "error info duration",
+			details: []proto.Message{errorInfo("1500ms")},
+			want:    1500 * time.Millisecond,
+			wantOK:  true,
+		},
+		{
+			name:    "error info seconds",
+			details: []proto.Message{errorInfo("3")},
+			want:    3 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name:    "retry info preferred",
+			details: []proto.Message{errorInfo("3"), &rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 2}}},
+			want:    2 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name:    "invalid error info",
+			details: []proto.Message{errorInfo("soon")},
+		},
+		{
+			name:    "no details",
+			details: nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := New(codes.Unavailable, "unavailable").WithDetails(c.details...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := s.RetryAfter()
+			if got != c.want || ok != c.wantOK {
+				t.Fatalf("expected (%v, %v), got (%v, %v)", c.want, c.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestWithErrorInfo(t *testing.T) {
+	s := New(codes.ResourceExhausted, "too many watches")
+	if _, ok := s.ErrorInfo(); ok {
+		t.Fatal("expected no ErrorInfo")
+	}
+
+	withInfo, err := s.WithErrorInfo("WATCH_LIMIT_EXCEEDED", "mcp.istio.io", map[string]string{"limit": "100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The ErrorInfo survives a round trip through the wire format.
+	got, ok := FromProto(withInfo.Proto()).ErrorInfo()
+	if !ok {
+		t.Fatal("expected an ErrorInfo")
+	}
+	want := &rpc.ErrorInfo{
+		Reason:   "WATCH_LIMIT_EXCEEDED",
+		Domain:   "mcp.istio.io",
+		Metadata: map[string]string{"limit": "100"},
+	}
+	if !proto.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if _, err := New(codes.OK, "").WithErrorInfo("REASON", "mcp.istio.io", nil); err == nil {
+		t.Fatal("expected an error for an OK status")
+	}
+}
+
+func TestTypedDetails(t *testing.T) {
+	retryInfo := &rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 1}}
+	badRequest := &rpc.BadRequest{FieldViolations: []*rpc.BadRequest_FieldViolation{{Field: "name", Description: "empty"}}}
+	quotaFailure := &rpc.QuotaFailure{Violations: []*rpc.QuotaFailure_Violation{{Subject: "client:a", Description: "limit"}}}
+	s, err := New(codes.InvalidArgument, "invalid").WithDetails(retryInfo, badRequest, quotaFailure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A detail that cannot be decoded is skipped.
+	s, err = s.WithDetailsAny(&types.Any{TypeUrl: "type.googleapis.com/example.Unregistered"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := s.RetryInfo(); !ok || !proto.Equal(got, retryInfo) {
+		t.Fatalf("expected RetryInfo %v, got %v (%v)", retryInfo, got, ok)
+	}
+	if got, ok := s.BadRequest(); !ok || !proto.Equal(got, badRequest) {
+		t.Fatalf("expected BadRequest %v, got %v (%v)", badRequest, got, ok)
+	}
+	if got, ok := s.QuotaFailure(); !ok || !proto.Equal(got, quotaFailure) {
+		t.Fatalf("expected QuotaFailure %v, got %v (%v)", quotaFailure, got, ok)
+	}
+
+	empty := New(codes.InvalidArgument, "invalid")
+	if _, ok := empty.RetryInfo(); ok {
+		t.Fatal("expected no RetryInfo")
+	}
+	if _, ok := empty.BadRequest(); ok {
+		t.Fatal("expected no BadRequest")
+	}
+	if _, ok := empty.QuotaFailure(); ok {
+		t.Fatal("expected no QuotaFailure")
+	}
+}
+
+func TestDisplayMessage(t *testing.T) {
+	s := New(codes.InvalidArgument, "invalid resource")
+	if got := s.DisplayMessage("fr-FR"); got != "invalid resource" {
+		t.Fatalf("expected the default message, got %q", got)
+	}
+
+	localized, err := s.WithDetails(
+		&rpc.LocalizedMessage{Locale: "fr-FR", Message: "ressource invalide"},
+		&rpc.LocalizedMessage{Locale: "de-DE", Message: "ungültige Ressource"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"fr-FR": "ressource invalide",
+		"de-de": "ungültige Ressource",
+		"es-ES": "invalid resource",
+		"":      "invalid resource",
+	}
+	for locale, want := range cases {
+		if got := localized.DisplayMessage(locale); got != want {
+			t.Errorf("expected message %q for locale %q, got %q", want, locale, got)
+		}
+	}
+}
+
+func TestDecompose(t *testing.T) {
+	// The message of a merged status may contain the separator itself.
+	east, err := New(codes.Unavailable, "cluster east unreachable; retrying").WithDetails(
+		&rpc.ResourceInfo{ResourceType: "cluster", ResourceName: "east"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	west, err := New(codes.Unavailable, "cluster west unreachable").WithDetails(
+		&rpc.ResourceInfo{ResourceType: "cluster", ResourceName: "west"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A detail that cannot be decoded is skipped.
+	p := Merge(east, west).Proto()
+	p.Details = append(p.Details, &types.Any{TypeUrl: "type.googleapis.com/unknown.Type"})
+
+	code, messages, details := FromProto(p).Decompose()
+	if code != codes.Unavailable {
+		t.Errorf("expected code %v, got %v", codes.Unavailable, code)
+	}
+	if want := []string{"cluster east unreachable; retrying", "cluster west unreachable"}; !reflect.DeepEqual(messages, want) {
+		t.Errorf("expected messages %q, got %q", want, messages)
+	}
+	want := []proto.Message{
+		&rpc.ResourceInfo{ResourceType: "cluster", ResourceName: "east"},
+		&rpc.ResourceInfo{ResourceType: "cluster", ResourceName: "west"},
+	}
+	if len(details) != len(want) {
+		t.Fatalf("expected %d details, got %d: %v", len(want), len(details), details)
+	}
+	for i := range want {
+		if !proto.Equal(details[i], want[i]) {
+			t.Errorf("expected detail %d to be %v, got %v", i, want[i], details[i])
+		}
+	}
+
+	// The message of a status that wasn't merged is never split.
+	if _, messages, _ := east.Decompose(); !reflect.DeepEqual(messages, []string{east.Message()}) {
+		t.Errorf("expected the single message %q, got %q", east.Message(), messages)
+	}
+
+	if code, messages, details := (*Status)(nil).Decompose(); code != codes.OK || messages != nil || details != nil {
+		t.Errorf("expected a nil status to decompose into OK, got %v %q %v", code, messages, details)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	east, err := New(codes.Unavailable, "cluster east unreachable").WithDetails(
+		&rpc.ResourceInfo{ResourceType: "cluster", ResourceName: "east"},
+		&rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 1}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	west, err := New(codes.NotFound, "cluster west not found").WithDetails(
+		&rpc.ResourceInfo{ResourceType: "cluster", ResourceName: "west"},
+		&rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 1}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	internal := New(codes.Internal, "")
+
+	merged := Merge(west, nil, New(codes.OK, ""), east, internal)
+	if merged.Code() != codes.Internal {
+		t.Errorf("expected the most severe code %v, got %v", codes.Internal, merged.Code())
+	}
+	if want := "cluster west not found" + MessageSeparator + "cluster east unreachable"; merged.Message() != want {
+		t.Errorf("expected message %q, got %q", want, merged.Message())
+	}
+	_, messages, details := merged.Decompose()
+	if want := []string{"cluster west not found", "cluster east unreachable"}; !reflect.DeepEqual(messages, want) {
+		t.Errorf("expected messages %q, got %q", want, messages)
+	}
+	want := []proto.Message{
+		&rpc.ResourceInfo{ResourceType: "cluster", ResourceName: "west"},
+		&rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 1}},
+		&rpc.ResourceInfo{ResourceType: "cluster", ResourceName: "east"},
+	}
+	if len(details) != len(want) {
+		t.Fatalf("expected %d details, got %d: %v", len(want), len(details), details)
+	}
+	for i := range want {
+		if !proto.Equal(details[i], want[i]) {
+			t.Errorf("expected detail %d to be %v, got %v", i, want[i], details[i])
+		}
+	}
+
+	// Merging a merged status merges its components.
+	_, messages, _ = Merge(merged, New(codes.Unavailable, "cluster north unreachable")).Decompose()
+	if want := []string{"cluster west not found", "cluster east unreachable", "cluster north unreachable"}; !reflect.DeepEqual(messages, want) {
+		t.Errorf("expected messages %q, got %q", want, messages)
+	}
+
+	// Equally severe codes resolve to the first one.
+	if got := Merge(east, New(codes.DeadlineExceeded, "timeout")).Code(); got != codes.Unavailable {
+		t.Errorf("expected %v, got %v", codes.Unavailable, got)
+	}
+
+	for _, statuses := range [][]*Status{nil, {nil}, {New(codes.OK, ""), nil}} {
+		if merged := Merge(statuses...); merged.Code() != codes.OK || merged.Err() != nil {
+			t.Errorf("expected an OK status for %v, got %v", statuses, merged)
+		}
+	}
+}
+
+func TestWithCause(t *testing.T) {
+	s := New(codes.Internal, "failed to apply config")
+	if _, ok := s.Cause(); ok {
+		t.Fatal("expected no cause")
+	}
+
+	cause := fmt.Errorf("loading snapshot: %w", errors.New("disk full"))
+	withCause, err := s.WithCause(cause)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cause survives a round trip through the wire format.
+	got, ok := FromProto(withCause.Proto()).Cause()
+	if !ok {
+		t.Fatal("expected a cause")
+	}
+	if got.Message != cause.Error() {
+		t.Fatalf("expected cause message %q, got %q", cause.Error(), got.Message)
+	}
+	want := []string{
+		"*fmt.wrapError: loading snapshot: disk full",
+		"*errors.errorString: disk full",
+	}
+	if !reflect.DeepEqual(got.Chain, want) {
+		t.Fatalf("expected cause chain %v, got %v", want, got.Chain)
+	}
+	if withCause.Code() != codes.Internal || withCause.Message() != "failed to apply config" {
+		t.Fatalf("unexpected status %v", withCause.Proto())
+	}
+
+	// Other DebugInfo details are not mistaken for the cause.
+	debug, err := s.WithDetails(&rpc.DebugInfo{Detail: "stack"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := debug.Cause(); ok {
+		t.Fatal("expected no cause")
+	}
+
+	if _, err := New(codes.OK, "").WithCause(cause); err == nil {
+		t.Fatal("expected an error recording a cause on an OK status")
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	s := New(codes.Unavailable, "backend unavailable")
+	if _, ok := s.TraceID(); ok {
+		t.Fatal("expected no trace ID")
+	}
+
+	traced := s.WithTraceID("4bf92f3577b34da6")
+	if id, ok := traced.TraceID(); !ok || id != "4bf92f3577b34da6" {
+		t.Fatalf("TraceID() = %q, %v, want %q, true", id, ok, "4bf92f3577b34da6")
+	}
+	if got, want := traced.Message(), "[trace-id=4bf92f3577b34da6] backend unavailable"; got != want {
+		t.Fatalf("Message() = %q, want %q", got, want)
+	}
+	if s.Message() != "backend unavailable" {
+		t.Fatalf("WithTraceID modified the original status: %q", s.Message())
+	}
+
+	// The trace ID survives the wire.
+	fromWire, ok := FromError(traced.Err())
+	if !ok {
+		t.Fatal("FromError failed")
+	}
+	if id, ok := fromWire.TraceID(); !ok || id != "4bf92f3577b34da6" {
+		t.Fatalf("TraceID() after round trip = %q, %v", id, ok)
+	}
+
+	// A new ID replaces the previous one.
+	retraced := traced.WithTraceID("a3ce929d0e0e4736")
+	if got, want := retraced.Message(), "[trace-id=a3ce929d0e0e4736] backend unavailable"; got != want {
+		t.Fatalf("Message() = %q, want %q", got, want)
+	}
+
+	// Invalid IDs and OK statuses are left unchanged.
+	for _, id := range []string{"", "bad]id"} {
+		if got := s.WithTraceID(id); got != s {
+			t.Fatalf("WithTraceID(%q) = %v, want the status unchanged", id, got)
+		}
+	}
+	if _, ok := New(codes.OK, "").WithTraceID("4bf92f3577b34da6").TraceID(); ok {
+		t.Fatal("expected no trace ID on an OK status")
+	}
+}
+
+func TestFromErrorNil(t *testing.T) {
+	s, ok := FromError(nil)
+	if !ok {
+		t.Fatal("FromError(nil) returned ok=false")
+	}
+	if s.Code() != codes.OK || s.Message() != "" || s.Err() != nil || len(s.Details()) != 0 {
+		t.Fatalf("FromError(nil) = %v, want an OK status", s.Proto())
+	}
+
+	// Mutating the proto of the shared OK status must not affect later calls.
+	p := s.Proto()
+	p.Code = int32(codes.Internal)
+	p.Message = "mutated"
+	if s2, _ := FromError(nil); s2.Code() != codes.OK || s2.Message() != "" {
+		t.Fatalf("FromError(nil) = %v after mutating a previous result", s2.Proto())
+	}
+	if _, err := s.WithDetails(&rpc.RetryInfo{}); err == nil {
+		t.Fatal("expected WithDetails to fail for an OK status")
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { _, _ = FromError(nil) }); allocs != 0 {
+		t.Fatalf("FromError(nil) allocated %v times, want 0", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { _ = Code(nil) }); allocs != 0 {
+		t.Fatalf("Code(nil) allocated %v times, want 0", allocs)
+	}
+}
+
+func BenchmarkFromErrorNil(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = FromError(nil)
+	}
+}
+
+func BenchmarkGRPCStatus(b *testing.B) {
+	s, err := New(codes.Unavailable, "unavailable").WithDetails(&rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 1}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	se := s.Err().(interface{ GRPCStatus() *status.Status })
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = se.GRPCStatus()
+	}
+}
+
+func BenchmarkCodeNil(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = Code(nil)
+	}
+}
+
+// collectionError is an error of a type unknown to this package, wrapping a status error.
+type collectionError struct {
+	collection string
+	err        error
+}
+
+func (e *collectionError) Error() string {
+	return e.collection + ": " + e.err.Error()
+}
+
+func (e *collectionError) Unwrap() error {
+	return e.err
+}
+
+func TestFromErrorWrapped(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{
+			name: "status error",
+			err:  fmt.Errorf("watching resources: %w", Error(codes.NotFound, "collection missing")),
+			code: codes.NotFound,
+		},
+		{
+			name: "grpc status error",
+			err:  fmt.Errorf("pushing snapshot: %w", status.Error(codes.DeadlineExceeded, "deadline exceeded")),
+			code: codes.DeadlineExceeded,
+		},
+		{
+			name: "wrapped twice",
+			err:  fmt.Errorf("outer: %w", fmt.Errorf("inner: %w", Error(codes.Unavailable, "unavailable"))),
+			code: codes.Unavailable,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Code(c.err); got != c.code {
+				t.Fatalf("Code: expected %v, got %v", c.code, got)
+			}
+			s, ok := FromError(c.err)
+			if !ok {
+				t.Fatal("expected the wrapped status to be found")
+			}
+			if s.Code() != c.code || s.Message() != c.err.Error() {
+				t.Fatalf("expected (%v, %q), got (%v, %q)", c.code, c.err.Error(), s.Code(), s.Message())
+			}
+			// The error of the status exposes the error it was converted from.
+			if got := errors.Unwrap(s.Err()); got != c.err {
+				t.Fatalf("expected %v to unwrap to %v, got %v", s.Err(), c.err, got)
+			}
+			if got := Code(s.Err()); got != c.code {
+				t.Fatalf("Code of the converted error: expected %v, got %v", c.code, got)
+			}
+		})
+	}
+
+	// The errors of other types in the chain are still found, which is only possible through Unwrap.
+	cause := &collectionError{collection: "istio/networking/v1alpha3/gateways", err: Error(codes.NotFound, "collection missing")}
+	s, ok := FromError(fmt.Errorf("watching resources: %w", cause))
+	if !ok {
+		t.Fatal("expected the wrapped status to be found")
+	}
+	var target *collectionError
+	if !errors.As(s.Err(), &target) || target != cause {
+		t.Fatalf("expected %v to unwrap to %v, got %v", s.Err(), cause, target)
+	}
+
+	// Errors that don't wrap a status error are still unknown.
+	plain := fmt.Errorf("wrapped: %w", errors.New("plain"))
+	if s, ok := FromError(plain); ok || s.Code() != codes.Unknown {
+		t.Fatalf("expected an unknown status, got (%v, %t)", s.Code(), ok)
+	}
+	if got := Code(plain); got != codes.Unknown {
+		t.Fatalf("expected %v, got %v", codes.Unknown, got)
+	}
+}
+
+// unavailableError is a status error with code Unavailable wrapping its cause.
+type unavailableError struct {
+	cause error
+}
+
+func (e *unavailableError) Error() string {
+	return "unavailable: " + e.cause.Error()
+}
+
+func (e *unavailableError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, e.Error())
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.cause
+}
+
+func TestCodeOfContextErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{name: "canceled", err: context.Canceled, code: codes.Canceled},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, code: codes.DeadlineExceeded},
+		{
+			name: "wrapped canceled",
+			err:  fmt.Errorf("outer: %w", fmt.Errorf("watching resources: %w", context.Canceled)),
+			code: codes.Canceled,
+		},
+		{
+			name: "wrapped deadline exceeded",
+			err:  fmt.Errorf("pushing snapshot: %w", context.DeadlineExceeded),
+			code: codes.DeadlineExceeded,
+		},
+		{
+			// The status wrapping the context error takes precedence.
+			name: "status wrapping a context error",
+			err:  fmt.Errorf("outer: %w", &unavailableError{cause: context.Canceled}),
+			code: codes.Unavailable,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Code(c.err); got != c.code {
+				t.Fatalf("expected %v, got %v", c.code, got)
+			}
+			if got, _ := FromError(c.err); got.Code() != c.code {
+				t.Fatalf("expected FromError to return code %v, got %v", c.code, got.Code())
+			}
+			if got := Convert(c.err); got.Code() != c.code || got.Message() != c.err.Error() {
+				t.Fatalf("expected Convert to return code %v and message %q, got %v", c.code, c.err.Error(), got.Proto())
+			}
+		})
+	}
+}
+
+func TestFromContextError(t *testing.T) {
+	deadline := fmt.Errorf("pushing snapshot: %w", context.DeadlineExceeded)
+	cases := []struct {
+		name string
+		err  error
+		code codes.Code
+		msg  string
+	}{
+		{name: "nil", err: nil, code: codes.OK, msg: ""},
+		{name: "canceled", err: context.Canceled, code: codes.Canceled, msg: "context canceled"},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, code: codes.DeadlineExceeded, msg: "context deadline exceeded"},
+		{name: "wrapped", err: deadline, code: codes.DeadlineExceeded, msg: deadline.Error()},
+		{name: "status error", err: Error(codes.NotFound, "collection missing"), code: codes.NotFound, msg: "collection missing"},
+		{name: "plain error", err: errors.New("boom"), code: codes.Unknown, msg: "boom"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := FromContextError(c.err)
+			if s.Code() != c.code || s.Message() != c.msg {
+				t.Fatalf("expected (%v, %q), got (%v, %q)", c.code, c.msg, s.Code(), s.Message())
+			}
+		})
+	}
+}
+
+func TestFromErrorWith(t *testing.T) {
+	errForbidden := errors.New("forbidden")
+	mapper := func(err error) (*Status, bool) {
+		if errors.Is(err, errForbidden) {
+			return New(codes.PermissionDenied, err.Error()), true
+		}
+		return nil, false
+	}
+
+	s := FromErrorWith(fmt.Errorf("reading config: %w", errForbidden), mapper)
+	if s.Code() != codes.PermissionDenied || s.Message() != "reading config: forbidden" {
+		t.Fatalf("expected the mapped status, got %v", s.Proto())
+	}
+
+	// Errors the mapper doesn't handle fall back to the default behavior.
+	if s := FromErrorWith(Error(codes.NotFound, "missing"), mapper); s.Code() != codes.NotFound {
+		t.Fatalf("expected code NotFound, got %v", s.Code())
+	}
+	if s := FromErrorWith(errors.New("boom"), mapper); s.Code() != codes.Unknown || s.Message() != "boom" {
+		t.Fatalf("expected an Unknown status, got %v", s.Proto())
+	}
+	if s := FromErrorWith(errors.New("boom"), nil); s.Code() != codes.Unknown {
+		t.Fatalf("expected code Unknown, got %v", s.Code())
+	}
+	if s := FromErrorWith(nil, mapper); s.Code() != codes.OK {
+		t.Fatalf("expected code OK, got %v", s.Code())
+	}
+}
+
+func TestDetailsV2(t *testing.T) {
+	s, err := New(codes.Unavailable, "unavailable").WithDetailsV2(durationpb.New(time.Second), wrapperspb.String("retry"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The details are stored as the other details.
+	if s, err = s.WithDetailsAny(&types.Any{TypeUrl: "type.googleapis.com/example.Unregistered"}); err != nil {
+		t.Fatal(err)
+	}
+
+	details := s.DetailsV2()
+	if len(details) != 3 {
+		t.Fatalf("expected 3 details, got %v", details)
+	}
+	if d, ok := details[0].(*durationpb.Duration); !ok || d.AsDuration() != time.Second {
+		t.Fatalf("expected a duration of 1s, got %v", details[0])
+	}
+	if w, ok := details[1].(*wrapperspb.StringValue); !ok || w.GetValue() != "retry" {
+		t.Fatalf("expected the string value retry, got %v", details[1])
+	}
+	if _, ok := details[2].(error); !ok {
+		t.Fatalf("expected an error for the undecodable detail, got %v", details[2])
+	}
+
+	if _, err := New(codes.OK, "").WithDetailsV2(wrapperspb.String("retry")); err == nil {
+		t.Fatal("expected an error for a status with code OK")
+	}
+}
+
+func TestWithDetailsAny(t *testing.T) {
+	// The type of the detail is not registered, so it could not be marshaled by WithDetails.
+	unregistered := &types.Any{TypeUrl: "type.googleapis.com/example.Unregistered", Value: []byte{0x08, 0x01}}
+	retryInfo, err := types.MarshalAny(&rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(codes.Unavailable, "unavailable").WithDetailsAny(retryInfo, unregistered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	details := s.Proto().GetDetails()
+	if len(details) != 2 || !proto.Equal(details[0], retryInfo) || !proto.Equal(details[1], unregistered) {
+		t.Fatalf("unexpected details: %v", details)
+	}
+	if delay, ok := s.RetryAfter(); !ok || delay != time.Second {
+		t.Fatalf("expected a retry delay of 1s, got %v (%v)", delay, ok)
+	}
+
+	if _, err := New(codes.OK, "").WithDetailsAny(retryInfo); err == nil {
+		t.Fatal("expected an error for a status with code OK")
+	}
+}
+
+func TestWithDetailsCapped(t *testing.T) {
+	s, err := New(codes.Internal, "internal").WithDetails(&rpc.DebugInfo{Detail: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capped, err := s.WithDetailsCapped(3, &rpc.DebugInfo{Detail: "second"})
+	if err != nil {
+		t.Fatalf("unexpected error below the maximum: %v", err)
+	}
+	if got := len(capped.Proto().GetDetails()); got != 2 {
+		t.Fatalf("expected 2 details, got %d", got)
+	}
+
+	capped, err = capped.WithDetailsCapped(3,
+		&rpc.DebugInfo{Detail: "third"},
+		&rpc.DebugInfo{Detail: "fourth"},
+		&rpc.DebugInfo{Detail: "fifth"},
+	)
+	if !errors.Is(err, ErrDetailsTruncated) {
+		t.Fatalf("expected the details to be truncated, got %v", err)
+	}
+	details := capped.Details()
+	if len(details) != 3 {
+		t.Fatalf("expected 3 details, got %v", details)
+	}
+	if last, ok := details[2].(*rpc.DebugInfo); !ok || last.Detail != "third" {
+		t.Fatalf("expected the first of the appended details to be kept, got %v", details[2])
+	}
+	if capped.Code() != codes.Internal || capped.Message() != "internal" {
+		t.Fatalf("unexpected status: %v", capped)
+	}
+
+	// A status already over the maximum keeps its details but doesn't grow.
+	capped, err = capped.WithDetailsCapped(1, &rpc.DebugInfo{Detail: "sixth"})
+	if !errors.Is(err, ErrDetailsTruncated) || len(capped.Proto().GetDetails()) != 3 {
+		t.Fatalf("expected the detail to be dropped, got %v (%v)", capped.Details(), err)
+	}
+
+	if _, err := New(codes.OK, "").WithDetailsCapped(1, &rpc.DebugInfo{}); err == nil {
+		t.Fatal("expected an error for a status with code OK")
+	}
+}
+
+func TestWithoutDetail(t *testing.T) {
+	s, err := New(codes.Internal, "internal").WithDetails(
+		&rpc.DebugInfo{Detail: "stack"},
+		&rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 1}},
+		&rpc.DebugInfo{Detail: "more stack"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stripped := s.WithoutDetail("type.googleapis.com/google.rpc.DebugInfo")
+	details := stripped.Details()
+	if len(details) != 1 {
+		t.Fatalf("expected 1 detail, got %v", details)
+	}
+	if _, ok := details[0].(*rpc.RetryInfo); !ok {
+		t.Fatalf("expected the RetryInfo detail to be kept, got %T", details[0])
+	}
+	if stripped.Code() != codes.Internal || stripped.Message() != "internal" {
+		t.Fatalf("unexpected status %v", stripped.Proto())
+	}
+
+	// The original status is unchanged.
+	if got := len(s.Details()); got != 3 {
+		t.Fatalf("expected the original status to keep 3 details, got %d", got)
+	}
+
+	// Removing a type that isn't present keeps all of the details.
+	if got := len(s.WithoutDetail("type.googleapis.com/google.rpc.ErrorInfo").Details()); got != 3 {
+		t.Fatalf("expected 3 details, got %d", got)
+	}
+}
+
+func TestWithoutDetails(t *testing.T) {
+	s, err := New(codes.Internal, "internal").WithDetails(
+		&rpc.DebugInfo{Detail: "stack"},
+		&rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 1}},
+		&rpc.ErrorInfo{Reason: "STALE_CONFIG"},
+		&rpc.DebugInfo{Detail: "more stack"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redacted := s.WithoutDetails(
+		"type.googleapis.com/google.rpc.DebugInfo",
+		"type.googleapis.com/google.rpc.ErrorInfo",
+		// Type URLs are matched exactly.
+		"google.rpc.RetryInfo",
+	)
+	details := redacted.Details()
+	if len(details) != 1 {
+		t.Fatalf("expected 1 detail, got %v", details)
+	}
+	if _, ok := details[0].(*rpc.RetryInfo); !ok {
+		t.Fatalf("expected the RetryInfo detail to be kept, got %T", details[0])
+	}
+	if redacted.Code() != codes.Internal || redacted.Message() != "internal" {
+		t.Fatalf("unexpected status %v", redacted.Proto())
+	}
+	if got := len(s.Details()); got != 4 {
+		t.Fatalf("expected the original status to keep 4 details, got %d", got)
+	}
+
+	// Without type URLs, all of the details are kept.
+	if got := len(s.WithoutDetails().Details()); got != 4 {
+		t.Fatalf("expected 4 details, got %d", got)
+	}
+}
+
+func TestBothStatusesAnyStatus(t *testing.T) {
+	statusErr := Error(codes.NotFound, "not found")
+	grpcErr := status.Error(codes.Internal, "internal")
+	plainErr := errors.New("plain")
+	wrappedErr := fmt.Errorf("wrapped: %w", grpcErr)
+
+	cases := []struct {
+		name     string
+		a, b     error
+		wantBoth bool
+		wantAny  bool
+	}{
+		{"both status", statusErr, statusErr, true, true},
+		{"status and wrapped status", statusErr, wrappedErr, true, true},
+		{"plain and wrapped status", plainErr, wrappedErr, false, true},
+		{"status and grpc status", statusErr, grpcErr, true, true},
+		{"status and plain", statusErr, plainErr, false, true},
+		{"plain and grpc status", plainErr, grpcErr, false, true},
+		{"status and nil", statusErr, nil, false, true},
+		{"both plain", plainErr, plainErr, false, false},
+		{"both nil", nil, nil, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := BothStatuses(c.a, c.b); got != c.wantBoth {
+				t.Errorf("BothStatuses() = %v, want %v", got, c.wantBoth)
+			}
+			if got := AnyStatus(c.a, c.b); got != c.wantAny {
+				t.Errorf("AnyStatus() = %v, want %v", got, c.wantAny)
+			}
+		})
+	}
+}
+
+func TestSeverityAtLeast(t *testing.T) {
+	cases := []struct {
+		code codes.Code
+		min  codes.Code
+		want bool
+	}{
+		{codes.OK, codes.OK, true},
+		{codes.NotFound, codes.OK, true},
+		{codes.OK, codes.NotFound, false},
+		{codes.NotFound, codes.AlreadyExists, true},
+		{codes.NotFound, codes.InvalidArgument, false},
+		{codes.PermissionDenied, codes.InvalidArgument, true},
+		{codes.InvalidArgument, codes.Unavailable, false},
+		{codes.Unavailable, codes.DeadlineExceeded, true},
+		{codes.Unavailable, codes.Internal, false},
+		{codes.Internal, codes.Unavailable, true},
+		{codes.DataLoss, codes.Internal, true},
+		{codes.Internal, codes.DataLoss, false},
+		// Unrecognized codes are as severe as Unknown.
+		{codes.Code(100), codes.Unknown, true},
+		{codes.Code(100), codes.Internal, false},
+		{codes.Unimplemented, codes.Code(100), true},
+	}
+	for _, c := range cases {
+		if got := New(c.code, "").SeverityAtLeast(c.min); got != c.want {
+			t.Errorf("SeverityAtLeast(%v) of %v = %v, want %v", c.min, c.code, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	"google.golang.org/grpc/codes"
+
+	rpc "istio.io/gogo-genproto/googleapis/google/rpc"
+	"istio.io/istio/pkg/mcp/status"
+)
+
+// maxRandomDetails is the maximum number of details attached by RandomStatus.
+const maxRandomDetails = 3
+
+// RandomStatus returns a random, valid status with a non-OK code, a message and up to
+// maxRandomDetails details. The same seed always produces the same status, which allows
+// property-based tests to reproduce failures.
+func RandomStatus(seed int64) *status.Status {
+	r := rand.New(rand.NewSource(seed))
+
+	// Codes are numbered from OK (0) to Unauthenticated (16).
+	c := codes.Code(1 + r.Intn(int(codes.Unauthenticated)))
+	s := status.Newf(c, "random status %d", r.Int63())
+
+	details := make([]proto.Message, 0, maxRandomDetails)
+	for i := r.Intn(maxRandomDetails + 1); i > 0; i-- {
+		details = append(details, randomDetail(r))
+	}
+	if len(details) == 0 {
+		return s
+	}
+	out, err := s.WithDetails(details...)
+	if err != nil {
+		// The details are well-known messages with a non-OK code, so this can't happen.
+		panic(fmt.Sprintf("failed adding details to random status: %v", err))
+	}
+	return out
+}
+
+func randomDetail(r *rand.Rand) proto.Message {
+	switch r.Intn(3) {
+	case 0:
+		return &rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: r.Int63n(60), Nanos: r.Int31n(1e9)}}
+	case 1:
+		return &rpc.DebugInfo{Detail: fmt.Sprintf("detail %d", r.Int63())}
+	default:
+		return &rpc.ResourceInfo{
+			ResourceType: "type",
+			ResourceName: fmt.Sprintf("resource-%d", r.Int63()),
+		}
+	}
+}
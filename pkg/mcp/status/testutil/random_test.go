@@ -0,0 +1,41 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+
+	"istio.io/istio/pkg/mcp/status"
+)
+
+func TestRandomStatusRoundTrip(t *testing.T) {
+	for seed := int64(0); seed < 100; seed++ {
+		s := RandomStatus(seed)
+		if s.Code() == codes.OK {
+			t.Fatalf("seed %d: unexpected OK status", seed)
+		}
+
+		// The same seed produces the same status.
+		AssertStatusEqual(t, s, RandomStatus(seed))
+
+		// Round-trip through the proto.
+		AssertStatusEqual(t, s, status.FromProto(s.Proto()))
+
+		// Round-trip through the error.
+		AssertStatusEqual(t, s, status.Convert(s.Err()))
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testutil contains helpers for testing code that produces statuses from the
+// istio.io/istio/pkg/mcp/status package.
+package testutil
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"istio.io/istio/pkg/mcp/status"
+)
+
+// AssertStatusEqual fails the test if want and got differ in their code, message or decoded
+// details. The failure message contains a diff of the two statuses.
+func AssertStatusEqual(t testing.TB, want, got *status.Status) {
+	t.Helper()
+	if diff := cmp.Diff(summarize(want), summarize(got)); diff != "" {
+		t.Fatalf("status mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// statusSummary is a comparable, human-readable view of a status.
+type statusSummary struct {
+	Code    string
+	Message string
+	Details []string
+}
+
+func summarize(s *status.Status) statusSummary {
+	out := statusSummary{
+		Code:    s.Code().String(),
+		Message: s.Message(),
+	}
+	for _, detail := range s.Details() {
+		out.Details = append(out.Details, fmt.Sprintf("%T: %v", detail, detail))
+	}
+	return out
+}
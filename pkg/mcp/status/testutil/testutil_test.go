@@ -0,0 +1,77 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gogo/protobuf/types"
+	"google.golang.org/grpc/codes"
+
+	rpc "istio.io/gogo-genproto/googleapis/google/rpc"
+	"istio.io/istio/pkg/mcp/status"
+)
+
+// recordingTB records failures instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Fatalf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func withRetryDelay(t *testing.T, s *status.Status, seconds int64) *status.Status {
+	t.Helper()
+	out, err := s.WithDetails(&rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: seconds}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestAssertStatusEqual(t *testing.T) {
+	t.Run("equal", func(t *testing.T) {
+		want := withRetryDelay(t, status.New(codes.NotFound, "missing"), 1)
+		got := withRetryDelay(t, status.New(codes.NotFound, "missing"), 1)
+
+		r := &recordingTB{TB: t}
+		AssertStatusEqual(r, want, got)
+		if len(r.failures) != 0 {
+			t.Fatalf("expected no failures, got %v", r.failures)
+		}
+	})
+
+	t.Run("different", func(t *testing.T) {
+		want := withRetryDelay(t, status.New(codes.NotFound, "missing"), 1)
+		got := withRetryDelay(t, status.New(codes.Internal, "broken"), 2)
+
+		r := &recordingTB{TB: t}
+		AssertStatusEqual(r, want, got)
+		if len(r.failures) != 1 {
+			t.Fatalf("expected 1 failure, got %v", r.failures)
+		}
+		for _, expected := range []string{"NotFound", "Internal", "missing", "broken", "RetryInfo"} {
+			if !strings.Contains(r.failures[0], expected) {
+				t.Errorf("failure message does not contain %q:\n%s", expected, r.failures[0])
+			}
+		}
+	})
+}
@@ -0,0 +1,647 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	envoyAdmin "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	bootstrap "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	tcp "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// ParsedConfig holds the sections of an Envoy config dump, unmarshaled once by Parse, so that composite
+// assertions (see AllOf) don't walk the dump again for each section they inspect. Dynamic resources precede static
+// ones. The sections missing from the dump are left empty.
+type ParsedConfig struct {
+	Bootstrap *bootstrap.Bootstrap
+	Clusters  []*cluster.Cluster
+	Listeners []*listener.Listener
+	Routes    []*route.RouteConfiguration
+	Endpoints []*endpoint.ClusterLoadAssignment
+	Secrets   []*tls.Secret
+}
+
+// Parse unmarshals the bootstrap, clusters, listeners, routes, endpoints and secrets of the config dump. Only the
+// active dynamic clusters, listeners and secrets are included.
+func Parse(cfg *envoyAdmin.ConfigDump) (*ParsedConfig, error) {
+	out := &ParsedConfig{}
+
+	bootstrapDump := &envoyAdmin.BootstrapConfigDump{}
+	if err := unmarshalSection(cfg, bootstrapDump); err != nil {
+		return nil, err
+	}
+	out.Bootstrap = bootstrapDump.GetBootstrap()
+
+	clustersDump := &envoyAdmin.ClustersConfigDump{}
+	if err := unmarshalSection(cfg, clustersDump); err != nil {
+		return nil, err
+	}
+	for _, c := range clustersDump.GetDynamicActiveClusters() {
+		cl := &cluster.Cluster{}
+		if err := c.GetCluster().UnmarshalTo(cl); err != nil {
+			return nil, err
+		}
+		out.Clusters = append(out.Clusters, cl)
+	}
+	for _, c := range clustersDump.GetStaticClusters() {
+		cl := &cluster.Cluster{}
+		if err := c.GetCluster().UnmarshalTo(cl); err != nil {
+			return nil, err
+		}
+		out.Clusters = append(out.Clusters, cl)
+	}
+
+	listenersDump := &envoyAdmin.ListenersConfigDump{}
+	if err := unmarshalSection(cfg, listenersDump); err != nil {
+		return nil, err
+	}
+	for _, l := range listenersDump.GetDynamicListeners() {
+		if l.GetActiveState() == nil {
+			continue
+		}
+		ln := &listener.Listener{}
+		if err := l.GetActiveState().GetListener().UnmarshalTo(ln); err != nil {
+			return nil, err
+		}
+		out.Listeners = append(out.Listeners, ln)
+	}
+	for _, l := range listenersDump.GetStaticListeners() {
+		ln := &listener.Listener{}
+		if err := l.GetListener().UnmarshalTo(ln); err != nil {
+			return nil, err
+		}
+		out.Listeners = append(out.Listeners, ln)
+	}
+
+	routesDump := &envoyAdmin.RoutesConfigDump{}
+	if err := unmarshalSection(cfg, routesDump); err != nil {
+		return nil, err
+	}
+	for _, r := range routesDump.GetDynamicRouteConfigs() {
+		rc := &route.RouteConfiguration{}
+		if err := r.GetRouteConfig().UnmarshalTo(rc); err != nil {
+			return nil, err
+		}
+		out.Routes = append(out.Routes, rc)
+	}
+	for _, r := range routesDump.GetStaticRouteConfigs() {
+		rc := &route.RouteConfiguration{}
+		if err := r.GetRouteConfig().UnmarshalTo(rc); err != nil {
+			return nil, err
+		}
+		out.Routes = append(out.Routes, rc)
+	}
+
+	endpointsDump := &envoyAdmin.EndpointsConfigDump{}
+	if err := unmarshalSection(cfg, endpointsDump); err != nil {
+		return nil, err
+	}
+	for _, c := range endpointsDump.GetDynamicEndpointConfigs() {
+		cla := &endpoint.ClusterLoadAssignment{}
+		if err := c.GetEndpointConfig().UnmarshalTo(cla); err != nil {
+			return nil, err
+		}
+		out.Endpoints = append(out.Endpoints, cla)
+	}
+	for _, c := range endpointsDump.GetStaticEndpointConfigs() {
+		cla := &endpoint.ClusterLoadAssignment{}
+		if err := c.GetEndpointConfig().UnmarshalTo(cla); err != nil {
+			return nil, err
+		}
+		out.Endpoints = append(out.Endpoints, cla)
+	}
+
+	secretsDump := &envoyAdmin.SecretsConfigDump{}
+	if err := unmarshalSection(cfg, secretsDump); err != nil {
+		return nil, err
+	}
+	for _, s := range secretsDump.GetDynamicActiveSecrets() {
+		if s.GetSecret() == nil {
+			// The secret is not yet available.
+			continue
+		}
+		secret := &tls.Secret{}
+		if err := s.GetSecret().UnmarshalTo(secret); err != nil {
+			return nil, err
+		}
+		out.Secrets = append(out.Secrets, secret)
+	}
+	for _, s := range secretsDump.GetStaticSecrets() {
+		secret := &tls.Secret{}
+		if err := s.GetSecret().UnmarshalTo(secret); err != nil {
+			return nil, err
+		}
+		out.Secrets = append(out.Secrets, secret)
+	}
+	return out, nil
+}
+
+// unmarshalSection finds the section of the config dump holding the type of out and unmarshals it into out. out is
+// left empty if the config dump has no such section.
+func unmarshalSection(cfg *envoyAdmin.ConfigDump, out proto.Message) error {
+	for _, c := range cfg.GetConfigs() {
+		if c.MessageIs(out) {
+			return c.UnmarshalTo(out)
+		}
+	}
+	return nil
+}
+
+// Cluster returns the cluster with the given name.
+func (p *ParsedConfig) Cluster(name string) (*cluster.Cluster, error) {
+	for _, c := range p.Clusters {
+		if c.GetName() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("cluster %s not found", name)
+}
+
+// Listener returns the listener with the given name.
+func (p *ParsedConfig) Listener(name string) (*listener.Listener, error) {
+	for _, l := range p.Listeners {
+		if l.GetName() == name {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("listener %s not found", name)
+}
+
+// LoadAssignment returns the endpoints of the given cluster.
+func (p *ParsedConfig) LoadAssignment(clusterName string) (*endpoint.ClusterLoadAssignment, error) {
+	for _, cla := range p.Endpoints {
+		if cla.GetClusterName() == clusterName {
+			return cla, nil
+		}
+	}
+	return nil, fmt.Errorf("no endpoints found for cluster %s", clusterName)
+}
+
+// RouteConfig returns the route configuration with the given name.
+func (p *ParsedConfig) RouteConfig(name string) (*route.RouteConfiguration, error) {
+	for _, rc := range p.Routes {
+		if rc.GetName() == name {
+			return rc, nil
+		}
+	}
+	return nil, fmt.Errorf("route config %s not found", name)
+}
+
+// VirtualHost returns the given virtual host of the route configuration.
+func (p *ParsedConfig) VirtualHost(routeConfig, vhost string) (*route.VirtualHost, error) {
+	rc, err := p.RouteConfig(routeConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, vh := range rc.GetVirtualHosts() {
+		if vh.GetName() == vhost {
+			return vh, nil
+		}
+	}
+	return nil, fmt.Errorf("virtual host %s not found in route config %s", vhost, routeConfig)
+}
+
+// Secret returns the secret with the given name.
+func (p *ParsedConfig) Secret(name string) (*tls.Secret, error) {
+	for _, s := range p.Secrets {
+		if s.GetName() == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("secret %s not found", name)
+}
+
+// HasEndpointCount returns a ParsedAcceptFunc that accepts the config once the given cluster has exactly
+// count endpoints. A missing cluster or a different number of endpoints is retried.
+func HasEndpointCount(clusterName string, count int) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		if _, err := countedEndpoints(cfg, clusterName, count); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+}
+
+// HasClusterCount returns a ParsedAcceptFunc that accepts the config once the CDS section of the config dump
+// holds exactly count clusters, static and dynamic. A different number of clusters is reported and retried.
+func HasClusterCount(count int) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		if actual := len(cfg.Clusters); actual != count {
+			return false, fmt.Errorf("expected %d clusters, found %d", count, actual)
+		}
+		return true, nil
+	}
+}
+
+// EndpointCountEvidence returns a ConfigEvidenceFunc that accepts the config dump when the given cluster has
+// exactly count endpoints. The evidence holds the cluster name and the addresses of its endpoints.
+func EndpointCountEvidence(clusterName string, count int) ConfigEvidenceFunc {
+	return func(cfg *envoyAdmin.ConfigDump) (*MatchEvidence, bool, error) {
+		parsed, err := Parse(cfg)
+		if err != nil {
+			return nil, false, err
+		}
+		endpoints, err := countedEndpoints(parsed, clusterName, count)
+		if err != nil {
+			return nil, false, err
+		}
+		return &MatchEvidence{Cluster: clusterName, Endpoints: endpoints}, true, nil
+	}
+}
+
+// countedEndpoints returns the addresses of the endpoints in the load assignment of the given cluster, failing
+// unless there are exactly count of them.
+func countedEndpoints(cfg *ParsedConfig, clusterName string, count int) ([]string, error) {
+	cla, err := cfg.LoadAssignment(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []string
+	for _, group := range cla.GetEndpoints() {
+		for _, ep := range group.GetLbEndpoints() {
+			sa := ep.GetEndpoint().GetAddress().GetSocketAddress()
+			endpoints = append(endpoints, net.JoinHostPort(sa.GetAddress(), strconv.Itoa(int(sa.GetPortValue()))))
+		}
+	}
+	if actual := len(endpoints); actual != count {
+		return nil, fmt.Errorf("expected %d endpoints for cluster %s, found %d", count, clusterName, actual)
+	}
+	return endpoints, nil
+}
+
+// HasEndpointMetadata returns a ParsedAcceptFunc that accepts the config once the endpoint with the given IP in
+// the load assignment of the cluster carries key with the given value in one of the namespaces of its filter
+// metadata, e.g. "istio". A missing endpoint, or a missing or differing value, is reported and retried.
+func HasEndpointMetadata(clusterName, ip, key, value string) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		cla, err := cfg.LoadAssignment(clusterName)
+		if err != nil {
+			return false, err
+		}
+		var found []string
+		matched := false
+		for _, group := range cla.GetEndpoints() {
+			for _, ep := range group.GetLbEndpoints() {
+				if ep.GetEndpoint().GetAddress().GetSocketAddress().GetAddress() != ip {
+					continue
+				}
+				matched = true
+				for namespace, metadata := range ep.GetMetadata().GetFilterMetadata() {
+					field, ok := metadata.GetFields()[key]
+					if !ok {
+						continue
+					}
+					if field.GetStringValue() == value {
+						return true, nil
+					}
+					found = append(found, fmt.Sprintf("%s.%s=%q", namespace, key, field.GetStringValue()))
+				}
+			}
+		}
+		if !matched {
+			return false, fmt.Errorf("cluster %s has no endpoint %s", clusterName, ip)
+		}
+		if len(found) == 0 {
+			return false, fmt.Errorf("endpoint %s of cluster %s has no metadata %s, want %q", ip, clusterName, key, value)
+		}
+		sort.Strings(found)
+		return false, fmt.Errorf("endpoint %s of cluster %s has metadata %s, want %q",
+			ip, clusterName, strings.Join(found, ", "), value)
+	}
+}
+
+// HasOutlierDetection returns a ParsedAcceptFunc that evaluates the outlier detection settings of the
+// given cluster with the predicate. A missing cluster or missing outlier detection is retried.
+func HasOutlierDetection(clusterName string, predicate func(*cluster.OutlierDetection) bool) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		c, err := cfg.Cluster(clusterName)
+		if err != nil {
+			return false, err
+		}
+		od := c.GetOutlierDetection()
+		if od == nil {
+			return false, fmt.Errorf("cluster %s has no outlier detection", clusterName)
+		}
+		return predicate(od), nil
+	}
+}
+
+// HasCircuitBreaker returns a ParsedAcceptFunc that evaluates the circuit breaker thresholds of the
+// given cluster with the predicate. A missing cluster or missing circuit breakers is retried.
+func HasCircuitBreaker(clusterName string, predicate func(*cluster.CircuitBreakers) bool) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		c, err := cfg.Cluster(clusterName)
+		if err != nil {
+			return false, err
+		}
+		cb := c.GetCircuitBreakers()
+		if cb == nil {
+			return false, fmt.Errorf("cluster %s has no circuit breakers", clusterName)
+		}
+		return predicate(cb), nil
+	}
+}
+
+// HasClusterDiscoveryType returns a ParsedAcceptFunc that accepts the config once the given cluster has the
+// discovery type dtype, e.g. "EDS" for a dynamically discovered cluster or "STATIC". Clusters with a custom
+// cluster type are matched by the name of the extension. A missing cluster or a different type is retried.
+func HasClusterDiscoveryType(name string, dtype string) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		c, err := cfg.Cluster(name)
+		if err != nil {
+			return false, err
+		}
+		actual := c.GetType().String()
+		if custom := c.GetClusterType(); custom != nil {
+			actual = custom.GetName()
+		}
+		if actual != dtype {
+			return false, fmt.Errorf("expected cluster %s to have discovery type %s, found %s", name, dtype, actual)
+		}
+		return true, nil
+	}
+}
+
+// HasTypedExtensionProtocolOptions returns a ParsedAcceptFunc that accepts the config once the typed extension
+// protocol options of the given cluster hold options of the type URL, e.g.
+// "type.googleapis.com/envoy.extensions.upstreams.http.v3.HttpProtocolOptions". A missing cluster, or missing
+// options, is reported and retried.
+func HasTypedExtensionProtocolOptions(clusterName, typeURL string) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		c, err := cfg.Cluster(clusterName)
+		if err != nil {
+			return false, err
+		}
+		var found []string
+		for _, options := range c.GetTypedExtensionProtocolOptions() {
+			if options.GetTypeUrl() == typeURL {
+				return true, nil
+			}
+			found = append(found, options.GetTypeUrl())
+		}
+		if len(found) == 0 {
+			return false, fmt.Errorf("cluster %s has no typed extension protocol options, want %s", clusterName, typeURL)
+		}
+		sort.Strings(found)
+		return false, fmt.Errorf("cluster %s has typed extension protocol options %s, want %s",
+			clusterName, strings.Join(found, ", "), typeURL)
+	}
+}
+
+// HasNodeLabels returns a ParsedAcceptFunc that accepts the config once the LABELS in the node metadata of
+// the bootstrap contain all of the given labels. Missing or differing labels are reported and retried.
+func HasNodeLabels(labels map[string]string) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		actual := cfg.Bootstrap.GetNode().GetMetadata().GetFields()["LABELS"].GetStructValue().GetFields()
+
+		var diffs []string
+		for key, want := range labels {
+			got, found := actual[key]
+			switch {
+			case !found:
+				diffs = append(diffs, fmt.Sprintf("%s: missing, want %q", key, want))
+			case got.GetStringValue() != want:
+				diffs = append(diffs, fmt.Sprintf("%s: got %q, want %q", key, got.GetStringValue(), want))
+			}
+		}
+		if len(diffs) > 0 {
+			sort.Strings(diffs)
+			return false, fmt.Errorf("node labels mismatch: %s", strings.Join(diffs, "; "))
+		}
+		return true, nil
+	}
+}
+
+// HasListenerAddress returns a ParsedAcceptFunc that accepts the config once the given listener is bound to
+// address:port. A missing listener or a different socket address is reported and retried.
+func HasListenerAddress(name, address string, port uint32) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		l, err := cfg.Listener(name)
+		if err != nil {
+			return false, err
+		}
+		sa := l.GetAddress().GetSocketAddress()
+		if sa == nil {
+			return false, fmt.Errorf("listener %s has no socket address", name)
+		}
+		if sa.GetAddress() != address || sa.GetPortValue() != port {
+			return false, fmt.Errorf("listener %s is bound to %s:%d, want %s:%d",
+				name, sa.GetAddress(), sa.GetPortValue(), address, port)
+		}
+		return true, nil
+	}
+}
+
+// HasRequestHeaderAdd returns a ParsedAcceptFunc that accepts the config once the given virtual host of the
+// route config adds the request header with the given value, either on the virtual host itself or on one of
+// its routes. A missing route config or virtual host, or a missing or differing header, is reported and retried.
+func HasRequestHeaderAdd(routeConfig, vhost, header, value string) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		vh, err := cfg.VirtualHost(routeConfig, vhost)
+		if err != nil {
+			return false, err
+		}
+
+		headers := append([]*core.HeaderValueOption{}, vh.GetRequestHeadersToAdd()...)
+		for _, r := range vh.GetRoutes() {
+			headers = append(headers, r.GetRequestHeadersToAdd()...)
+		}
+		var found []string
+		for _, h := range headers {
+			if !strings.EqualFold(h.GetHeader().GetKey(), header) {
+				continue
+			}
+			if h.GetHeader().GetValue() == value {
+				return true, nil
+			}
+			found = append(found, fmt.Sprintf("%q", h.GetHeader().GetValue()))
+		}
+		if len(found) == 0 {
+			return false, fmt.Errorf("virtual host %s of route config %s does not add request header %s", vhost, routeConfig, header)
+		}
+		return false, fmt.Errorf("virtual host %s of route config %s adds request header %s with %s, want %q",
+			vhost, routeConfig, header, strings.Join(found, ", "), value)
+	}
+}
+
+// HasRetryPolicy returns a ParsedAcceptFunc that evaluates the retry policies of the routes of the given virtual
+// host with the predicate. Routes without a retry policy of their own use the policy of the virtual host. The config
+// is accepted if the predicate holds for all of the policies. A missing route config or virtual host, or the absence
+// of any retry policy, is reported and retried.
+func HasRetryPolicy(routeConfig, vhost string, predicate func(*route.RetryPolicy) bool) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		vh, err := cfg.VirtualHost(routeConfig, vhost)
+		if err != nil {
+			return false, err
+		}
+
+		var policies []*route.RetryPolicy
+		for _, r := range vh.GetRoutes() {
+			p := r.GetRoute().GetRetryPolicy()
+			if p == nil {
+				p = vh.GetRetryPolicy()
+			}
+			if p != nil {
+				policies = append(policies, p)
+			}
+		}
+		if len(vh.GetRoutes()) == 0 && vh.GetRetryPolicy() != nil {
+			policies = append(policies, vh.GetRetryPolicy())
+		}
+		if len(policies) == 0 {
+			return false, fmt.Errorf("virtual host %s of route config %s has no retry policy", vhost, routeConfig)
+		}
+		for _, p := range policies {
+			if !predicate(p) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
+
+// NoDanglingClusterRefs returns a ParsedAcceptFunc that accepts the config once every cluster targeted by the
+// routes of the RDS section exists in the CDS section. Routes referencing missing clusters are reported and
+// retried, since they are typically left behind while a deleted config is being removed.
+func NoDanglingClusterRefs() ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		existing := make(map[string]bool)
+		for _, c := range cfg.Clusters {
+			existing[c.GetName()] = true
+		}
+
+		var dangling []string
+		for _, rc := range cfg.Routes {
+			for _, vh := range rc.GetVirtualHosts() {
+				for _, r := range vh.GetRoutes() {
+					targets := []string{r.GetRoute().GetCluster()}
+					for _, wc := range r.GetRoute().GetWeightedClusters().GetClusters() {
+						targets = append(targets, wc.GetName())
+					}
+					for _, target := range targets {
+						if target != "" && !existing[target] {
+							dangling = append(dangling, fmt.Sprintf("%s/%s/%s -> %s", rc.GetName(), vh.GetName(), r.GetName(), target))
+						}
+					}
+				}
+			}
+		}
+		if len(dangling) > 0 {
+			sort.Strings(dangling)
+			return false, fmt.Errorf("routes reference missing clusters: %s", strings.Join(dangling, "; "))
+		}
+		return true, nil
+	}
+}
+
+// HasTCPProxyCluster returns a ParsedAcceptFunc that accepts the config once a tcp_proxy filter of the given
+// listener targets the cluster, either directly or as one of its weighted clusters. A missing listener or
+// tcp_proxy filter, or a different cluster, is reported and retried.
+func HasTCPProxyCluster(listenerName, clusterName string) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		l, err := cfg.Listener(listenerName)
+		if err != nil {
+			return false, err
+		}
+		chains := l.GetFilterChains()
+		if l.GetDefaultFilterChain() != nil {
+			chains = append(chains, l.GetDefaultFilterChain())
+		}
+
+		var found []string
+		for _, fc := range chains {
+			for _, f := range fc.GetFilters() {
+				proxy := &tcp.TcpProxy{}
+				if f.GetTypedConfig() == nil || !f.GetTypedConfig().MessageIs(proxy) {
+					continue
+				}
+				if err := f.GetTypedConfig().UnmarshalTo(proxy); err != nil {
+					return false, err
+				}
+				targets := []string{proxy.GetCluster()}
+				for _, wc := range proxy.GetWeightedClusters().GetClusters() {
+					targets = append(targets, wc.GetName())
+				}
+				for _, target := range targets {
+					if target == "" {
+						continue
+					}
+					if target == clusterName {
+						return true, nil
+					}
+					found = append(found, target)
+				}
+			}
+		}
+		if len(found) == 0 {
+			return false, fmt.Errorf("listener %s has no tcp_proxy filter with a cluster", listenerName)
+		}
+		return false, fmt.Errorf("listener %s proxies to clusters %s, want %s", listenerName, strings.Join(found, ", "), clusterName)
+	}
+}
+
+// HasSNIFilterChain returns a ParsedAcceptFunc that accepts the config once one of the filter chains of the given
+// listener matches the SNI, i.e. its filter chain match lists sni in its server names. A missing listener, or
+// filter chains that only match other server names, is reported and retried.
+func HasSNIFilterChain(listenerName, sni string) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		l, err := cfg.Listener(listenerName)
+		if err != nil {
+			return false, err
+		}
+		var found []string
+		for _, fc := range l.GetFilterChains() {
+			for _, name := range fc.GetFilterChainMatch().GetServerNames() {
+				if name == sni {
+					return true, nil
+				}
+				found = append(found, name)
+			}
+		}
+		if len(found) == 0 {
+			return false, fmt.Errorf("listener %s has no filter chain matching server names", listenerName)
+		}
+		return false, fmt.Errorf("listener %s has filter chains for server names %s, want %s",
+			listenerName, strings.Join(found, ", "), sni)
+	}
+}
+
+// HasFilterChainCount returns a ParsedAcceptFunc that accepts the config once the given listener has exactly count
+// filter chains, which catches filter chains of multi-protocol listeners being dropped or duplicated. A missing
+// listener, or a different number of filter chains, is reported and retried.
+func HasFilterChainCount(listenerName string, count int) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		l, err := cfg.Listener(listenerName)
+		if err != nil {
+			return false, err
+		}
+		if got := len(l.GetFilterChains()); got != count {
+			return false, fmt.Errorf("listener %s has %d filter chains, want %d", listenerName, got, count)
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,608 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"strings"
+	"testing"
+
+	envoyAdmin "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	bootstrap "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	tcp "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	http "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func clustersDump(t *testing.T, clusters ...*cluster.Cluster) *envoyAdmin.ClustersConfigDump {
+	t.Helper()
+	out := &envoyAdmin.ClustersConfigDump{}
+	for _, c := range clusters {
+		out.DynamicActiveClusters = append(out.DynamicActiveClusters, &envoyAdmin.ClustersConfigDump_DynamicCluster{
+			Cluster: toAny(t, c),
+		})
+	}
+	return out
+}
+
+// checkAccept runs the accept func against the config dump and checks the outcome.
+func checkAccept(t *testing.T, accept ParsedAcceptFunc, cfg *envoyAdmin.ConfigDump, wantAccepted, wantErr bool) {
+	t.Helper()
+	accepted, err := accept.Accept()(cfg)
+	if (err != nil) != wantErr {
+		t.Fatalf("expected error: %v, got: %v", wantErr, err)
+	}
+	if accepted != wantAccepted {
+		t.Fatalf("expected accepted: %v, got: %v", wantAccepted, accepted)
+	}
+}
+
+func TestHasOutlierDetection(t *testing.T) {
+	cfg := configDump(t, clustersDump(t,
+		&cluster.Cluster{
+			Name: "with-od",
+			OutlierDetection: &cluster.OutlierDetection{
+				Consecutive_5Xx: wrapperspb.UInt32(5),
+			},
+		},
+		&cluster.Cluster{Name: "without-od"}))
+
+	fiveErrors := func(od *cluster.OutlierDetection) bool {
+		return od.GetConsecutive_5Xx().GetValue() == 5
+	}
+	tenErrors := func(od *cluster.OutlierDetection) bool {
+		return od.GetConsecutive_5Xx().GetValue() == 10
+	}
+
+	t.Run("match", func(t *testing.T) {
+		checkAccept(t, HasOutlierDetection("with-od", fiveErrors), cfg, true, false)
+	})
+	t.Run("mismatch", func(t *testing.T) {
+		checkAccept(t, HasOutlierDetection("with-od", tenErrors), cfg, false, false)
+	})
+	t.Run("no outlier detection", func(t *testing.T) {
+		checkAccept(t, HasOutlierDetection("without-od", fiveErrors), cfg, false, true)
+	})
+	t.Run("missing cluster", func(t *testing.T) {
+		checkAccept(t, HasOutlierDetection("missing", fiveErrors), cfg, false, true)
+	})
+}
+
+func TestHasCircuitBreaker(t *testing.T) {
+	cfg := configDump(t, clustersDump(t,
+		&cluster.Cluster{
+			Name: "with-cb",
+			CircuitBreakers: &cluster.CircuitBreakers{
+				Thresholds: []*cluster.CircuitBreakers_Thresholds{{
+					MaxConnections: wrapperspb.UInt32(100),
+				}},
+			},
+		},
+		&cluster.Cluster{Name: "without-cb"}))
+
+	maxConnections := func(n uint32) func(*cluster.CircuitBreakers) bool {
+		return func(cb *cluster.CircuitBreakers) bool {
+			for _, th := range cb.GetThresholds() {
+				if th.GetMaxConnections().GetValue() == n {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	t.Run("match", func(t *testing.T) {
+		checkAccept(t, HasCircuitBreaker("with-cb", maxConnections(100)), cfg, true, false)
+	})
+	t.Run("mismatch", func(t *testing.T) {
+		checkAccept(t, HasCircuitBreaker("with-cb", maxConnections(10)), cfg, false, false)
+	})
+	t.Run("no circuit breakers", func(t *testing.T) {
+		checkAccept(t, HasCircuitBreaker("without-cb", maxConnections(100)), cfg, false, true)
+	})
+	t.Run("missing cluster", func(t *testing.T) {
+		checkAccept(t, HasCircuitBreaker("missing", maxConnections(100)), cfg, false, true)
+	})
+}
+
+func TestHasClusterDiscoveryType(t *testing.T) {
+	cfg := configDump(t, clustersDump(t,
+		&cluster.Cluster{
+			Name:                 "outbound|80||a.default.svc.cluster.local",
+			ClusterDiscoveryType: &cluster.Cluster_Type{Type: cluster.Cluster_EDS},
+		},
+		&cluster.Cluster{
+			Name:                 "BlackHoleCluster",
+			ClusterDiscoveryType: &cluster.Cluster_Type{Type: cluster.Cluster_STATIC},
+		}))
+
+	t.Run("eds", func(t *testing.T) {
+		checkAccept(t, HasClusterDiscoveryType("outbound|80||a.default.svc.cluster.local", "EDS"), cfg, true, false)
+	})
+	t.Run("static", func(t *testing.T) {
+		checkAccept(t, HasClusterDiscoveryType("BlackHoleCluster", "STATIC"), cfg, true, false)
+	})
+	t.Run("mismatch", func(t *testing.T) {
+		checkAccept(t, HasClusterDiscoveryType("BlackHoleCluster", "EDS"), cfg, false, true)
+	})
+	t.Run("missing cluster", func(t *testing.T) {
+		checkAccept(t, HasClusterDiscoveryType("missing", "EDS"), cfg, false, true)
+	})
+}
+
+func TestHasTypedExtensionProtocolOptions(t *testing.T) {
+	const httpOptionsType = "type.googleapis.com/envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+	cfg := configDump(t, clustersDump(t,
+		&cluster.Cluster{
+			Name: "outbound|80||a.default.svc.cluster.local",
+			TypedExtensionProtocolOptions: map[string]*anypb.Any{
+				"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": toAny(t, &http.HttpProtocolOptions{}),
+			},
+		},
+		&cluster.Cluster{
+			Name: "outbound|3306||db.default.svc.cluster.local",
+		}))
+
+	t.Run("match", func(t *testing.T) {
+		checkAccept(t, HasTypedExtensionProtocolOptions("outbound|80||a.default.svc.cluster.local", httpOptionsType),
+			cfg, true, false)
+	})
+	t.Run("different type", func(t *testing.T) {
+		checkAccept(t, HasTypedExtensionProtocolOptions("outbound|80||a.default.svc.cluster.local",
+			"type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy"), cfg, false, true)
+	})
+	t.Run("no options", func(t *testing.T) {
+		checkAccept(t, HasTypedExtensionProtocolOptions("outbound|3306||db.default.svc.cluster.local", httpOptionsType),
+			cfg, false, true)
+	})
+	t.Run("missing cluster", func(t *testing.T) {
+		checkAccept(t, HasTypedExtensionProtocolOptions("missing", httpOptionsType), cfg, false, true)
+	})
+}
+
+func TestHasNodeLabels(t *testing.T) {
+	metadata, err := structpb.NewStruct(map[string]interface{}{
+		"LABELS": map[string]interface{}{
+			"app":     "a",
+			"version": "v1",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := configDump(t, &envoyAdmin.BootstrapConfigDump{
+		Bootstrap: &bootstrap.Bootstrap{
+			Node: &core.Node{Metadata: metadata},
+		},
+	})
+
+	t.Run("match", func(t *testing.T) {
+		checkAccept(t, HasNodeLabels(map[string]string{"app": "a", "version": "v1"}), cfg, true, false)
+	})
+	t.Run("different value", func(t *testing.T) {
+		checkAccept(t, HasNodeLabels(map[string]string{"version": "v2"}), cfg, false, true)
+	})
+	t.Run("missing label", func(t *testing.T) {
+		checkAccept(t, HasNodeLabels(map[string]string{"zone": "z"}), cfg, false, true)
+	})
+	t.Run("no bootstrap", func(t *testing.T) {
+		checkAccept(t, HasNodeLabels(map[string]string{"app": "a"}), configDump(t), false, true)
+	})
+}
+
+func TestHasListenerAddress(t *testing.T) {
+	l := &listener.Listener{
+		Name: "0.0.0.0_8080",
+		Address: &core.Address{
+			Address: &core.Address_SocketAddress{
+				SocketAddress: &core.SocketAddress{
+					Address:       "0.0.0.0",
+					PortSpecifier: &core.SocketAddress_PortValue{PortValue: 8080},
+				},
+			},
+		},
+	}
+	cfg := configDump(t, &envoyAdmin.ListenersConfigDump{
+		DynamicListeners: []*envoyAdmin.ListenersConfigDump_DynamicListener{{
+			Name:        l.Name,
+			ActiveState: &envoyAdmin.ListenersConfigDump_DynamicListenerState{Listener: toAny(t, l)},
+		}},
+	})
+
+	t.Run("match", func(t *testing.T) {
+		checkAccept(t, HasListenerAddress("0.0.0.0_8080", "0.0.0.0", 8080), cfg, true, false)
+	})
+	t.Run("different port", func(t *testing.T) {
+		checkAccept(t, HasListenerAddress("0.0.0.0_8080", "0.0.0.0", 9090), cfg, false, true)
+	})
+	t.Run("different address", func(t *testing.T) {
+		checkAccept(t, HasListenerAddress("0.0.0.0_8080", "127.0.0.1", 8080), cfg, false, true)
+	})
+	t.Run("missing listener", func(t *testing.T) {
+		checkAccept(t, HasListenerAddress("missing", "0.0.0.0", 8080), cfg, false, true)
+	})
+}
+
+func TestHasRequestHeaderAdd(t *testing.T) {
+	headerAdd := func(key, value string) *core.HeaderValueOption {
+		return &core.HeaderValueOption{Header: &core.HeaderValue{Key: key, Value: value}}
+	}
+	rc := &route.RouteConfiguration{
+		Name: "8080",
+		VirtualHosts: []*route.VirtualHost{{
+			Name:                "b.default.svc.cluster.local:8080",
+			RequestHeadersToAdd: []*core.HeaderValueOption{headerAdd("x-vhost", "vhost-value")},
+			Routes: []*route.Route{{
+				Name:                "default",
+				RequestHeadersToAdd: []*core.HeaderValueOption{headerAdd("x-route", "route-value")},
+			}},
+		}},
+	}
+	cfg := configDump(t, &envoyAdmin.RoutesConfigDump{
+		DynamicRouteConfigs: []*envoyAdmin.RoutesConfigDump_DynamicRouteConfig{{
+			RouteConfig: toAny(t, rc),
+		}},
+	})
+	vhost := "b.default.svc.cluster.local:8080"
+
+	t.Run("virtual host header", func(t *testing.T) {
+		checkAccept(t, HasRequestHeaderAdd("8080", vhost, "x-vhost", "vhost-value"), cfg, true, false)
+	})
+	t.Run("route header", func(t *testing.T) {
+		checkAccept(t, HasRequestHeaderAdd("8080", vhost, "X-Route", "route-value"), cfg, true, false)
+	})
+	t.Run("different value", func(t *testing.T) {
+		checkAccept(t, HasRequestHeaderAdd("8080", vhost, "x-route", "other"), cfg, false, true)
+	})
+	t.Run("missing header", func(t *testing.T) {
+		checkAccept(t, HasRequestHeaderAdd("8080", vhost, "x-missing", "value"), cfg, false, true)
+	})
+	t.Run("missing virtual host", func(t *testing.T) {
+		checkAccept(t, HasRequestHeaderAdd("8080", "missing", "x-vhost", "vhost-value"), cfg, false, true)
+	})
+	t.Run("missing route config", func(t *testing.T) {
+		checkAccept(t, HasRequestHeaderAdd("9090", vhost, "x-vhost", "vhost-value"), cfg, false, true)
+	})
+}
+
+func TestHasRetryPolicy(t *testing.T) {
+	rc := &route.RouteConfiguration{
+		Name: "8080",
+		VirtualHosts: []*route.VirtualHost{
+			{
+				Name: "with-retries",
+				Routes: []*route.Route{{
+					Name: "default",
+					Action: &route.Route_Route{Route: &route.RouteAction{
+						RetryPolicy: &route.RetryPolicy{
+							RetryOn:    "connect-failure,refused-stream,503",
+							NumRetries: wrapperspb.UInt32(3),
+						},
+					}},
+				}},
+			},
+			{
+				Name: "without-retries",
+				Routes: []*route.Route{{
+					Name:   "default",
+					Action: &route.Route_Route{Route: &route.RouteAction{}},
+				}},
+			},
+		},
+	}
+	cfg := configDump(t, &envoyAdmin.RoutesConfigDump{
+		DynamicRouteConfigs: []*envoyAdmin.RoutesConfigDump_DynamicRouteConfig{{
+			RouteConfig: toAny(t, rc),
+		}},
+	})
+
+	retries := func(n uint32) func(*route.RetryPolicy) bool {
+		return func(p *route.RetryPolicy) bool {
+			return p.GetNumRetries().GetValue() == n && strings.Contains(p.GetRetryOn(), "503")
+		}
+	}
+
+	t.Run("match", func(t *testing.T) {
+		checkAccept(t, HasRetryPolicy("8080", "with-retries", retries(3)), cfg, true, false)
+	})
+	t.Run("mismatch", func(t *testing.T) {
+		checkAccept(t, HasRetryPolicy("8080", "with-retries", retries(5)), cfg, false, false)
+	})
+	t.Run("no retry policy", func(t *testing.T) {
+		checkAccept(t, HasRetryPolicy("8080", "without-retries", retries(3)), cfg, false, true)
+	})
+	t.Run("missing virtual host", func(t *testing.T) {
+		checkAccept(t, HasRetryPolicy("8080", "missing", retries(3)), cfg, false, true)
+	})
+	t.Run("missing route config", func(t *testing.T) {
+		checkAccept(t, HasRetryPolicy("9090", "with-retries", retries(3)), cfg, false, true)
+	})
+}
+
+func TestNoDanglingClusterRefs(t *testing.T) {
+	routesDump := func(clusters ...string) *envoyAdmin.RoutesConfigDump {
+		vh := &route.VirtualHost{Name: "b.default.svc.cluster.local:8080"}
+		for _, c := range clusters {
+			vh.Routes = append(vh.Routes, &route.Route{
+				Name: c,
+				Action: &route.Route_Route{Route: &route.RouteAction{
+					ClusterSpecifier: &route.RouteAction_Cluster{Cluster: c},
+				}},
+			})
+		}
+		vh.Routes = append(vh.Routes, &route.Route{
+			Name: "split",
+			Action: &route.Route_Route{Route: &route.RouteAction{
+				ClusterSpecifier: &route.RouteAction_WeightedClusters{WeightedClusters: &route.WeightedCluster{
+					Clusters: []*route.WeightedCluster_ClusterWeight{{Name: "outbound|8080|v1|b.default.svc.cluster.local"}},
+				}},
+			}},
+		})
+		return &envoyAdmin.RoutesConfigDump{
+			DynamicRouteConfigs: []*envoyAdmin.RoutesConfigDump_DynamicRouteConfig{{
+				RouteConfig: toAny(t, &route.RouteConfiguration{Name: "8080", VirtualHosts: []*route.VirtualHost{vh}}),
+			}},
+		}
+	}
+	clusters := clustersDump(t,
+		&cluster.Cluster{Name: "outbound|8080||b.default.svc.cluster.local"},
+		&cluster.Cluster{Name: "outbound|8080|v1|b.default.svc.cluster.local"})
+
+	t.Run("all clusters exist", func(t *testing.T) {
+		cfg := configDump(t, clusters, routesDump("outbound|8080||b.default.svc.cluster.local"))
+		checkAccept(t, NoDanglingClusterRefs(), cfg, true, false)
+	})
+	t.Run("dangling reference", func(t *testing.T) {
+		cfg := configDump(t, clusters, routesDump("outbound|8080||b.default.svc.cluster.local", "outbound|8080||deleted.default.svc.cluster.local"))
+		checkAccept(t, NoDanglingClusterRefs(), cfg, false, true)
+	})
+	t.Run("dangling weighted reference", func(t *testing.T) {
+		cfg := configDump(t, clustersDump(t, &cluster.Cluster{Name: "outbound|8080||b.default.svc.cluster.local"}),
+			routesDump("outbound|8080||b.default.svc.cluster.local"))
+		checkAccept(t, NoDanglingClusterRefs(), cfg, false, true)
+	})
+	t.Run("missing routes", func(t *testing.T) {
+		checkAccept(t, NoDanglingClusterRefs(), configDump(t, clusters), false, true)
+	})
+}
+
+func TestHasTCPProxyCluster(t *testing.T) {
+	proxy := &tcp.TcpProxy{
+		StatPrefix:       "outbound|9090||b.default.svc.cluster.local",
+		ClusterSpecifier: &tcp.TcpProxy_Cluster{Cluster: "outbound|9090||b.default.svc.cluster.local"},
+	}
+	l := &listener.Listener{
+		Name: "0.0.0.0_9090",
+		FilterChains: []*listener.FilterChain{{
+			Filters: []*listener.Filter{{
+				Name:       "envoy.filters.network.tcp_proxy",
+				ConfigType: &listener.Filter_TypedConfig{TypedConfig: toAny(t, proxy)},
+			}},
+		}},
+	}
+	empty := &listener.Listener{Name: "0.0.0.0_7070"}
+	cfg := configDump(t, &envoyAdmin.ListenersConfigDump{
+		DynamicListeners: []*envoyAdmin.ListenersConfigDump_DynamicListener{
+			{Name: l.Name, ActiveState: &envoyAdmin.ListenersConfigDump_DynamicListenerState{Listener: toAny(t, l)}},
+			{Name: empty.Name, ActiveState: &envoyAdmin.ListenersConfigDump_DynamicListenerState{Listener: toAny(t, empty)}},
+		},
+	})
+
+	t.Run("match", func(t *testing.T) {
+		checkAccept(t, HasTCPProxyCluster("0.0.0.0_9090", "outbound|9090||b.default.svc.cluster.local"), cfg, true, false)
+	})
+	t.Run("different cluster", func(t *testing.T) {
+		checkAccept(t, HasTCPProxyCluster("0.0.0.0_9090", "outbound|9090||c.default.svc.cluster.local"), cfg, false, true)
+	})
+	t.Run("no tcp_proxy", func(t *testing.T) {
+		checkAccept(t, HasTCPProxyCluster("0.0.0.0_7070", "outbound|9090||b.default.svc.cluster.local"), cfg, false, true)
+	})
+	t.Run("missing listener", func(t *testing.T) {
+		checkAccept(t, HasTCPProxyCluster("missing", "outbound|9090||b.default.svc.cluster.local"), cfg, false, true)
+	})
+}
+
+func TestHasSNIFilterChain(t *testing.T) {
+	l := &listener.Listener{
+		Name: "0.0.0.0_443",
+		FilterChains: []*listener.FilterChain{
+			{FilterChainMatch: &listener.FilterChainMatch{ServerNames: []string{"a.example.com"}}},
+			{FilterChainMatch: &listener.FilterChainMatch{ServerNames: []string{"b.example.com", "*.b.example.com"}}},
+		},
+	}
+	plain := &listener.Listener{
+		Name:         "0.0.0.0_8080",
+		FilterChains: []*listener.FilterChain{{}},
+	}
+	cfg := configDump(t, &envoyAdmin.ListenersConfigDump{
+		DynamicListeners: []*envoyAdmin.ListenersConfigDump_DynamicListener{
+			{Name: l.Name, ActiveState: &envoyAdmin.ListenersConfigDump_DynamicListenerState{Listener: toAny(t, l)}},
+			{Name: plain.Name, ActiveState: &envoyAdmin.ListenersConfigDump_DynamicListenerState{Listener: toAny(t, plain)}},
+		},
+	})
+
+	t.Run("match", func(t *testing.T) {
+		checkAccept(t, HasSNIFilterChain("0.0.0.0_443", "b.example.com"), cfg, true, false)
+	})
+	t.Run("different sni", func(t *testing.T) {
+		checkAccept(t, HasSNIFilterChain("0.0.0.0_443", "c.example.com"), cfg, false, true)
+	})
+	t.Run("no sni match", func(t *testing.T) {
+		checkAccept(t, HasSNIFilterChain("0.0.0.0_8080", "a.example.com"), cfg, false, true)
+	})
+	t.Run("missing listener", func(t *testing.T) {
+		checkAccept(t, HasSNIFilterChain("missing", "a.example.com"), cfg, false, true)
+	})
+}
+
+func TestHasFilterChainCount(t *testing.T) {
+	l := &listener.Listener{
+		Name: "0.0.0.0_9000",
+		FilterChains: []*listener.FilterChain{
+			{Name: "http", FilterChainMatch: &listener.FilterChainMatch{ApplicationProtocols: []string{"http/1.1", "h2c"}}},
+			{Name: "tls", FilterChainMatch: &listener.FilterChainMatch{TransportProtocol: "tls"}},
+			{Name: "tcp"},
+		},
+	}
+	cfg := configDump(t, &envoyAdmin.ListenersConfigDump{
+		DynamicListeners: []*envoyAdmin.ListenersConfigDump_DynamicListener{
+			{Name: l.Name, ActiveState: &envoyAdmin.ListenersConfigDump_DynamicListenerState{Listener: toAny(t, l)}},
+		},
+	})
+
+	t.Run("match", func(t *testing.T) {
+		checkAccept(t, HasFilterChainCount("0.0.0.0_9000", 3), cfg, true, false)
+	})
+	t.Run("dropped", func(t *testing.T) {
+		checkAccept(t, HasFilterChainCount("0.0.0.0_9000", 4), cfg, false, true)
+	})
+	t.Run("duplicated", func(t *testing.T) {
+		_, err := HasFilterChainCount("0.0.0.0_9000", 2).Accept()(cfg)
+		if err == nil || !strings.Contains(err.Error(), "has 3 filter chains") {
+			t.Fatalf("expected the actual count to be reported, got %v", err)
+		}
+	})
+	t.Run("missing listener", func(t *testing.T) {
+		checkAccept(t, HasFilterChainCount("missing", 3), cfg, false, true)
+	})
+}
+
+func TestHasEndpointMetadata(t *testing.T) {
+	const clusterName = "outbound|80||b.default.svc.cluster.local"
+	metadata, err := structpb.NewStruct(map[string]interface{}{
+		"cluster": "cluster-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withMetadata := lbEndpoint("10.0.0.1", 8080)
+	withMetadata.Metadata = &core.Metadata{FilterMetadata: map[string]*structpb.Struct{"istio": metadata}}
+	cfg := configDump(t, endpointsDump(t, &endpoint.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints: []*endpoint.LocalityLbEndpoints{{
+			LbEndpoints: []*endpoint.LbEndpoint{withMetadata, lbEndpoint("10.0.0.2", 8080)},
+		}},
+	}))
+
+	t.Run("match", func(t *testing.T) {
+		checkAccept(t, HasEndpointMetadata(clusterName, "10.0.0.1", "cluster", "cluster-1"), cfg, true, false)
+	})
+	t.Run("different value", func(t *testing.T) {
+		_, err := HasEndpointMetadata(clusterName, "10.0.0.1", "cluster", "cluster-2").Accept()(cfg)
+		if err == nil || !strings.Contains(err.Error(), `istio.cluster="cluster-1"`) {
+			t.Fatalf("expected the actual value to be reported, got %v", err)
+		}
+	})
+	t.Run("missing metadata", func(t *testing.T) {
+		checkAccept(t, HasEndpointMetadata(clusterName, "10.0.0.2", "cluster", "cluster-1"), cfg, false, true)
+	})
+	t.Run("missing endpoint", func(t *testing.T) {
+		checkAccept(t, HasEndpointMetadata(clusterName, "10.0.0.3", "cluster", "cluster-1"), cfg, false, true)
+	})
+}
+
+func TestParse(t *testing.T) {
+	secret := &tls.Secret{Name: "default"}
+	cfg := configDump(t,
+		clustersDump(t, &cluster.Cluster{Name: "outbound|80||b.default.svc.cluster.local"}),
+		&envoyAdmin.ListenersConfigDump{
+			DynamicListeners: []*envoyAdmin.ListenersConfigDump_DynamicListener{
+				{
+					Name:        "0.0.0.0_80",
+					ActiveState: &envoyAdmin.ListenersConfigDump_DynamicListenerState{Listener: toAny(t, &listener.Listener{Name: "0.0.0.0_80"})},
+				},
+				// A listener that is only warming is not active.
+				{
+					Name:         "0.0.0.0_90",
+					WarmingState: &envoyAdmin.ListenersConfigDump_DynamicListenerState{Listener: toAny(t, &listener.Listener{Name: "0.0.0.0_90"})},
+				},
+			},
+		},
+		&envoyAdmin.SecretsConfigDump{
+			DynamicActiveSecrets: []*envoyAdmin.SecretsConfigDump_DynamicSecret{{Name: secret.Name, Secret: toAny(t, secret)}},
+		})
+
+	parsed, err := Parse(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsed.Cluster("outbound|80||b.default.svc.cluster.local"); err != nil {
+		t.Error(err)
+	}
+	if _, err := parsed.Listener("0.0.0.0_80"); err != nil {
+		t.Error(err)
+	}
+	if _, err := parsed.Listener("0.0.0.0_90"); err == nil {
+		t.Error("expected the warming listener not to be parsed")
+	}
+	if _, err := parsed.Secret("default"); err != nil {
+		t.Error(err)
+	}
+	// The sections missing from the config dump are empty.
+	if parsed.Bootstrap != nil || len(parsed.Routes) != 0 || len(parsed.Endpoints) != 0 {
+		t.Errorf("expected no bootstrap, routes or endpoints, got %v", parsed)
+	}
+}
+
+func TestAllOf(t *testing.T) {
+	cfg := configDump(t, clustersDump(t,
+		&cluster.Cluster{
+			Name: "with-od",
+			OutlierDetection: &cluster.OutlierDetection{
+				Consecutive_5Xx: wrapperspb.UInt32(5),
+			},
+		},
+		&cluster.Cluster{Name: "without-od"}))
+
+	// Record the parsed configs the funcs of the composite assertion are evaluated with.
+	var evaluated []*ParsedConfig
+	recorded := func(accept ParsedAcceptFunc) ParsedAcceptFunc {
+		return func(parsed *ParsedConfig) (bool, error) {
+			evaluated = append(evaluated, parsed)
+			return accept(parsed)
+		}
+	}
+	anyOutlierDetection := func(*cluster.OutlierDetection) bool { return true }
+
+	checkAccept(t, AllOf(
+		recorded(HasClusterCount(2)),
+		recorded(HasOutlierDetection("with-od", anyOutlierDetection)),
+		recorded(NoDanglingClusterRefs()),
+	), cfg, true, false)
+	if len(evaluated) != 3 {
+		t.Fatalf("expected 3 evaluations, got %d", len(evaluated))
+	}
+	for _, parsed := range evaluated[1:] {
+		if parsed != evaluated[0] {
+			t.Fatal("expected the config dump to be parsed once for all of the assertions")
+		}
+	}
+
+	t.Run("rejected", func(t *testing.T) {
+		checkAccept(t, AllOf(HasClusterCount(2), HasOutlierDetection("with-od", func(*cluster.OutlierDetection) bool {
+			return false
+		})), cfg, false, false)
+	})
+	t.Run("failed", func(t *testing.T) {
+		checkAccept(t, AllOf(HasOutlierDetection("without-od", anyOutlierDetection), HasClusterCount(2)), cfg, false, true)
+	})
+}
@@ -17,10 +17,17 @@ package common
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	envoyAdmin "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
 
 	"istio.io/istio/pkg/test/util/retry"
 	"istio.io/istio/pkg/util/protomarshal"
@@ -37,15 +44,195 @@ const (
 // ConfigFetchFunc retrieves the config dump from Envoy.
 type ConfigFetchFunc func() (*envoyAdmin.ConfigDump, error)
 
+// PilotConfigFetcher returns a ConfigFetchFunc that retrieves the config Istiod computed for the given proxy from
+// the debug/config_dump endpoint of pilot, rather than from the Envoy admin API. pilotAddr is the address of the
+// pilot debug server (e.g. "localhost:15014"), with an optional scheme.
+func PilotConfigFetcher(pilotAddr, proxyID string) ConfigFetchFunc {
+	if !strings.Contains(pilotAddr, "://") {
+		pilotAddr = "http://" + pilotAddr
+	}
+	u := strings.TrimSuffix(pilotAddr, "/") + "/debug/config_dump?proxyID=" + url.QueryEscape(proxyID)
+	client := &http.Client{Timeout: defaultConfigTimeout}
+
+	return func() (*envoyAdmin.ConfigDump, error) {
+		resp, err := client.Get(u)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed fetching pilot config_dump for %s: %s: %s", proxyID, resp.Status, body)
+		}
+
+		out := &envoyAdmin.ConfigDump{}
+		if err := protomarshal.UnmarshalAllowUnknown(body, out); err != nil {
+			return nil, fmt.Errorf("failed parsing pilot config_dump for %s: %v", proxyID, err)
+		}
+		return out, nil
+	}
+}
+
+// CircuitBreakingFetcher wraps a ConfigFetchFunc with a circuit breaker. After failureThreshold consecutive
+// failures the circuit opens and, for the cooldown period, calls fail immediately with the last error instead
+// of reaching the inner fetcher. Once the cooldown elapses a single fetch is attempted, and concurrent calls
+// fail immediately until it completes; a failure reopens the circuit, while a success closes it again.
+func CircuitBreakingFetcher(inner ConfigFetchFunc, failureThreshold int, cooldown time.Duration) ConfigFetchFunc {
+	var mu sync.Mutex
+	var failures int
+	var lastErr error
+	var openUntil time.Time
+	var probing bool
+
+	return func() (*envoyAdmin.ConfigDump, error) {
+		mu.Lock()
+		if remaining := time.Until(openUntil); remaining > 0 {
+			mu.Unlock()
+			return nil, fmt.Errorf("config fetch circuit open after %d consecutive failures, retrying in %v: %v",
+				failures, remaining, lastErr)
+		}
+		if failures >= failureThreshold {
+			if probing {
+				mu.Unlock()
+				return nil, fmt.Errorf("config fetch circuit half-open after %d consecutive failures, probe in flight: %v",
+					failures, lastErr)
+			}
+			probing = true
+		}
+		mu.Unlock()
+
+		cfg, err := inner()
+
+		mu.Lock()
+		defer mu.Unlock()
+		probing = false
+		if err != nil {
+			failures++
+			lastErr = err
+			if failures >= failureThreshold {
+				openUntil = time.Now().Add(cooldown)
+			}
+			return nil, err
+		}
+		failures = 0
+		lastErr = nil
+		openUntil = time.Time{}
+		return cfg, nil
+	}
+}
+
 // ConfigAcceptFunc evaluates the Envoy config dump and either accept/reject it. This is used
 // by WaitForConfig to control the retry loop. If an error is returned, a retry will be attempted.
 // Otherwise the loop is immediately terminated with an error if rejected or none if accepted.
 type ConfigAcceptFunc func(*envoyAdmin.ConfigDump) (bool, error)
 
+// MatchEvidence describes what in an Envoy config dump satisfied a ConfigEvidenceFunc, so that tests can log
+// exactly what their assertion matched.
+type MatchEvidence struct {
+	// Cluster is the name of the matched cluster, if any.
+	Cluster string
+	// Endpoints are the addresses of the matched endpoints, if any.
+	Endpoints []string
+	// Details is a free-form description of the match.
+	Details string
+}
+
+func (e *MatchEvidence) String() string {
+	if e == nil {
+		return "<none>"
+	}
+	var parts []string
+	if e.Cluster != "" {
+		parts = append(parts, "cluster="+e.Cluster)
+	}
+	if len(e.Endpoints) > 0 {
+		parts = append(parts, "endpoints="+strings.Join(e.Endpoints, ","))
+	}
+	if e.Details != "" {
+		parts = append(parts, e.Details)
+	}
+	return strings.Join(parts, " ")
+}
+
+// ConfigEvidenceFunc is a ConfigAcceptFunc that also returns evidence of what it matched when it accepts
+// the config dump. It is used by WaitForConfigWithEvidence.
+type ConfigEvidenceFunc func(*envoyAdmin.ConfigDump) (*MatchEvidence, bool, error)
+
+// withoutEvidence returns a ConfigEvidenceFunc that accepts the same config dumps as f, with no evidence.
+func (f ConfigAcceptFunc) withoutEvidence() ConfigEvidenceFunc {
+	return func(cfg *envoyAdmin.ConfigDump) (*MatchEvidence, bool, error) {
+		accepted, err := f(cfg)
+		return nil, accepted, err
+	}
+}
+
+// Accept returns a ConfigAcceptFunc that accepts the same config dumps as f, discarding the evidence.
+func (f ConfigEvidenceFunc) Accept() ConfigAcceptFunc {
+	return func(cfg *envoyAdmin.ConfigDump) (bool, error) {
+		_, accepted, err := f(cfg)
+		return accepted, err
+	}
+}
+
+// ParsedAcceptFunc is a ConfigAcceptFunc evaluating a config dump parsed by Parse. Unlike ConfigAcceptFuncs, several
+// of them can be combined with AllOf into a single assertion that parses the config dump once.
+type ParsedAcceptFunc func(*ParsedConfig) (bool, error)
+
+// Accept returns a ConfigAcceptFunc that parses the config dump and evaluates it with f. A config dump that cannot
+// be parsed is retried.
+func (f ParsedAcceptFunc) Accept() ConfigAcceptFunc {
+	return func(cfg *envoyAdmin.ConfigDump) (bool, error) {
+		parsed, err := Parse(cfg)
+		if err != nil {
+			return false, err
+		}
+		return f(parsed)
+	}
+}
+
+// AllOf returns a ParsedAcceptFunc that accepts the config once all of the given funcs accept it. The funcs are
+// evaluated in order against the same ParsedConfig, stopping at the first that rejects the config or fails.
+func AllOf(accepts ...ParsedAcceptFunc) ParsedAcceptFunc {
+	return func(cfg *ParsedConfig) (bool, error) {
+		for _, accept := range accepts {
+			if accepted, err := accept(cfg); err != nil || !accepted {
+				return accepted, err
+			}
+		}
+		return true, nil
+	}
+}
+
 func WaitForConfig(fetch ConfigFetchFunc, accept ConfigAcceptFunc, options ...retry.Option) error {
+	_, err := waitForConfig(fetch, accept.withoutEvidence(), nil, options)
+	return err
+}
+
+// WaitForConfigComparing behaves like WaitForConfig, but if the wait fails, whether the last config dump fetched was
+// rejected or failed to be evaluated, the error includes a diff between that config dump and prev. This shows what
+// changed since the baseline, or what is still missing from it.
+func WaitForConfigComparing(fetch ConfigFetchFunc, accept ConfigAcceptFunc, prev *envoyAdmin.ConfigDump,
+	options ...retry.Option) error {
+	_, err := waitForConfig(fetch, accept.withoutEvidence(), prev, options)
+	return err
+}
+
+// WaitForConfigWithEvidence behaves like WaitForConfig, but returns the evidence of the accepted config dump.
+func WaitForConfigWithEvidence(fetch ConfigFetchFunc, accept ConfigEvidenceFunc, options ...retry.Option) (*MatchEvidence, error) {
+	return waitForConfig(fetch, accept, nil, options)
+}
+
+// waitForConfig implements WaitForConfigWithEvidence, including a diff against prev in the error, if not nil.
+func waitForConfig(fetch ConfigFetchFunc, accept ConfigEvidenceFunc, prev *envoyAdmin.ConfigDump,
+	options []retry.Option) (*MatchEvidence, error) {
 	options = append([]retry.Option{retry.BackoffDelay(defaultConfigDelay), retry.Timeout(defaultConfigTimeout)}, options...)
 
 	var cfg *envoyAdmin.ConfigDump
+	var evidence *MatchEvidence
 	_, err := retry.Do(func() (result interface{}, completed bool, err error) {
 		cfg, err = fetch()
 		if err != nil {
@@ -62,7 +249,8 @@ func WaitForConfig(fetch ConfigFetchFunc, accept ConfigAcceptFunc, options ...re
 			return nil, false, err
 		}
 
-		accepted, err := accept(cfg)
+		var accepted bool
+		evidence, accepted, err = accept(cfg)
 		if err != nil {
 			// Accept returned an error - retry.
 			return nil, false, err
@@ -77,15 +265,109 @@ func WaitForConfig(fetch ConfigFetchFunc, accept ConfigAcceptFunc, options ...re
 		return nil, true, errors.New("envoy config rejected")
 	}, options...)
 	if err != nil {
-		configDumpStr := "nil"
-		if cfg != nil {
-			b, err := protomarshal.MarshalIndent(cfg, "  ")
+		return nil, configWaitError(err, cfg, prev)
+	}
+	return evidence, nil
+}
+
+// WaitForConfigStable waits for the config to be accepted and then to remain accepted for the stableFor
+// window, returning the final config dump. Unlike WaitForConfig, a rejection does not terminate the wait:
+// any failure to accept, including a rejection after the config was accepted, restarts the window.
+func WaitForConfigStable(fetch ConfigFetchFunc, accept ConfigAcceptFunc, stableFor time.Duration,
+	options ...retry.Option) (*envoyAdmin.ConfigDump, error) {
+	options = append([]retry.Option{retry.BackoffDelay(defaultConfigDelay), retry.Timeout(defaultConfigTimeout + stableFor)}, options...)
+
+	var cfg *envoyAdmin.ConfigDump
+	var acceptedSince time.Time
+	_, err := retry.Do(func() (result interface{}, completed bool, err error) {
+		cfg, err = fetch()
+		if err != nil {
+			acceptedSince = time.Time{}
+			return nil, false, err
+		}
+
+		accepted, err := accept(cfg)
+		if err != nil || !accepted {
+			acceptedSince = time.Time{}
 			if err == nil {
-				configDumpStr = string(b)
+				err = errors.New("envoy config rejected")
 			}
+			return nil, false, err
 		}
 
-		return fmt.Errorf("failed waiting for Envoy configuration: %v. Last config_dump:\n%s", err, configDumpStr)
+		if acceptedSince.IsZero() {
+			acceptedSince = time.Now()
+		}
+		if stable := time.Since(acceptedSince); stable < stableFor {
+			return nil, false, fmt.Errorf("envoy config accepted for %v, waiting for %v", stable, stableFor)
+		}
+		return nil, true, nil
+	}, options...)
+	if err != nil {
+		return nil, configWaitError(err, cfg, nil)
+	}
+	return cfg, nil
+}
+
+// configWaitError returns an error for a failed wait for the config, including the last config dump and, if prev is
+// not nil, its diff against prev.
+func configWaitError(err error, cfg, prev *envoyAdmin.ConfigDump) error {
+	configDumpStr := "nil"
+	if cfg != nil {
+		b, err := protomarshal.MarshalIndent(cfg, "  ")
+		if err == nil {
+			configDumpStr = string(b)
+		}
+	}
+
+	if prev != nil {
+		diff := cmp.Diff(prev, cfg, protocmp.Transform())
+		if diff == "" {
+			err = fmt.Errorf("%w (config_dump unchanged from previous)", err)
+		} else {
+			err = fmt.Errorf("%w. Diff against previous config_dump (-previous +current):\n%s", err, diff)
+		}
 	}
-	return nil
+	return fmt.Errorf("failed waiting for Envoy configuration: %v. Last config_dump:\n%s", err, configDumpStr)
+}
+
+// WaitForDeploymentConfig waits for the config of all of the proxies of a deployment, fetched by fetchers, to be
+// accepted by the deadline. The proxies are waited for concurrently. If any of them doesn't converge by the
+// deadline, the returned error reports these laggards, by their index in fetchers, along with the reason.
+func WaitForDeploymentConfig(fetchers []ConfigFetchFunc, accept ConfigAcceptFunc, deadline time.Time) error {
+	errs := make([]error, len(fetchers))
+	wg := sync.WaitGroup{}
+	for i, fetch := range fetchers {
+		wg.Add(1)
+		go func(i int, fetch ConfigFetchFunc) {
+			defer wg.Done()
+			errs[i] = WaitForConfig(fetch, accept, retry.Timeout(time.Until(deadline)))
+		}(i, fetch)
+	}
+	wg.Wait()
+
+	var laggards []string
+	var reasons []string
+	for i, err := range errs {
+		if err != nil {
+			laggards = append(laggards, strconv.Itoa(i))
+			reasons = append(reasons, fmt.Sprintf("proxy %d: %v", i, err))
+		}
+	}
+	if len(laggards) == 0 {
+		return nil
+	}
+	return fmt.Errorf("proxies %s of %d did not converge by %s:\n%s",
+		strings.Join(laggards, ", "), len(fetchers), deadline.Format(time.RFC3339), strings.Join(reasons, "\n"))
+}
+
+// WaitForEndpointCount waits for the given cluster to have exactly count endpoints.
+func WaitForEndpointCount(fetch ConfigFetchFunc, clusterName string, count int, options ...retry.Option) error {
+	return WaitForConfig(fetch, HasEndpointCount(clusterName, count).Accept(), options...)
+}
+
+// WaitForClusterCount waits for the config to have exactly count clusters, e.g. once the services of a scaled
+// deployment are all discovered.
+func WaitForClusterCount(fetch ConfigFetchFunc, count int, options ...retry.Option) error {
+	return WaitForConfig(fetch, HasClusterCount(count).Accept(), options...)
 }
@@ -0,0 +1,419 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	envoyAdmin "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"istio.io/istio/pkg/test/util/retry"
+	"istio.io/istio/pkg/util/protomarshal"
+)
+
+func toAny(t *testing.T, msg proto.Message) *anypb.Any {
+	t.Helper()
+	out, err := anypb.New(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func configDump(t *testing.T, sections ...proto.Message) *envoyAdmin.ConfigDump {
+	t.Helper()
+	out := &envoyAdmin.ConfigDump{}
+	for _, section := range sections {
+		out.Configs = append(out.Configs, toAny(t, section))
+	}
+	return out
+}
+
+func lbEndpoint(ip string, port uint32) *endpoint.LbEndpoint {
+	return &endpoint.LbEndpoint{
+		HostIdentifier: &endpoint.LbEndpoint_Endpoint{
+			Endpoint: &endpoint.Endpoint{
+				Address: &core.Address{
+					Address: &core.Address_SocketAddress{
+						SocketAddress: &core.SocketAddress{
+							Address:       ip,
+							PortSpecifier: &core.SocketAddress_PortValue{PortValue: port},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func endpointsDump(t *testing.T, assignments ...*endpoint.ClusterLoadAssignment) *envoyAdmin.EndpointsConfigDump {
+	t.Helper()
+	out := &envoyAdmin.EndpointsConfigDump{}
+	for _, cla := range assignments {
+		out.DynamicEndpointConfigs = append(out.DynamicEndpointConfigs, &envoyAdmin.EndpointsConfigDump_DynamicEndpointConfig{
+			EndpointConfig: toAny(t, cla),
+		})
+	}
+	return out
+}
+
+// testClusterName is the cluster whose endpoints the config dumps of endpointsConfigDump hold.
+const testClusterName = "outbound|80||b.default.svc.cluster.local"
+
+// endpointsConfigDump returns a config dump holding the load assignment of testClusterName, with an endpoint on
+// port 8080 for each of the given IPs.
+func endpointsConfigDump(t *testing.T, ips ...string) *envoyAdmin.ConfigDump {
+	t.Helper()
+	group := &endpoint.LocalityLbEndpoints{}
+	for _, ip := range ips {
+		group.LbEndpoints = append(group.LbEndpoints, lbEndpoint(ip, 8080))
+	}
+	cla := &endpoint.ClusterLoadAssignment{ClusterName: testClusterName, Endpoints: []*endpoint.LocalityLbEndpoints{group}}
+	return configDump(t, endpointsDump(t, cla))
+}
+
+func TestWaitForEndpointCount(t *testing.T) {
+	ips := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+	// Each fetch returns one more endpoint than the previous one.
+	fetches := 0
+	fetch := func() (*envoyAdmin.ConfigDump, error) {
+		fetches++
+		return endpointsConfigDump(t, ips[:fetches]...), nil
+	}
+
+	if err := WaitForEndpointCount(fetch, testClusterName, len(ips), retry.Delay(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != len(ips) {
+		t.Fatalf("expected %d fetches, got %d", len(ips), fetches)
+	}
+}
+
+func TestWaitForClusterCount(t *testing.T) {
+	names := []string{
+		"outbound|80||a.default.svc.cluster.local",
+		"outbound|80||b.default.svc.cluster.local",
+		"outbound|80||c.default.svc.cluster.local",
+	}
+
+	// Each fetch returns one more cluster than the previous one.
+	fetches := 0
+	fetch := func() (*envoyAdmin.ConfigDump, error) {
+		fetches++
+		var clusters []*cluster.Cluster
+		for _, name := range names[:fetches] {
+			clusters = append(clusters, &cluster.Cluster{Name: name})
+		}
+		return configDump(t, clustersDump(t, clusters...)), nil
+	}
+
+	if err := WaitForClusterCount(fetch, len(names), retry.Delay(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != len(names) {
+		t.Fatalf("expected %d fetches, got %d", len(names), fetches)
+	}
+}
+
+func TestWaitForDeploymentConfig(t *testing.T) {
+	// Each proxy gets the second endpoint after its own delay, or never for a negative delay.
+	start := time.Now()
+	staggered := func(delays ...time.Duration) []ConfigFetchFunc {
+		var fetchers []ConfigFetchFunc
+		for _, delay := range delays {
+			delay := delay
+			fetchers = append(fetchers, func() (*envoyAdmin.ConfigDump, error) {
+				if delay >= 0 && time.Since(start) >= delay {
+					return endpointsConfigDump(t, "10.0.0.1", "10.0.0.2"), nil
+				}
+				return endpointsConfigDump(t, "10.0.0.1"), nil
+			})
+		}
+		return fetchers
+	}
+
+	t.Run("converged", func(t *testing.T) {
+		fetchers := staggered(0, 20*time.Millisecond, 50*time.Millisecond)
+		if err := WaitForDeploymentConfig(fetchers, HasEndpointCount(testClusterName, 2).Accept(), time.Now().Add(5*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("laggards", func(t *testing.T) {
+		fetchers := staggered(0, -1, 20*time.Millisecond, -1)
+		err := WaitForDeploymentConfig(fetchers, HasEndpointCount(testClusterName, 2).Accept(), time.Now().Add(200*time.Millisecond))
+		if err == nil {
+			t.Fatal("expected the wait to fail")
+		}
+		if !strings.HasPrefix(err.Error(), "proxies 1, 3 of 4 did not converge") {
+			t.Fatalf("expected proxies 1 and 3 to be reported, got: %v", err)
+		}
+		if strings.Contains(err.Error(), "proxy 0:") || strings.Contains(err.Error(), "proxy 2:") {
+			t.Fatalf("expected only the laggards to be reported, got: %v", err)
+		}
+	})
+}
+
+func TestWaitForConfigStable(t *testing.T) {
+	ips := []string{"10.0.0.1", "10.0.0.2"}
+
+	// The config flaps between 2 and 1 endpoints before settling on 2.
+	counts := []int{2, 1, 2, 1}
+	fetches := 0
+	fetch := func() (*envoyAdmin.ConfigDump, error) {
+		count := 2
+		if fetches < len(counts) {
+			count = counts[fetches]
+		}
+		fetches++
+		return endpointsConfigDump(t, ips[:count]...), nil
+	}
+
+	stableFor := 20 * time.Millisecond
+	start := time.Now()
+	cfg, err := WaitForConfigStable(fetch, HasEndpointCount(testClusterName, 2).Accept(), stableFor, retry.Delay(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches <= len(counts) {
+		t.Fatalf("expected the wait to outlast the flapping, got %d fetches", fetches)
+	}
+	if elapsed := time.Since(start); elapsed < stableFor {
+		t.Fatalf("expected to wait at least %v, waited %v", stableFor, elapsed)
+	}
+	if accepted, err := HasEndpointCount(testClusterName, 2).Accept()(cfg); err != nil || !accepted {
+		t.Fatalf("expected the final config to be accepted, got accepted=%v err=%v", accepted, err)
+	}
+}
+
+func TestWaitForConfigComparing(t *testing.T) {
+	// The baseline had two endpoints, but the proxy only ever gets one of them.
+	prev := endpointsConfigDump(t, "10.0.0.1", "10.0.0.2")
+	fetch := func() (*envoyAdmin.ConfigDump, error) {
+		return endpointsConfigDump(t, "10.0.0.1"), nil
+	}
+
+	rejected := func(*envoyAdmin.ConfigDump) (bool, error) {
+		return false, nil
+	}
+	cases := []struct {
+		name   string
+		accept ConfigAcceptFunc
+	}{
+		{"timeout", HasEndpointCount(testClusterName, 2).Accept()},
+		{"rejected", rejected},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := WaitForConfigComparing(fetch, tt.accept, prev,
+				retry.Delay(time.Millisecond), retry.Timeout(50*time.Millisecond))
+			if err == nil {
+				t.Fatal("expected the wait to fail")
+			}
+			if !strings.Contains(err.Error(), "-previous +current") {
+				t.Fatalf("expected a diff against the previous config dump, got: %v", err)
+			}
+			// The missing endpoint only appears in the baseline, so it can only be reported by the diff.
+			if !strings.Contains(err.Error(), "10.0.0.2") {
+				t.Fatalf("expected the diff to show the missing endpoint, got: %v", err)
+			}
+		})
+	}
+
+	// An unchanged config is reported as such.
+	err := WaitForConfigComparing(fetch, rejected, endpointsConfigDump(t, "10.0.0.1"), retry.Delay(time.Millisecond))
+	if err == nil || !strings.Contains(err.Error(), "config_dump unchanged from previous") {
+		t.Fatalf("expected the config dump to be reported unchanged, got: %v", err)
+	}
+
+	// An accepted config is not affected by the comparison.
+	if err := WaitForConfigComparing(fetch, HasEndpointCount(testClusterName, 1).Accept(), prev, retry.Delay(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForConfigWithEvidence(t *testing.T) {
+	ips := []string{"10.0.0.1", "10.0.0.2"}
+
+	// Each fetch returns one more endpoint than the previous one, until all of them are returned.
+	fetches := 0
+	fetch := func() (*envoyAdmin.ConfigDump, error) {
+		if fetches < len(ips) {
+			fetches++
+		}
+		return endpointsConfigDump(t, ips[:fetches]...), nil
+	}
+
+	evidence, err := WaitForConfigWithEvidence(fetch, EndpointCountEvidence(testClusterName, len(ips)), retry.Delay(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evidence == nil {
+		t.Fatal("expected evidence for the accepted config")
+	}
+	if evidence.Cluster != testClusterName {
+		t.Fatalf("expected evidence for cluster %s, got %s", testClusterName, evidence.Cluster)
+	}
+	want := []string{"10.0.0.1:8080", "10.0.0.2:8080"}
+	if strings.Join(evidence.Endpoints, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected evidence for endpoints %v, got %v", want, evidence.Endpoints)
+	}
+	if got := evidence.String(); !strings.Contains(got, testClusterName) || !strings.Contains(got, "10.0.0.2:8080") {
+		t.Fatalf("expected the evidence to describe the match, got %q", got)
+	}
+
+	// A failed wait has no evidence.
+	evidence, err = WaitForConfigWithEvidence(fetch, EndpointCountEvidence(testClusterName, 3),
+		retry.Delay(time.Millisecond), retry.Timeout(50*time.Millisecond))
+	if err == nil || evidence != nil {
+		t.Fatalf("expected the wait to fail without evidence, got evidence=%v err=%v", evidence, err)
+	}
+}
+
+func TestPilotConfigFetcher(t *testing.T) {
+	const proxyID = "a-1234.default"
+	body, err := protomarshal.Marshal(endpointsConfigDump(t, "10.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/config_dump" || r.URL.Query().Get("proxyID") != proxyID {
+			http.Error(w, "unknown proxy", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	t.Run("found", func(t *testing.T) {
+		cfg, err := PilotConfigFetcher(srv.URL, proxyID)()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accepted, err := HasEndpointCount(testClusterName, 1).Accept()(cfg); err != nil || !accepted {
+			t.Fatalf("expected config to be accepted, got accepted=%v err=%v", accepted, err)
+		}
+	})
+
+	t.Run("unknown proxy", func(t *testing.T) {
+		if _, err := PilotConfigFetcher(srv.URL, "other.default")(); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestCircuitBreakingFetcher(t *testing.T) {
+	calls := 0
+	fail := true
+	fetch := CircuitBreakingFetcher(func() (*envoyAdmin.ConfigDump, error) {
+		calls++
+		if fail {
+			return nil, errors.New("connection refused")
+		}
+		return &envoyAdmin.ConfigDump{}, nil
+	}, 2, 100*time.Millisecond)
+
+	// Trip the breaker.
+	for i := 0; i < 2; i++ {
+		if _, err := fetch(); err == nil {
+			t.Fatal("expected fetch error")
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", calls)
+	}
+
+	// While open, fetches fail without reaching the inner fetcher.
+	if _, err := fetch(); err == nil {
+		t.Fatal("expected fetch error while circuit is open")
+	}
+	if calls != 2 {
+		t.Fatalf("expected the open circuit to skip the inner fetcher, got %d calls", calls)
+	}
+
+	// After the cooldown, a failed attempt reopens the circuit immediately.
+	time.Sleep(150 * time.Millisecond)
+	if _, err := fetch(); err == nil {
+		t.Fatal("expected fetch error")
+	}
+	if _, err := fetch(); err == nil {
+		t.Fatal("expected fetch error while circuit is open")
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+
+	// A successful attempt closes the circuit.
+	fail = false
+	time.Sleep(150 * time.Millisecond)
+	if _, err := fetch(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fetch(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 5 {
+		t.Fatalf("expected 5 calls, got %d", calls)
+	}
+}
+
+func TestCircuitBreakingFetcherSingleProbe(t *testing.T) {
+	var calls int32
+	probing := make(chan struct{})
+	release := make(chan struct{})
+	fetch := CircuitBreakingFetcher(func() (*envoyAdmin.ConfigDump, error) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			// Block the probe until the concurrent fetch has been attempted.
+			probing <- struct{}{}
+			<-release
+		}
+		return nil, errors.New("connection refused")
+	}, 1, 50*time.Millisecond)
+
+	// Trip the breaker and wait for the cooldown.
+	if _, err := fetch(); err == nil {
+		t.Fatal("expected fetch error")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Start the probe, then fetch concurrently while it is in flight.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = fetch()
+	}()
+	<-probing
+	if _, err := fetch(); err == nil {
+		t.Fatal("expected fetch error while the probe is in flight")
+	}
+	close(release)
+	<-done
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("expected a single probe to reach the inner fetcher, got %d calls", got)
+	}
+}
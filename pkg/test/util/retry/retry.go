@@ -17,6 +17,7 @@ package retry
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"time"
 
 	"istio.io/istio/pkg/test"
@@ -45,6 +46,7 @@ type config struct {
 	timeout  time.Duration
 	delay    time.Duration
 	delayMax time.Duration
+	jitter   float64
 	converge int
 }
 
@@ -74,6 +76,22 @@ func BackoffDelay(delay time.Duration) Option {
 	}
 }
 
+// Jitter randomizes each delay between successive retry attempts by up to the given fraction of the delay
+// (e.g. 0.2 for +/-20%). This avoids many callers polling in lockstep. The fraction should be in [0, 1].
+func Jitter(fraction float64) Option {
+	return func(cfg *config) {
+		cfg.jitter = fraction
+	}
+}
+
+// jitterDelay returns a delay picked uniformly between delay*(1-fraction) and delay*(1+fraction).
+func jitterDelay(delay time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return delay
+	}
+	return time.Duration(float64(delay) * (1 + fraction*(2*rand.Float64()-1)))
+}
+
 // Converge sets the number of successes in a row needed to count a success.
 // This is useful to avoid the case where tests like `coin.Flip() == HEADS` will always
 // return success due to random variance.
@@ -195,7 +213,7 @@ func Do(fn RetriableFunc, options ...Option) (interface{}, error) {
 				convergeStr = fmt.Sprintf(", %d/%d successes", successes, cfg.converge)
 			}
 			return nil, fmt.Errorf("timeout while waiting after %d attempts%s (last error: %v)", attempts, convergeStr, lasterr)
-		case <-time.After(delay):
+		case <-time.After(jitterDelay(delay, cfg.jitter)):
 			delay = cfg.delay * 2
 			if delay > cfg.delayMax {
 				delay = cfg.delayMax
@@ -49,3 +49,26 @@ func TestConverge(t *testing.T) {
 		}
 	})
 }
+
+func TestJitter(t *testing.T) {
+	delay := 100 * time.Millisecond
+	fraction := 0.2
+	low := time.Duration(float64(delay) * (1 - fraction))
+	high := time.Duration(float64(delay) * (1 + fraction))
+
+	seen := make(map[time.Duration]struct{})
+	for i := 0; i < 100; i++ {
+		d := jitterDelay(delay, fraction)
+		if d < low || d > high {
+			t.Fatalf("delay %v outside of expected range [%v, %v]", d, low, high)
+		}
+		seen[d] = struct{}{}
+	}
+	if len(seen) < 2 {
+		t.Fatalf("expected delays to vary, got %v", seen)
+	}
+
+	if d := jitterDelay(delay, 0); d != delay {
+		t.Fatalf("expected delay %v without jitter, got %v", delay, d)
+	}
+}